@@ -0,0 +1,108 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/client"
+)
+
+// collectArtifacts copies the files matching the Executor's Artifacts
+// patterns out of the container cID.
+func (e *Executor) collectArtifacts(ctx context.Context, cID string) (FileSet, error) {
+	var files memFiles
+	seen := make(map[string]bool)
+	for _, pattern := range e.Artifacts {
+		pattern = path.Clean("/" + pattern)
+		src := globRoot(pattern)
+		rc, _, err := e.cli.CopyFromContainer(ctx, cID, src)
+		if client.IsErrNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// entries are named relative to the parent of src
+		dir := path.Dir(src)
+		tr := tar.NewReader(rc)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+			full := path.Join(dir, hdr.Name)
+			if hdr.Typeflag != tar.TypeReg || seen[full] || !matchArtifact(pattern, full) {
+				continue
+			}
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				rc.Close()
+				return nil, err
+			}
+			seen[full] = true
+			files = append(files, memFile{
+				path: strings.TrimPrefix(full, "/"),
+				data: string(b),
+				mode: hdr.FileInfo().Mode(),
+			})
+		}
+		rc.Close()
+	}
+	return files, nil
+}
+
+// globRoot returns the longest leading directory of pattern
+// that contains no wildcards.
+func globRoot(pattern string) string {
+	elems := strings.Split(pattern, "/")
+	for i, elem := range elems {
+		if strings.ContainsAny(elem, `*?[\`) {
+			if i <= 1 {
+				return "/"
+			}
+			return strings.Join(elems[:i], "/")
+		}
+	}
+	return pattern
+}
+
+// matchArtifact reports whether the file at name, or any directory
+// containing it, matches pattern.
+func matchArtifact(pattern, name string) bool {
+	for ; name != "/" && name != "."; name = path.Dir(name) {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
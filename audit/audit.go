@@ -0,0 +1,219 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package audit records what each execution ran, and under which limits,
+// to a Sink, so that the untrusted code an eggsy service has run can be
+// reviewed:
+//
+//	sink, err := audit.NewFileSink("/var/log/eggsy/audit.jsonl")
+//	...
+//	res, err := audit.Execute(ctx, sink, tenant, e)
+//
+// A Sink of a message queue, such as Kafka, is written as a SinkFunc
+// that produces each Record with the queue's client.
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/smasher164/eggsy"
+)
+
+// Record describes an execution. Its durations are encoded in JSON as
+// nanoseconds.
+type Record struct {
+	// Time is when the execution started.
+	Time time.Time `json:"time"`
+
+	// Tenant identifies whom the execution was for.
+	Tenant string `json:"tenant,omitempty"`
+
+	// Files is the SHA-256 digest of the Executor's Files, and
+	// Dockerfile the digest of its Dockerfile, as "sha256:" and the
+	// hexadecimal digest. Image is the image it was created from, if
+	// it wasn't built.
+	Files      string `json:"files,omitempty"`
+	Dockerfile string `json:"dockerfile,omitempty"`
+	Image      string `json:"image,omitempty"`
+
+	// Cmd and Args are the command that was executed.
+	Cmd  string   `json:"cmd,omitempty"`
+	Args []string `json:"args,omitempty"`
+
+	// Limits are the limits the command was executed under.
+	Limits Limits `json:"limits"`
+
+	// Status is how the command finished, and ExitCode its exit code.
+	Status   eggsy.Status `json:"status"`
+	ExitCode int          `json:"exitCode"`
+
+	// Verdict is the judgement of the command's output, for callers
+	// that judge it, such as with package judge.
+	Verdict string `json:"verdict,omitempty"`
+
+	// Duration is the wall-clock time the command ran for, and CPU and
+	// Usage the resources it used, if the backend reports them.
+	Duration time.Duration `json:"duration"`
+	CPU      time.Duration `json:"cpu,omitempty"`
+	Usage    *eggsy.Usage  `json:"usage,omitempty"`
+
+	// Error is the error the execution failed with.
+	Error string `json:"error,omitempty"`
+}
+
+// Limits are the limits of an Executor that a Record describes. Their
+// fields have the meaning of the Executor's fields of the same name,
+// but for Seccomp, which is the digest of its seccomp profile.
+type Limits struct {
+	Timeout         time.Duration `json:"timeout"`
+	CPUTimeLimit    time.Duration `json:"cpuTimeLimit,omitempty"`
+	Memory          int64         `json:"memory,omitempty"`
+	PidsLimit       int64         `json:"pidsLimit,omitempty"`
+	DiskQuota       int64         `json:"diskQuota,omitempty"`
+	Net             string        `json:"net"`
+	AllowHosts      []string      `json:"allowHosts,omitempty"`
+	Runtime         eggsy.Runtime `json:"runtime,omitempty"`
+	Backend         string        `json:"backend,omitempty"`
+	Seccomp         string        `json:"seccomp,omitempty"`
+	CapAdd          []string      `json:"capAdd,omitempty"`
+	CapDrop         []string      `json:"capDrop,omitempty"`
+	NoNewPrivileges bool          `json:"noNewPrivileges,omitempty"`
+	ReadOnlyRootFS  bool          `json:"readOnlyRootFS,omitempty"`
+}
+
+// NewRecord returns a Record of e's execution for tenant, before it has
+// executed. It reads e's Files to digest them.
+func NewRecord(tenant string, e *eggsy.Executor) (*Record, error) {
+	files, err := digestFiles(e.Files)
+	if err != nil {
+		return nil, err
+	}
+	r := &Record{
+		Time:   time.Now(),
+		Tenant: tenant,
+		Files:  files,
+		Image:  e.Image,
+		Cmd:    e.Cmd,
+		Args:   e.Args,
+		Limits: Limits{
+			Timeout:         e.Timeout,
+			CPUTimeLimit:    e.CPUTimeLimit,
+			Memory:          e.Memory,
+			PidsLimit:       e.PidsLimit,
+			DiskQuota:       e.DiskQuota,
+			Net:             e.Net.String(),
+			AllowHosts:      e.AllowHosts,
+			Runtime:         e.Runtime,
+			CapAdd:          e.CapAdd,
+			CapDrop:         e.CapDrop,
+			NoNewPrivileges: e.NoNewPrivileges,
+			ReadOnlyRootFS:  e.ReadOnlyRootFS,
+		},
+	}
+	if e.Dockerfile != "" {
+		r.Dockerfile = digest([]byte(e.Dockerfile))
+	}
+	if e.Seccomp != "" {
+		r.Limits.Seccomp = digest([]byte(e.Seccomp))
+	}
+	if e.Backend != nil {
+		r.Limits.Backend = fmt.Sprintf("%T", e.Backend)
+	}
+	return r, nil
+}
+
+// Finish records the result of the execution, as returned by Execute.
+func (r *Record) Finish(res *eggsy.ExecResult, err error) {
+	r.Status = eggsy.StatusOf(err)
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if res != nil {
+		r.Time = res.Started
+		r.Status = res.Status
+		r.ExitCode = res.ExitCode
+		r.Duration = res.Duration()
+		if res.Usage != nil {
+			r.CPU = res.Usage.CPU()
+			r.Usage = res.Usage
+		}
+	}
+}
+
+// Execute executes e for tenant, and writes a Record of the execution
+// to s once it has finished. If the Record can't be written, Execute
+// returns the Sink's error along with the result, unless the execution
+// failed.
+func Execute(ctx context.Context, s Sink, tenant string, e *eggsy.Executor) (*eggsy.ExecResult, error) {
+	r, err := NewRecord(tenant, e)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.Execute(ctx)
+	r.Finish(res, err)
+	if werr := s.Write(context.WithoutCancel(ctx), r); err == nil {
+		err = werr
+	}
+	return res, err
+}
+
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// digestFiles returns the digest of the paths, modes, and contents of
+// files, which doesn't depend on their order.
+func digestFiles(files eggsy.FileSet) (string, error) {
+	if files == nil {
+		return "", nil
+	}
+	sums := make([]string, files.Len())
+	for i := range sums {
+		f, err := files.At(i)
+		if err != nil {
+			return "", err
+		}
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00%o\x00%s\x00", f.Path, f.Mode, f.Linkname)
+		if f.ReadCloser != nil {
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+		sums[i] = hex.EncodeToString(h.Sum(nil))
+	}
+	sort.Strings(sums)
+	h := sha256.New()
+	for _, s := range sums {
+		io.WriteString(h, s)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,134 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package audit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smasher164/eggsy"
+)
+
+func TestExecuteRecord(t *testing.T) {
+	denied := errors.New("denied by policy")
+	e := &eggsy.Executor{
+		Dockerfile:      "FROM alpine",
+		Files:           eggsy.MapFileSet(map[string][]byte{"main.c": []byte("int main;")}),
+		Cmd:             "./main",
+		Timeout:         3 * time.Second,
+		CPUTimeLimit:    2 * time.Second,
+		Memory:          64 << 20,
+		PidsLimit:       32,
+		Net:             eggsy.NetNone,
+		CapDrop:         []string{"ALL"},
+		NoNewPrivileges: true,
+		Seccomp:         `{"defaultAction":"SCMP_ACT_ERRNO"}`,
+		Policy:          eggsy.PolicyFunc(func(*eggsy.Executor) error { return denied }),
+	}
+	var records []*Record
+	sink := SinkFunc(func(ctx context.Context, r *Record) error {
+		if ctx.Err() != nil {
+			t.Error("record written with a done context")
+		}
+		records = append(records, r)
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Execute(ctx, sink, "acme", e); !errors.Is(err, denied) {
+		t.Fatalf("Execute = %v, want the policy's error", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("wrote %d records, want 1", len(records))
+	}
+	r := records[0]
+	files, _ := digestFiles(eggsy.MapFileSet(map[string][]byte{"main.c": []byte("int main;")}))
+	for _, f := range []struct{ name, got, want string }{
+		{"Tenant", r.Tenant, "acme"},
+		{"Files", r.Files, files},
+		{"Dockerfile", r.Dockerfile, digest([]byte("FROM alpine"))},
+		{"Image", r.Image, ""},
+		{"Cmd", r.Cmd, "./main"},
+		{"Net", r.Limits.Net, eggsy.NetNone.String()},
+		{"Seccomp", r.Limits.Seccomp, digest([]byte(e.Seccomp))},
+		{"Status", string(r.Status), string(eggsy.StatusError)},
+		{"Error", r.Error, denied.Error()},
+	} {
+		if f.got != f.want {
+			t.Errorf("%s = %q, want %q", f.name, f.got, f.want)
+		}
+	}
+	if !strings.HasPrefix(r.Files, "sha256:") || len(r.Files) != len("sha256:")+64 {
+		t.Errorf("Files = %q, want a SHA-256 digest", r.Files)
+	}
+	l := r.Limits
+	if l.Timeout != e.Timeout || l.CPUTimeLimit != e.CPUTimeLimit || l.Memory != e.Memory || l.PidsLimit != e.PidsLimit ||
+		len(l.CapDrop) != 1 || !l.NoNewPrivileges {
+		t.Errorf("Limits = %+v, want those of the Executor", l)
+	}
+	if r.Time.IsZero() || r.Duration != 0 || r.Usage != nil {
+		t.Errorf("record of an execution that didn't run has time %v, duration %v, usage %v", r.Time, r.Duration, r.Usage)
+	}
+}
+
+func TestRecordFinish(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := &eggsy.ExecResult{
+		Status:   eggsy.StatusOOMKilled,
+		ExitCode: 137,
+		Started:  start,
+		Finished: start.Add(1500 * time.Millisecond),
+		Usage:    &eggsy.Usage{MaxMemory: 64 << 20, CPUUser: time.Second, CPUSystem: 200 * time.Millisecond},
+	}
+	r := new(Record)
+	r.Finish(res, nil)
+	if !r.Time.Equal(start) || r.Status != eggsy.StatusOOMKilled || r.ExitCode != 137 ||
+		r.Duration != 1500*time.Millisecond || r.CPU != 1200*time.Millisecond || r.Usage != res.Usage || r.Error != "" {
+		t.Errorf("Finish recorded %+v", r)
+	}
+
+	r = new(Record)
+	r.Finish(&eggsy.ExecResult{Status: eggsy.StatusTimeout, ExitCode: 137, TimedOut: true}, &eggsy.TimeoutError{Cmd: "sleep 9"})
+	if r.Status != eggsy.StatusTimeout || r.Error == "" {
+		t.Errorf("Finish of a timeout recorded status %v and error %q", r.Status, r.Error)
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	var n int
+	count := SinkFunc(func(context.Context, *Record) error { n++; return nil })
+	fail := func(err error) Sink {
+		return SinkFunc(func(context.Context, *Record) error { n++; return err })
+	}
+	s := MultiSink(count, fail(errFirst), fail(errSecond), count)
+	if err := s.Write(context.Background(), new(Record)); err != errFirst {
+		t.Errorf("Write = %v, want %v", err, errFirst)
+	}
+	if n != 4 {
+		t.Errorf("wrote to %d sinks, want every one of 4", n)
+	}
+}
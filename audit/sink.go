@@ -0,0 +1,132 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Sink stores Records. Implementations must be safe for concurrent use.
+type Sink interface {
+	Write(ctx context.Context, r *Record) error
+}
+
+// SinkFunc is a Sink that calls the function with each Record.
+type SinkFunc func(ctx context.Context, r *Record) error
+
+// Write implements Sink.
+func (f SinkFunc) Write(ctx context.Context, r *Record) error { return f(ctx, r) }
+
+// MultiSink returns a Sink that writes each Record to every one of
+// sinks, and returns the first error they return.
+func MultiSink(sinks ...Sink) Sink {
+	return SinkFunc(func(ctx context.Context, r *Record) error {
+		var first error
+		for _, s := range sinks {
+			if err := s.Write(ctx, r); err != nil && first == nil {
+				first = err
+			}
+		}
+		return first
+	})
+}
+
+// FileSink is a Sink that appends each Record to a file as a line of
+// JSON.
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink opens the file at path for appending, creating it if it
+// doesn't exist.
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+// Write implements Sink.
+func (s *FileSink) Write(ctx context.Context, r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(b, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error { return s.f.Close() }
+
+// WebhookSink is a Sink that posts each Record as JSON to a URL.
+type WebhookSink struct {
+	// URL is the URL Records are posted to.
+	URL string
+
+	// Header holds headers added to each request, such as to
+	// authenticate it.
+	Header http.Header
+
+	// Client sends the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Write implements Sink. It fails unless the response has a 2xx status.
+func (s *WebhookSink) Write(ctx context.Context, r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("eggsy: audit webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/docker/docker/client"
+)
+
+// ErrNoDaemon is returned when an Executor whose Backend has no daemon,
+// such as BackendWASM or an NsjailBackend, is used by anything but
+// Execute.
+var ErrNoDaemon = errors.New("eggsy: backend has no daemon")
+
+// Backend is a container engine that builds images and runs containers.
+// Engines are driven through the Docker Engine API, which Podman serves
+// as well as Docker. BackendWASM and NsjailBackend have no engine, and
+// run the command from the calling process instead.
+type Backend interface {
+	// ClientOpts returns the options of a client connected to the engine.
+	ClientOpts() ([]func(*client.Client) error, error)
+}
+
+// DockerBackend is the Docker daemon.
+type DockerBackend struct {
+	// Context is the name of a context configured with `docker context`
+	// whose daemon is used. If empty, the daemon is chosen by the
+	// DOCKER_HOST, DOCKER_CERT_PATH, DOCKER_TLS_VERIFY, and
+	// DOCKER_API_VERSION environment variables.
+	Context string
+}
+
+// ClientOpts implements Backend.
+func (b DockerBackend) ClientOpts() ([]func(*client.Client) error, error) {
+	opts := []func(*client.Client) error{client.FromEnv}
+	if b.Context != "" && b.Context != "default" {
+		copts, err := contextOpts(b.Context)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, copts...)
+	}
+	return opts, nil
+}
+
+// PodmanBackend is a Podman API service, as started by
+// `podman system service` or the podman.socket systemd unit. Rootless
+// Podman may not be able to use RuntimeRunsc, in which case the
+// Executor's Runtime must name one of its configured OCI runtimes.
+type PodmanBackend struct {
+	// Socket is the path of the service's Unix socket. If empty, it is
+	// taken from the CONTAINER_HOST environment variable, or else is
+	// the default socket of the current user: /run/podman/podman.sock
+	// for root, and $XDG_RUNTIME_DIR/podman/podman.sock otherwise.
+	Socket string
+}
+
+// ClientOpts implements Backend.
+func (b PodmanBackend) ClientOpts() ([]func(*client.Client) error, error) {
+	host := "unix://" + b.Socket
+	if b.Socket == "" {
+		host = podmanHost()
+	}
+	return []func(*client.Client) error{client.WithHost(host)}, nil
+}
+
+// podmanHost returns the address of the current user's Podman service.
+func podmanHost() string {
+	if host := os.Getenv("CONTAINER_HOST"); host != "" {
+		return host
+	}
+	uid := os.Getuid()
+	if uid == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join("/run/user", strconv.Itoa(uid))
+	}
+	return "unix://" + filepath.Join(dir, "podman", "podman.sock")
+}
+
+// extractFiles writes the Executor's Files, except for the entry named
+// by StdinPath and symbolic links, into dir.
+func (e *Executor) extractFiles(dir string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := e.writeFiles(tw, e.Files); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the names were cleaned by writeFiles
+		name := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, os.FileMode(hdr.Mode).Perm()|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode).Perm()|0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// countWriter counts the bytes written to it.
+type countWriter struct{ n int64 }
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
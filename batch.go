@@ -0,0 +1,235 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrMixedBatch is returned by ExecuteAll for Jobs that don't share
+// their Dockerfile and Image.
+var ErrMixedBatch = errors.New("eggsy: jobs of a batch must share their Dockerfile and Image")
+
+// BatchOptions configures ExecuteAll.
+type BatchOptions struct {
+	// MaxParallel limits the number of Jobs executing at once. Zero
+	// means any number.
+	MaxParallel int
+
+	// Options configure the Executor of each Job, which has the Job's
+	// fields, as the options of NewRunner do.
+	Options []func(*Executor)
+
+	// StopOnError stops executing Jobs once one fails with an error or
+	// exits with a non-zero code. The Jobs that weren't executed have
+	// a result whose Err is ErrSkipped.
+	StopOnError bool
+
+	// Budget and CPUBudget, if positive, limit the wall-clock time of
+	// executing the Jobs, from after the image is built, and the CPU
+	// time their containers may use in total. Once either is used up,
+	// the Jobs that are executing are canceled, and they and the Jobs
+	// that weren't executed have a result whose Err is
+	// ErrBudgetExceeded. The CPU time is sampled about once a second.
+	Budget    time.Duration
+	CPUBudget time.Duration
+}
+
+// ErrSkipped is the error of a Job that ExecuteAll didn't execute,
+// because an earlier Job failed and StopOnError was set.
+var ErrSkipped = errors.New("eggsy: job skipped")
+
+// ErrBudgetExceeded is the error of an execution that was canceled, or
+// never started, because its batch or Steps used up their budget.
+var ErrBudgetExceeded = errors.New("eggsy: budget exceeded")
+
+// BatchResult is the outcome of a Job executed by ExecuteAll: the
+// result and error that Execute would have returned for it.
+type BatchResult struct {
+	Result *ExecResult
+	Err    error
+}
+
+// ExecuteAll builds the image of the first of jobs once, and executes
+// each Job in a container of its own created from it, with at most
+// opts.MaxParallel executing at once. It suits running a program against
+// many inputs, such as the test cases of a problem, which differ only
+// in their Cmd, Args, Stdin, and output.
+//
+// The Jobs must share their Dockerfile and Image, and the Files of the
+// first Job are those of every container; the Files of the others are
+// ignored. Backends other than Docker have no image to share, so each
+// Job is executed with all of its fields. ExecuteAll returns an error
+// only if the image can't be built, and otherwise returns the outcome of
+// each Job at the same index.
+func ExecuteAll(ctx context.Context, jobs []Job, opts BatchOptions) ([]BatchResult, error) {
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	for _, j := range jobs[1:] {
+		if j.Dockerfile != jobs[0].Dockerfile || j.Image != jobs[0].Image {
+			return nil, ErrMixedBatch
+		}
+	}
+	b := jobs[0].executor(opts.Options)
+	run := func(ctx context.Context, e *Executor) (*ExecResult, error) { return e.Execute(ctx) }
+	switch b.Backend.(type) {
+	case WASMBackend, NsjailBackend:
+		// there is no image to share, so each Job is executed whole
+	default:
+		ref, err := b.Build(ctx)
+		defer b.Close()
+		if err != nil {
+			return nil, err
+		}
+		defer b.Remove(context.Background(), ref)
+		run = func(ctx context.Context, e *Executor) (*ExecResult, error) { return e.Run(ctx, ref) }
+	}
+
+	results := make([]BatchResult, len(jobs))
+	var sem chan struct{}
+	if opts.MaxParallel > 0 {
+		sem = make(chan struct{}, opts.MaxParallel)
+	}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+	bctx, bud := newBudget(ctx, opts.Budget, opts.CPUBudget)
+	defer bud.stop()
+	for i := range jobs {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-bctx.Done():
+			}
+		}
+		mu.Lock()
+		stop := failed || bctx.Err() != nil
+		mu.Unlock()
+		if stop {
+			if sem != nil && bctx.Err() == nil {
+				<-sem
+			}
+			for ; i < len(jobs); i++ {
+				results[i].Err = ErrSkipped
+				if ctx.Err() != nil {
+					results[i].Err = ctx.Err()
+				} else if bud.exceeded() {
+					results[i].Err = ErrBudgetExceeded
+				}
+			}
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			e := jobs[i].executor(opts.Options)
+			e.onUsage = func(u *Usage) { bud.use(i, u.CPU()) }
+			res, err := run(bctx, e)
+			if res != nil && res.Usage != nil {
+				bud.use(i, res.Usage.CPU())
+			}
+			if ctx.Err() == nil && bud.exceeded() && StatusOf(err) == StatusCanceled {
+				err = ErrBudgetExceeded
+			}
+			results[i] = BatchResult{Result: res, Err: err}
+			if opts.StopOnError && (err != nil || res == nil || res.ExitCode != 0) {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// budget cancels the context of the executions of a batch or Steps once
+// they have used up their wall-clock or CPU time.
+type budget struct {
+	cpu    time.Duration
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	mu   sync.Mutex
+	used map[int]time.Duration // CPU time used by each execution
+	over bool
+}
+
+// newBudget returns a budget of wall and cpu time, either of which is
+// unlimited if it isn't positive, and the context that it cancels.
+func newBudget(ctx context.Context, wall, cpu time.Duration) (context.Context, *budget) {
+	ctx, cancel := context.WithCancel(ctx)
+	b := &budget{cpu: cpu, cancel: cancel, used: make(map[int]time.Duration)}
+	if wall > 0 {
+		b.timer = time.AfterFunc(wall, b.exceed)
+	}
+	return ctx, b
+}
+
+// use records that the execution i has used cpu time so far.
+func (b *budget) use(i int, cpu time.Duration) {
+	if b.cpu <= 0 {
+		return
+	}
+	b.mu.Lock()
+	b.used[i] = cpu
+	var total time.Duration
+	for _, d := range b.used {
+		total += d
+	}
+	b.mu.Unlock()
+	if total > b.cpu {
+		b.exceed()
+	}
+}
+
+func (b *budget) exceed() {
+	b.mu.Lock()
+	b.over = true
+	b.mu.Unlock()
+	b.cancel()
+}
+
+// exceeded reports whether the budget has been used up.
+func (b *budget) exceeded() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.over
+}
+
+// stop releases the budget's timer and context.
+func (b *budget) stop() {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	b.cancel()
+}
@@ -0,0 +1,72 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBudgetWall(t *testing.T) {
+	ctx, b := newBudget(context.Background(), 10*time.Millisecond, 0)
+	defer b.stop()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context wasn't canceled once the budget was used up")
+	}
+	if !b.exceeded() {
+		t.Error("budget isn't exceeded after its time")
+	}
+	if s := StatusOf(ErrBudgetExceeded); s != StatusBudgetExceeded {
+		t.Errorf("StatusOf(ErrBudgetExceeded) = %q, want %q", s, StatusBudgetExceeded)
+	}
+}
+
+func TestBudgetCPU(t *testing.T) {
+	ctx, b := newBudget(context.Background(), 0, 3*time.Second)
+	defer b.stop()
+	// later samples of an execution replace its earlier ones
+	b.use(0, time.Second)
+	b.use(0, 2*time.Second)
+	b.use(1, time.Second)
+	if b.exceeded() || ctx.Err() != nil {
+		t.Fatal("budget exceeded by 3s of CPU time, want at most 3s")
+	}
+	b.use(1, 2*time.Second)
+	if !b.exceeded() || ctx.Err() == nil {
+		t.Error("budget not exceeded by 4s of CPU time")
+	}
+}
+
+func TestBudgetStop(t *testing.T) {
+	ctx, b := newBudget(context.Background(), time.Hour, time.Second)
+	b.stop()
+	if b.exceeded() {
+		t.Error("budget exceeded after it was stopped")
+	}
+	if ctx.Err() == nil {
+		t.Error("context not released by stop")
+	}
+}
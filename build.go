@@ -0,0 +1,304 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// BuildBudget limits the time spent building an image to a multiple of
+// how long builds of the same Dockerfile have taken before, so that a bad
+// Dockerfile can't occupy a shared host. A BuildBudget is safe for
+// concurrent use, and is meant to be shared by the Executors of a service.
+type BuildBudget struct {
+	// Factor is the multiple of the 95th percentile of past build times
+	// that a build may take before it is canceled. The default is 3.
+	Factor float64
+
+	// MinSamples is the number of builds of a Dockerfile that must be
+	// recorded before its builds are limited. The default is 5.
+	MinSamples int
+
+	// History is the number of recent build times kept per Dockerfile.
+	// The default is 100.
+	History int
+
+	mu    sync.Mutex
+	times map[string][]time.Duration
+}
+
+// BuildBudgetError is returned when building an image is canceled
+// because it exceeded its BuildBudget.
+type BuildBudgetError struct {
+	// Dockerfile is the SHA-256 digest of the Dockerfile being built.
+	Dockerfile string
+
+	// P95 is the 95th percentile of past build times, Limit is the
+	// time the build was allowed, and Elapsed is the time it took
+	// before it was canceled.
+	P95     time.Duration
+	Limit   time.Duration
+	Elapsed time.Duration
+}
+
+func (b *BuildBudgetError) Error() string {
+	return fmt.Sprintf("build of Dockerfile %.12s canceled after %v, exceeding its budget of %v", b.Dockerfile, b.Elapsed, b.Limit)
+}
+
+// BuildTimeoutError is returned when building an image is canceled
+// because it took longer than the Executor's BuildTimeout.
+type BuildTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (b *BuildTimeoutError) Error() string {
+	return fmt.Sprintf("build canceled after exceeding its timeout of %v", b.Timeout)
+}
+
+// ImageLimitError is returned when a built image exceeds the Executor's
+// MaxImageSize or MaxImageLayers. The image is removed before it is
+// returned.
+type ImageLimitError struct {
+	// Size is the size of the image in bytes, and Layers its number
+	// of layers.
+	Size   int64
+	Layers int
+
+	// MaxSize and MaxLayers are the Executor's limits.
+	MaxSize   int64
+	MaxLayers int
+}
+
+func (i *ImageLimitError) Error() string {
+	if i.MaxSize > 0 && i.Size > i.MaxSize {
+		return fmt.Sprintf("image of %d bytes exceeds the limit of %d bytes", i.Size, i.MaxSize)
+	}
+	return fmt.Sprintf("image of %d layers exceeds the limit of %d layers", i.Layers, i.MaxLayers)
+}
+
+// limit returns the time a build of the Dockerfile with the given digest
+// is allowed, or 0 if it isn't limited yet.
+func (b *BuildBudget) limit(digest string) (limit, p95 time.Duration) {
+	min, factor := b.MinSamples, b.Factor
+	if min <= 0 {
+		min = 5
+	}
+	if factor <= 0 {
+		factor = 3
+	}
+	b.mu.Lock()
+	ts := append([]time.Duration(nil), b.times[digest]...)
+	b.mu.Unlock()
+	if len(ts) < min {
+		return 0, 0
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+	p95 = ts[(len(ts)*95+99)/100-1]
+	return time.Duration(float64(p95) * factor), p95
+}
+
+// record adds the time of a successful build.
+func (b *BuildBudget) record(digest string, d time.Duration) {
+	n := b.History
+	if n <= 0 {
+		n = 100
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.times == nil {
+		b.times = make(map[string][]time.Duration)
+	}
+	ts := append(b.times[digest], d)
+	if len(ts) > n {
+		ts = ts[len(ts)-n:]
+	}
+	b.times[digest] = ts
+}
+
+func digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// build builds the image tagged tag from the build context bc.
+func (e *Executor) build(ctx context.Context, bc io.Reader, tag string) error {
+	var (
+		dig        string
+		limit, p95 time.Duration
+	)
+	if e.BuildBudget != nil {
+		dig = digest(e.Dockerfile)
+		if limit, p95 = e.BuildBudget.limit(dig); limit > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, limit)
+			defer cancel()
+		}
+	}
+	if e.BuildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.BuildTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	r, err := e.cli.ImageBuild(ctx, bc, types.ImageBuildOptions{
+		Tags:       []string{tag},
+		Platform:   e.Platform,
+		Labels:     e.labels(tag),
+		PullParent: e.PullPolicy == PullAlways,
+		BuildArgs:  e.buildArgs(),
+		Target:     e.Target,
+	})
+	var berr *BuildError
+	if err == nil {
+		// the build runs until its output has been read
+		berr, err = e.readBuild(r.Body)
+		r.Body.Close()
+	}
+	elapsed := time.Since(start)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		switch {
+		case limit > 0 && elapsed >= limit:
+			return &BuildBudgetError{Dockerfile: dig, P95: p95, Limit: limit, Elapsed: elapsed}
+		case e.BuildTimeout > 0 && elapsed >= e.BuildTimeout:
+			return &BuildTimeoutError{Timeout: e.BuildTimeout}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if berr != nil {
+		return berr
+	}
+	if err := e.checkImage(ctx, tag); err != nil {
+		return err
+	}
+	if e.BuildBudget != nil {
+		e.BuildBudget.record(dig, elapsed)
+	}
+	return nil
+}
+
+// checkImage removes the image tag and returns an ImageLimitError if it
+// exceeds the Executor's MaxImageSize or MaxImageLayers.
+func (e *Executor) checkImage(ctx context.Context, tag string) error {
+	if e.MaxImageSize <= 0 && e.MaxImageLayers <= 0 {
+		return nil
+	}
+	img, _, err := e.cli.ImageInspectWithRaw(ctx, tag)
+	if err != nil {
+		return err
+	}
+	n := len(img.RootFS.Layers)
+	if e.MaxImageSize > 0 && img.Size > e.MaxImageSize || e.MaxImageLayers > 0 && n > e.MaxImageLayers {
+		// ctx may already be done by the time the image is removed
+		if _, err := e.cli.ImageRemove(context.Background(), tag, types.ImageRemoveOptions{Force: true}); err != nil {
+			e.logger().Warn("eggsy: removing image failed", "image", tag, "err", err)
+		}
+		return &ImageLimitError{Size: img.Size, Layers: n, MaxSize: e.MaxImageSize, MaxLayers: e.MaxImageLayers}
+	}
+	return nil
+}
+
+func (e *Executor) buildArgs() map[string]*string {
+	if len(e.BuildArgs) == 0 {
+		return nil
+	}
+	args := make(map[string]*string, len(e.BuildArgs))
+	for k, v := range e.BuildArgs {
+		v := v
+		args[k] = &v
+	}
+	return args
+}
+
+// BuildError is returned when the daemon fails to build the image,
+// such as when a step of the Dockerfile fails.
+type BuildError struct {
+	// Step is the step of the Dockerfile that failed, as reported by
+	// the daemon, such as "Step 3/4 : RUN make". It is empty if the
+	// build failed before its first step.
+	Step string
+
+	// Message is the daemon's error message, and Code its error code,
+	// which is often the exit code of the failed command.
+	Message string
+	Code    int
+}
+
+func (b *BuildError) Error() string {
+	if b.Step == "" {
+		return "build failed: " + b.Message
+	}
+	return fmt.Sprintf("build failed at %s: %s", b.Step, b.Message)
+}
+
+// readBuild reads the JSON messages of a build from r, writing its output
+// to BuildOutput. It returns a BuildError if the daemon reports one.
+func (e *Executor) readBuild(r io.Reader) (berr *BuildError, err error) {
+	w := e.BuildOutput
+	if w == nil {
+		w = ioutil.Discard
+	}
+	dec := json.NewDecoder(r)
+	var step string
+	for {
+		var m jsonmessage.JSONMessage
+		if err := dec.Decode(&m); err == io.EOF {
+			return berr, nil
+		} else if err != nil {
+			return berr, err
+		}
+		switch {
+		case m.Error != nil || m.ErrorMessage != "":
+			if berr == nil {
+				berr = &BuildError{Step: step, Message: m.ErrorMessage}
+				if m.Error != nil {
+					berr.Message, berr.Code = m.Error.Message, m.Error.Code
+				}
+				fmt.Fprintln(w, berr.Message)
+			}
+		case m.Stream != "":
+			if strings.HasPrefix(m.Stream, "Step ") {
+				step = strings.TrimSpace(m.Stream)
+			}
+			io.WriteString(w, m.Stream)
+		case m.Status != "" && m.ID != "":
+			fmt.Fprintf(w, "%s: %s\n", m.ID, m.Status)
+		case m.Status != "":
+			fmt.Fprintln(w, m.Status)
+		}
+	}
+}
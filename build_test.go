@@ -0,0 +1,119 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// buildFailure is the output of a build whose third step fails.
+const buildFailure = `{"stream":"Step 1/4 : FROM gcc"}
+{"stream":"\n"}
+{"stream":" ---> 1a2b3c4d\n"}
+{"stream":"Step 2/4 : COPY . ."}
+{"stream":"\n"}
+{"stream":"Step 3/4 : RUN gcc -o main main.c"}
+{"stream":"\n"}
+{"stream":"main.c:1:1: error: expected ';'\n"}
+{"errorDetail":{"code":1,"message":"The command '/bin/sh -c gcc -o main main.c' returned a non-zero code: 1"},"error":"The command '/bin/sh -c gcc -o main main.c' returned a non-zero code: 1"}
+`
+
+func TestReadBuild(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		output string
+		want   *BuildError
+	}{
+		{"ok", `{"stream":"Step 1/1 : FROM gcc"}` + "\n" + `{"stream":"Successfully built 1a2b3c4d\n"}`, nil},
+		{"failed step", buildFailure, &BuildError{
+			Step:    "Step 3/4 : RUN gcc -o main main.c",
+			Message: "The command '/bin/sh -c gcc -o main main.c' returned a non-zero code: 1",
+			Code:    1,
+		}},
+		{"before any step", `{"errorDetail":{"message":"dockerfile parse error line 1: unknown instruction: FORM"},"error":"dockerfile parse error line 1: unknown instruction: FORM"}`, &BuildError{
+			Message: "dockerfile parse error line 1: unknown instruction: FORM",
+		}},
+		{"message alone", `{"stream":"Step 1/2 : FROM nope"}` + "\n" + `{"error":"pull access denied for nope"}`, &BuildError{
+			Step:    "Step 1/2 : FROM nope",
+			Message: "pull access denied for nope",
+		}},
+		{"first error", `{"error":"first"}` + "\n" + `{"error":"second"}`, &BuildError{Message: "first"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			e := &Executor{BuildOutput: &out}
+			berr, err := e.readBuild(strings.NewReader(tt.output))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tt.want == nil && berr != nil:
+				t.Errorf("readBuild = %+v, want no BuildError", berr)
+			case tt.want != nil && (berr == nil || *berr != *tt.want):
+				t.Errorf("readBuild = %+v, want %+v", berr, tt.want)
+			case tt.want != nil && !strings.Contains(out.String(), tt.want.Message):
+				t.Errorf("build output %q lacks the error", out.String())
+			}
+		})
+	}
+}
+
+func TestReadBuildMalformed(t *testing.T) {
+	berr, err := new(Executor).readBuild(strings.NewReader(`{"stream":"Step 1/1 : FROM gcc"}` + "\n{"))
+	if err == nil || berr != nil {
+		t.Errorf("readBuild = %v, %v; want a decoding error", berr, err)
+	}
+}
+
+func TestBuildError(t *testing.T) {
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/build") {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		// the daemon reports a failed step in the body of a successful response
+		fmt.Fprint(w, buildFailure)
+	})
+	e := &Executor{cli: cli, Dockerfile: "FROM gcc"}
+	err := e.build(context.Background(), strings.NewReader(""), "tag")
+	var berr *BuildError
+	if !errors.As(err, &berr) {
+		t.Fatalf("build = %v, want a BuildError", err)
+	}
+	if berr.Step != "Step 3/4 : RUN gcc -o main main.c" || berr.Code != 1 || !strings.Contains(berr.Message, "non-zero code: 1") {
+		t.Errorf("BuildError = %+v", berr)
+	}
+	if want := "build failed at Step 3/4 : RUN gcc -o main main.c: The command"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Error() = %q, want prefix %q", err.Error(), want)
+	}
+	if StatusOf(err) != StatusError {
+		t.Errorf("StatusOf = %v, want %v", StatusOf(err), StatusError)
+	}
+}
@@ -0,0 +1,434 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// BuildCache remembers the images built by Executors, so that an Executor
+// whose Dockerfile and Files have been built before reuses the image
+// instead of building and removing a new one. Entries expire once they
+// are older than the cache's TTL, and their images are removed by Prune.
+// Implementations must be safe for concurrent use.
+type BuildCache interface {
+	// Get returns the tag of the image built for key, unless there is
+	// none or it has expired.
+	Get(key string) (tag string, ok bool)
+
+	// Put records that the image tagged tag was built for key.
+	Put(key, tag string) error
+
+	// Expire removes the entries that have expired, and returns the
+	// tags of their images.
+	Expire() ([]string, error)
+}
+
+// BuildCacheStats describes the use of a BuildCache.
+type BuildCacheStats struct {
+	Hits    int64 // calls to Get that returned a tag
+	Misses  int64 // calls to Get that returned none
+	Entries int   // entries held, including expired ones
+}
+
+// A BuildCacheEntry is an entry of a BuildCache, as listed by List.
+type BuildCacheEntry struct {
+	Key      string
+	Tag      string
+	Built    time.Time // when the image was built
+	LastUsed time.Time // when Get last returned the entry, if ever
+	Expired  bool
+}
+
+// cacheCounts counts the hits and misses of a BuildCache.
+type cacheCounts struct {
+	hits, misses int64
+}
+
+func (c *cacheCounts) count(ok bool) {
+	if ok {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+}
+
+func (c *cacheCounts) stats(entries int) BuildCacheStats {
+	return BuildCacheStats{
+		Hits:    atomic.LoadInt64(&c.hits),
+		Misses:  atomic.LoadInt64(&c.misses),
+		Entries: entries,
+	}
+}
+
+// MemoryBuildCache is a BuildCache held in memory. Its zero value is
+// an empty cache whose entries never expire.
+type MemoryBuildCache struct {
+	// TTL is how long an entry is used after its image is built.
+	// If zero, entries never expire.
+	TTL time.Duration
+
+	counts cacheCounts
+	mu     sync.Mutex
+	m      map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	tag      string
+	built    time.Time
+	lastUsed time.Time
+}
+
+func expired(built time.Time, ttl time.Duration) bool {
+	return ttl > 0 && time.Since(built) > ttl
+}
+
+// Get implements BuildCache.
+func (c *MemoryBuildCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.m[key]
+	if !ok || expired(ent.built, c.TTL) {
+		c.counts.count(false)
+		return "", false
+	}
+	c.counts.count(true)
+	ent.lastUsed = time.Now()
+	c.m[key] = ent
+	return ent.tag, true
+}
+
+// Put implements BuildCache.
+func (c *MemoryBuildCache) Put(key, tag string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]cacheEntry)
+	}
+	c.m[key] = cacheEntry{tag: tag, built: time.Now()}
+	return nil
+}
+
+// Expire implements BuildCache.
+func (c *MemoryBuildCache) Expire() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var tags []string
+	for key, ent := range c.m {
+		if expired(ent.built, c.TTL) {
+			tags = append(tags, ent.tag)
+			delete(c.m, key)
+		}
+	}
+	return tags, nil
+}
+
+// Stats returns the statistics of the cache.
+func (c *MemoryBuildCache) Stats() BuildCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts.stats(len(c.m))
+}
+
+// List returns the entries of the cache, sorted by key.
+func (c *MemoryBuildCache) List() ([]BuildCacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ents := make([]BuildCacheEntry, 0, len(c.m))
+	for key, ent := range c.m {
+		ents = append(ents, BuildCacheEntry{
+			Key:      key,
+			Tag:      ent.tag,
+			Built:    ent.built,
+			LastUsed: ent.lastUsed,
+			Expired:  expired(ent.built, c.TTL),
+		})
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].Key < ents[j].Key })
+	return ents, nil
+}
+
+// Evict removes the entry for key, whether or not it has expired, and
+// returns the tag of its image. It returns "" if there is no entry.
+func (c *MemoryBuildCache) Evict(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.m[key]
+	if !ok {
+		return "", nil
+	}
+	delete(c.m, key)
+	return ent.tag, nil
+}
+
+// DiskBuildCache is a BuildCache stored in a directory, so that images
+// are reused across restarts of a service. Each entry is a file named
+// by its key that holds the tag of its image. Its statistics and the
+// times entries were last used are those of this DiskBuildCache, not
+// of every process sharing Dir.
+type DiskBuildCache struct {
+	// Dir is the directory of the cache, which is created if needed.
+	Dir string
+
+	// TTL is how long an entry is used after its image is built.
+	// If zero, entries never expire.
+	TTL time.Duration
+
+	counts cacheCounts
+	used   sync.Map // key -> time.Time of the last hit
+}
+
+// Get implements BuildCache.
+func (c *DiskBuildCache) Get(key string) (string, bool) {
+	tag, ok := c.get(key)
+	c.counts.count(ok)
+	if ok {
+		c.used.Store(key, time.Now())
+	}
+	return tag, ok
+}
+
+func (c *DiskBuildCache) get(key string) (string, bool) {
+	path := filepath.Join(c.Dir, key)
+	fi, err := os.Stat(path)
+	if err != nil || expired(fi.ModTime(), c.TTL) {
+		return "", false
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || len(b) == 0 {
+		return "", false
+	}
+	return string(b), true
+}
+
+// Put implements BuildCache.
+func (c *DiskBuildCache) Put(key, tag string) error {
+	if err := os.MkdirAll(c.Dir, 0777); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(c.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(tag)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// replace any existing entry atomically
+		err = os.Rename(f.Name(), filepath.Join(c.Dir, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// Expire implements BuildCache.
+func (c *DiskBuildCache) Expire() ([]string, error) {
+	fis, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, fi := range fis {
+		if fi.IsDir() || fi.Name()[0] == '.' || !expired(fi.ModTime(), c.TTL) {
+			continue
+		}
+		path := filepath.Join(c.Dir, fi.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if os.Remove(path) == nil && len(b) > 0 {
+			c.used.Delete(fi.Name())
+			tags = append(tags, string(b))
+		}
+	}
+	return tags, nil
+}
+
+// Stats returns the statistics of the cache. Entries counts the
+// entries in Dir, and is 0 if Dir can't be read.
+func (c *DiskBuildCache) Stats() BuildCacheStats {
+	ents, _ := c.List()
+	return c.counts.stats(len(ents))
+}
+
+// List returns the entries of the cache, sorted by key.
+func (c *DiskBuildCache) List() ([]BuildCacheEntry, error) {
+	fis, err := ioutil.ReadDir(c.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ents []BuildCacheEntry
+	for _, fi := range fis {
+		if fi.IsDir() || fi.Name()[0] == '.' {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(c.Dir, fi.Name()))
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		ent := BuildCacheEntry{
+			Key:     fi.Name(),
+			Tag:     string(b),
+			Built:   fi.ModTime(),
+			Expired: expired(fi.ModTime(), c.TTL),
+		}
+		if t, ok := c.used.Load(fi.Name()); ok {
+			ent.LastUsed = t.(time.Time)
+		}
+		ents = append(ents, ent)
+	}
+	return ents, nil
+}
+
+// Evict removes the entry for key, whether or not it has expired, and
+// returns the tag of its image. It returns "" if there is no entry.
+func (c *DiskBuildCache) Evict(key string) (string, error) {
+	if key == "" || key[0] == '.' || strings.ContainsAny(key, `/\`) {
+		return "", nil
+	}
+	path := filepath.Join(c.Dir, key)
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	c.used.Delete(key)
+	return string(b), nil
+}
+
+// cacheKey returns the BuildCache key of the build context bc, which
+// doesn't depend on the order of its files.
+func (e *Executor) cacheKey(bc []byte) string {
+	sum := contextDigest(bc)
+	if len(e.BuildArgs) == 0 && e.Target == "" {
+		return digest(e.Platform + "\x00" + sum)
+	}
+	var b strings.Builder
+	b.WriteString(e.Platform)
+	if e.Target != "" {
+		b.WriteString("\x00target=" + e.Target)
+	}
+	keys := make([]string, 0, len(e.BuildArgs))
+	for k := range e.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\x00%s=%s", k, e.BuildArgs[k])
+	}
+	b.WriteString("\x00\x00")
+	b.WriteString(sum)
+	return digest(b.String())
+}
+
+// contextDigest returns the digest of the entries of the archive bc, in
+// the order of their names, or of bc itself if it can't be read.
+func contextDigest(bc []byte) string {
+	var sums []string
+	tr := tar.NewReader(bytes.NewReader(bc))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return digest(string(bc))
+		}
+		h := sha256.New()
+		fmt.Fprintf(h, "%s\x00%c\x00%o\x00%d\x00%d\x00%s\x00", hdr.Name, hdr.Typeflag, hdr.Mode, hdr.Uid, hdr.Gid, hdr.Linkname)
+		if _, err := io.Copy(h, tr); err != nil {
+			return digest(string(bc))
+		}
+		sums = append(sums, hex.EncodeToString(h.Sum(nil)))
+	}
+	sort.Strings(sums)
+	return digest(strings.Join(sums, ""))
+}
+
+// cached returns the tag of the image cached for key, if it still exists.
+func (e *Executor) cached(ctx context.Context, key string) (string, bool) {
+	tag, ok := e.BuildCache.Get(key)
+	if !ok {
+		return "", false
+	}
+	if _, _, err := e.cli.ImageInspectWithRaw(ctx, tag); err != nil {
+		return "", false
+	}
+	return tag, true
+}
+
+// Prune removes the images of the entries of the Executor's BuildCache
+// that have expired.
+func (e *Executor) Prune(ctx context.Context) error {
+	if e.BuildCache == nil {
+		return nil
+	}
+	tags, err := e.BuildCache.Expire()
+	if err != nil || len(tags) == 0 {
+		return err
+	}
+	cli, err := e.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer e.closeClient(cli)
+	for _, tag := range tags {
+		_, err := cli.ImageRemove(ctx, tag, types.ImageRemoveOptions{Force: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
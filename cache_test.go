@@ -0,0 +1,167 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildCacheStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eggsy-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		name string
+		c    interface {
+			BuildCache
+			Stats() BuildCacheStats
+			List() ([]BuildCacheEntry, error)
+			Evict(key string) (string, error)
+		}
+	}{
+		{"memory", new(MemoryBuildCache)},
+		{"disk", &DiskBuildCache{Dir: dir}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.c
+			for key, tag := range map[string]string{"a": "eggsy-a", "b": "eggsy-b"} {
+				if err := c.Put(key, tag); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, ok := c.Get("a"); !ok {
+				t.Error(`Get("a") missed`)
+			}
+			if _, ok := c.Get("c"); ok {
+				t.Error(`Get("c") hit`)
+			}
+			st := c.Stats()
+			if want := (BuildCacheStats{Hits: 1, Misses: 1, Entries: 2}); st != want {
+				t.Errorf("Stats() = %+v, want %+v", st, want)
+			}
+
+			ents, err := c.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ents) != 2 || ents[0].Key != "a" || ents[0].Tag != "eggsy-a" || ents[1].Key != "b" {
+				t.Fatalf("List() = %+v", ents)
+			}
+			if ents[0].LastUsed.IsZero() || !ents[1].LastUsed.IsZero() {
+				t.Errorf("LastUsed = %v, %v; want only a's set", ents[0].LastUsed, ents[1].LastUsed)
+			}
+			if ents[0].Built.IsZero() || ents[0].Expired {
+				t.Errorf("entry a = %+v, want built and unexpired", ents[0])
+			}
+
+			if tag, err := c.Evict("a"); err != nil || tag != "eggsy-a" {
+				t.Errorf(`Evict("a") = %q, %v; want "eggsy-a"`, tag, err)
+			}
+			if tag, err := c.Evict("a"); err != nil || tag != "" {
+				t.Errorf(`second Evict("a") = %q, %v; want ""`, tag, err)
+			}
+			if _, ok := c.Get("a"); ok {
+				t.Error(`Get("a") hit after Evict`)
+			}
+			if st := c.Stats(); st.Entries != 1 || st.Misses != 2 {
+				t.Errorf("Stats() after Evict = %+v", st)
+			}
+		})
+	}
+}
+
+func TestBuildCacheListExpired(t *testing.T) {
+	c := &MemoryBuildCache{TTL: time.Minute}
+	c.Put("a", "eggsy-a")
+	c.m["a"] = cacheEntry{tag: "eggsy-a", built: time.Now().Add(-time.Hour)}
+	ents, _ := c.List()
+	if len(ents) != 1 || !ents[0].Expired {
+		t.Errorf("List() = %+v, want a expired", ents)
+	}
+	if st := c.Stats(); st.Entries != 1 {
+		t.Errorf("Stats().Entries = %d, want the expired entry counted", st.Entries)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	file := func(path, data string) File {
+		return File{Path: path, ReadCloser: ioutil.NopCloser(strings.NewReader(data))}
+	}
+	key := func(e *Executor) string {
+		t.Helper()
+		bc, _, err := e.makeBuildContext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e.cacheKey(bc.Bytes())
+	}
+	base := func() *Executor {
+		return &Executor{
+			Dockerfile: "FROM alpine\nCOPY . .",
+			Files:      fileList{file("main.c", "int main;"), file("lib/util.h", "#pragma once")},
+		}
+	}
+	want := key(base())
+	if got := key(base()); got != want {
+		t.Fatalf("key of the same context = %s, want %s", got, want)
+	}
+	reordered := base()
+	reordered.Files = fileList{file("lib/util.h", "#pragma once"), file("main.c", "int main;")}
+	if got := key(reordered); got != want {
+		t.Errorf("key with the files reordered = %s, want %s", got, want)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		change func(e *Executor)
+	}{
+		{"content", func(e *Executor) {
+			e.Files = fileList{file("main.c", "int main=1;"), file("lib/util.h", "#pragma once")}
+		}},
+		{"path", func(e *Executor) {
+			e.Files = fileList{file("main.cc", "int main;"), file("lib/util.h", "#pragma once")}
+		}},
+		{"mode", func(e *Executor) {
+			f := file("main.c", "int main;")
+			f.Mode = 0755
+			e.Files = fileList{f, file("lib/util.h", "#pragma once")}
+		}},
+		{"missing file", func(e *Executor) { e.Files = fileList{file("main.c", "int main;")} }},
+		{"Dockerfile", func(e *Executor) { e.Dockerfile = "FROM alpine:3\nCOPY . ." }},
+		{"Platform", func(e *Executor) { e.Platform = "linux/arm64" }},
+		{"BuildArgs", func(e *Executor) { e.BuildArgs = map[string]string{"CC": "clang"} }},
+		{"Target", func(e *Executor) { e.Target = "test" }},
+	} {
+		e := base()
+		tt.change(e)
+		if got := key(e); got == want {
+			t.Errorf("key with a different %s = %s, the same as before", tt.name, got)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// Labels of the images and containers created by Executors and Pools.
+const (
+	// Label marks every image and container.
+	Label = "eggsy"
+
+	// LabelID is the ID that eggsy gave the image or container, which
+	// is its name or tag.
+	LabelID = "eggsy.id"
+
+	// LabelCreated is the time the image or container was created,
+	// in RFC 3339 format.
+	LabelCreated = "eggsy.created"
+)
+
+// labels returns the labels of the Executor's image or container with
+// the given ID.
+func (e *Executor) labels(id string) map[string]string {
+	m := make(map[string]string, len(e.Labels)+3)
+	for k, v := range e.Labels {
+		m[k] = v
+	}
+	m[Label] = "true"
+	m[LabelID] = id
+	m[LabelCreated] = time.Now().UTC().Format(time.RFC3339)
+	return m
+}
+
+// Cleanup removes the images and containers created by Executors and
+// Pools more than olderThan ago, such as those left behind by a service
+// that crashed. It must not be run with an olderThan shorter than the
+// longest execution, and removes images held by a BuildCache regardless
+// of their TTL. The daemon is connected to as by an Executor configured
+// by opts, and so is selected by the environment unless they set its
+// Client, DockerHost, DockerContext, or TLSConfig.
+func Cleanup(ctx context.Context, olderThan time.Duration, opts ...func(*Executor)) error {
+	e := new(Executor)
+	for _, opt := range opts {
+		opt(e)
+	}
+	cli, err := e.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer e.closeClient(cli)
+	before := time.Now().Add(-olderThan).Unix()
+	f := filters.NewArgs(filters.Arg("label", Label))
+	cs, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: f})
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if c.Created > before {
+			continue
+		}
+		err := cli.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil && !client.IsErrNotFound(err) {
+			return err
+		}
+	}
+	is, err := cli.ImageList(ctx, types.ImageListOptions{Filters: f})
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, i := range is {
+		if i.Created > before {
+			continue
+		}
+		// an image in use by a running container can't be removed,
+		// but the others still can
+		_, err := cli.ImageRemove(ctx, i.ID, types.ImageRemoveOptions{Force: true, PruneChildren: true})
+		if err != nil && !client.IsErrNotFound(err) && first == nil {
+			first = err
+		}
+	}
+	return first
+}
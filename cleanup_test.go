@@ -0,0 +1,69 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCleanupClient(t *testing.T) {
+	old, recent := time.Now().Add(-2*time.Hour).Unix(), time.Now().Unix()
+	var (
+		mu      sync.Mutex
+		removed []string
+	)
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/containers/json"):
+			fmt.Fprintf(w, `[{"Id":"old","Created":%d},{"Id":"recent","Created":%d}]`, old, recent)
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/images/json"):
+			fmt.Fprintf(w, `[{"Id":"oldimage","Created":%d},{"Id":"recentimage","Created":%d}]`, old, recent)
+		case r.Method == "DELETE":
+			mu.Lock()
+			removed = append(removed, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+			mu.Unlock()
+			if strings.Contains(r.URL.Path, "/images/") {
+				fmt.Fprint(w, `[]`)
+			}
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+	err := Cleanup(context.Background(), time.Hour, func(e *Executor) { e.Client = cli })
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if want := []string{"old", "oldimage"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %q, want %q", removed, want)
+	}
+}
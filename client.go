@@ -0,0 +1,229 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// newClient creates a client for the engine selected by the Executor,
+// or returns its Client. It must be released with closeClient.
+func (e *Executor) newClient(ctx context.Context) (*client.Client, error) {
+	if e.Client != nil {
+		return e.Client, nil
+	}
+	b := e.Backend
+	if b == nil {
+		b = DockerBackend{Context: e.DockerContext}
+	}
+	opts, err := b.ClientOpts()
+	if err != nil {
+		return nil, err
+	}
+	if e.TLSConfig != nil {
+		opts = append(opts, client.WithHTTPClient(&http.Client{
+			Transport:     &http.Transport{TLSClientConfig: e.TLSConfig},
+			CheckRedirect: client.CheckRedirect,
+		}))
+	}
+	switch {
+	case strings.HasPrefix(e.DockerHost, "ssh://"):
+		sopts, err := sshOpts(e.DockerHost)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sopts...)
+	case e.DockerHost != "":
+		// the host configures the transport, so it must come last
+		opts = append(opts, client.WithHost(e.DockerHost))
+	}
+	if e.APIVersion != "" {
+		opts = append(opts, client.WithVersion(e.APIVersion))
+	}
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.Transport.apply(cli); err != nil {
+		return nil, err
+	}
+	if e.APIVersion == "" && e.NegotiateAPIVersion {
+		cli.NegotiateAPIVersion(ctx)
+	}
+	return cli, nil
+}
+
+// closeClient closes a client created by newClient, unless it is the
+// Executor's Client.
+func (e *Executor) closeClient(cli *client.Client) {
+	if cli != e.Client {
+		cli.Close()
+	}
+}
+
+// TransportConfig tunes the connections made to the daemon. The zero
+// value of each field leaves the client's default in place.
+type TransportConfig struct {
+	// MaxIdleConns and MaxIdleConnsPerHost limit the number of idle
+	// connections kept open to the daemon, and IdleConnTimeout is how
+	// long an idle connection is kept open.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+
+	// DialTimeout is the maximum time spent connecting to the daemon,
+	// and KeepAlive is the interval of TCP keep-alive probes on its
+	// connections. A negative KeepAlive disables them.
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+
+	// ResponseHeaderTimeout is the maximum time spent waiting for the
+	// daemon to respond to a request. Stopping a container waits for
+	// the container to exit, so it should exceed the Executor's Timeout.
+	ResponseHeaderTimeout time.Duration
+}
+
+func (t TransportConfig) apply(cli *client.Client) error {
+	if t == (TransportConfig{}) {
+		return nil
+	}
+	// HTTPClient shares its transport with cli
+	tr, ok := cli.HTTPClient().Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("eggsy: cannot configure transport %T", cli.HTTPClient().Transport)
+	}
+	if t.MaxIdleConns != 0 {
+		tr.MaxIdleConns = t.MaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost != 0 {
+		tr.MaxIdleConnsPerHost = t.MaxIdleConnsPerHost
+	}
+	if t.IdleConnTimeout != 0 {
+		tr.IdleConnTimeout = t.IdleConnTimeout
+	}
+	if t.ResponseHeaderTimeout != 0 {
+		tr.ResponseHeaderTimeout = t.ResponseHeaderTimeout
+	}
+	if t.DialTimeout == 0 && t.KeepAlive == 0 || tr.DialContext != nil {
+		// a transport with DialContext set dials through ssh
+		return nil
+	}
+	u, err := client.ParseHostURL(cli.DaemonHost())
+	if err != nil {
+		return err
+	}
+	d := &net.Dialer{Timeout: t.DialTimeout, KeepAlive: t.KeepAlive}
+	switch u.Scheme {
+	case "unix":
+		tr.Dial = nil
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", u.Host)
+		}
+	case "tcp", "http", "https":
+		tr.Dial = nil
+		tr.DialContext = d.DialContext
+	}
+	return nil
+}
+
+// contextMeta is the metadata of a context, as stored by `docker context`.
+type contextMeta struct {
+	Name      string
+	Endpoints map[string]struct {
+		Host          string
+		SkipTLSVerify bool
+	}
+}
+
+// dockerConfigDir returns the directory of the docker CLI's configuration.
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// contextOpts returns the client options for connecting to the docker
+// endpoint of the named context.
+func contextOpts(name string) ([]func(*client.Client) error, error) {
+	sum := sha256.Sum256([]byte(name))
+	id := hex.EncodeToString(sum[:])
+	dir := dockerConfigDir()
+	b, err := ioutil.ReadFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("eggsy: docker context %q not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta contextMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		return nil, fmt.Errorf("eggsy: docker context %q: %v", name, err)
+	}
+	ep, ok := meta.Endpoints["docker"]
+	if !ok || ep.Host == "" {
+		return nil, fmt.Errorf("eggsy: docker context %q has no docker endpoint", name)
+	}
+	var opts []func(*client.Client) error
+	tls := filepath.Join(dir, "contexts", "tls", id, "docker")
+	if _, err := os.Stat(tls); err == nil {
+		tlsc, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             existing(filepath.Join(tls, "ca.pem")),
+			CertFile:           existing(filepath.Join(tls, "cert.pem")),
+			KeyFile:            existing(filepath.Join(tls, "key.pem")),
+			InsecureSkipVerify: ep.SkipTLSVerify,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("eggsy: docker context %q: %v", name, err)
+		}
+		opts = append(opts, client.WithHTTPClient(&http.Client{
+			Transport:     &http.Transport{TLSClientConfig: tlsc},
+			CheckRedirect: client.CheckRedirect,
+		}))
+	}
+	// the host configures the transport, so it must come last
+	return append(opts, client.WithHost(ep.Host)), nil
+}
+
+// existing returns path if it exists, and "" otherwise.
+func existing(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,288 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Eggsy runs commands in sandboxes from the command line.
+//
+// Usage:
+//
+//	eggsy run [flags] [-- command [args...]]
+//	eggsy cleanup [-older-than duration]
+//	eggsy presets list
+//
+// Run executes a command in a container, copying the files given with -f
+// into its working directory, and exits with the command's exit code, or
+// with 124 if it timed out, or 125 if it couldn't be run. A command given
+// as a single argument is run by sh -c; otherwise it is run as is. For
+// example:
+//
+//	eggsy run -image golang:1.22 -timeout 5s -mem 256m -f main.go -- "go run main.go"
+//
+// The flags of run are:
+//
+//	-image name
+//		the image to run the command in
+//	-dockerfile file
+//		a Dockerfile to build the image from, in place of -image
+//	-preset name
+//		a language preset, such as go or python3, whose program is
+//		the file given by the only -f; see eggsy presets list
+//	-f file
+//		a file to copy into the working directory; may be repeated
+//	-i
+//		pass standard input to the command
+//	-timeout d
+//		the command's timeout (default 10s)
+//	-mem size
+//		the command's memory limit, such as 256m
+//	-pids n
+//		the maximum number of processes
+//	-net mode
+//		the network mode: bridge, none, or restricted (default bridge)
+//	-runtime name
+//		the container runtime (default runsc)
+//	-json
+//		print the result as JSON to standard error
+//
+// Cleanup removes the images and containers that eggsy created more than
+// -older-than ago (default 1h). Presets list lists the language presets.
+//
+// The Docker daemon is chosen by the DOCKER_HOST, DOCKER_CERT_PATH,
+// DOCKER_TLS_VERIFY, and DOCKER_API_VERSION environment variables.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/smasher164/eggsy"
+	"github.com/smasher164/eggsy/preset"
+)
+
+const usage = `usage:
+	eggsy run [flags] [-- command [args...]]
+	eggsy cleanup [-older-than duration]
+	eggsy presets list
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; {
+	case cmd == "run":
+		var code int
+		code, err = run(ctx, args)
+		if err == nil {
+			stop()
+			os.Exit(code)
+		}
+	case cmd == "cleanup":
+		err = cleanup(ctx, args)
+	case cmd == "presets" && len(args) == 1 && args[0] == "list":
+		listPresets()
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(125)
+	}
+}
+
+// files is a flag that may be repeated.
+type files []string
+
+func (f *files) String() string     { return strings.Join(*f, ",") }
+func (f *files) Set(s string) error { *f = append(*f, s); return nil }
+
+func run(ctx context.Context, args []string) (int, error) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var (
+		image      = fs.String("image", "", "image to run the command in")
+		dockerfile = fs.String("dockerfile", "", "Dockerfile to build the image from")
+		presetName = fs.String("preset", "", "language preset")
+		stdin      = fs.Bool("i", false, "pass standard input to the command")
+		timeout    = fs.Duration("timeout", eggsy.DefaultRunTimeout, "the command's timeout")
+		mem        = fs.String("mem", "", "the command's memory limit, such as 256m")
+		pids       = fs.Int64("pids", 0, "maximum number of processes")
+		network    = fs.String("net", "bridge", "network mode: bridge, none, or restricted")
+		runtime    = fs.String("runtime", string(eggsy.RuntimeRunsc), "container runtime")
+		asJSON     = fs.Bool("json", false, "print the result as JSON to standard error")
+		paths      files
+	)
+	fs.Var(&paths, "f", "file to copy into the working directory; may be repeated")
+	fs.Parse(args)
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	opts := []func(*eggsy.Executor){func(e *eggsy.Executor) {
+		e.Stdout, e.Stderr = os.Stdout, os.Stderr
+		// a preset's own limits are kept unless overridden
+		if *presetName == "" || set["timeout"] {
+			e.Timeout = *timeout
+		}
+		if *presetName == "" || set["runtime"] {
+			e.Runtime = eggsy.Runtime(*runtime)
+		}
+		if *stdin {
+			e.Stdin = os.Stdin
+		}
+	}}
+	var memory int64
+	if *mem != "" {
+		var err error
+		if memory, err = units.RAMInBytes(*mem); err != nil {
+			return 0, fmt.Errorf("eggsy: invalid -mem: %v", err)
+		}
+	}
+	net := eggsy.NetBridge
+	switch *network {
+	case "bridge":
+		net = eggsy.NetBridge
+	case "none":
+		net = eggsy.NetNone
+	case "restricted":
+		net = eggsy.NetRestricted
+	default:
+		return 0, fmt.Errorf("eggsy: unknown network mode %q", *network)
+	}
+	opts = append(opts, func(e *eggsy.Executor) {
+		if *presetName == "" || set["net"] {
+			e.Net = net
+		}
+		if memory > 0 {
+			e.Memory = memory
+		}
+		if *pids > 0 {
+			e.PidsLimit = *pids
+		}
+		switch fs.NArg() {
+		case 0:
+		case 1:
+			e.Cmd = fs.Arg(0)
+		default:
+			e.Args = fs.Args()
+		}
+	})
+
+	var e *eggsy.Executor
+	if *presetName != "" {
+		p := findPreset(*presetName)
+		if p == nil {
+			return 0, fmt.Errorf("eggsy: unknown preset %q", *presetName)
+		}
+		if len(paths) != 1 {
+			return 0, errors.New("eggsy: -preset takes the program as the only -f")
+		}
+		source, err := ioutil.ReadFile(paths[0])
+		if err != nil {
+			return 0, err
+		}
+		e = p.Executor(string(source), opts...)
+	} else {
+		if (*image == "") == (*dockerfile == "") {
+			return 0, errors.New("eggsy: run takes one of -image, -dockerfile, or -preset")
+		}
+		if fs.NArg() == 0 {
+			return 0, errors.New("eggsy: run takes a command after --")
+		}
+		e = &eggsy.Executor{Image: *image}
+		if *dockerfile != "" {
+			b, err := ioutil.ReadFile(*dockerfile)
+			if err != nil {
+				return 0, err
+			}
+			e.Dockerfile = string(b)
+		}
+		m := make(map[string][]byte, len(paths))
+		for _, p := range paths {
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return 0, err
+			}
+			m[filepath.ToSlash(filepath.Clean(p))] = b
+		}
+		e.Files = eggsy.MapFileSet(m)
+		for _, opt := range opts {
+			opt(e)
+		}
+	}
+
+	res, err := e.Execute(ctx)
+	if *asJSON && res != nil {
+		enc := json.NewEncoder(os.Stderr)
+		enc.SetIndent("", "\t")
+		enc.Encode(res)
+	}
+	switch {
+	case errors.Is(err, eggsy.ErrTimeout):
+		fmt.Fprintln(os.Stderr, err)
+		return 124, nil
+	case err != nil:
+		return 0, err
+	}
+	return res.ExitCode, nil
+}
+
+// findPreset returns the preset whose name is name, ignoring case and
+// spaces, so that "python3" names "Python 3".
+func findPreset(name string) *preset.Preset {
+	norm := func(s string) string { return strings.ToLower(strings.Replace(s, " ", "", -1)) }
+	for _, p := range preset.All {
+		if norm(p.Name) == norm(name) {
+			return p
+		}
+	}
+	return nil
+}
+
+func cleanup(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", time.Hour, "remove what was created more than this long ago")
+	fs.Parse(args)
+	return eggsy.Cleanup(ctx, *olderThan)
+}
+
+func listPresets() {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tIMAGE\tSOURCE\tTIMEOUT\tMEMORY")
+	for _, p := range preset.All {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Name, p.Image, p.Source, p.Timeout, units.BytesSize(float64(p.Memory)))
+	}
+	tw.Flush()
+}
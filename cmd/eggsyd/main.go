@@ -0,0 +1,201 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Eggsyd serves eggsy's Executor over gRPC, as defined by package rpc.
+//
+// Usage:
+//
+//	eggsyd [flags]
+//
+// The flags are:
+//
+//	-addr address
+//		the address to listen on (default "localhost:7447"). An
+//		address other than a loopback one requires -token-file or
+//		-tls-client-ca, since any caller can run containers
+//	-token-file file
+//		require every call to carry the token in file, as
+//		rpc.Token sends it
+//	-parallel n
+//		the maximum number of jobs executing at once, or 0 for any
+//		number (default 8)
+//	-max-waiting n
+//		the maximum number of jobs waiting to execute, beyond which
+//		jobs are refused with RESOURCE_EXHAUSTED, or 0 for any number
+//		(default 64)
+//	-max-timeout d
+//		the maximum Timeout of a job (default 1m)
+//	-max-memory bytes
+//		the maximum Memory of a job, which is also the default
+//		(default 512MiB)
+//	-max-message bytes
+//		the maximum size of a request, which holds a job's files
+//		(default 16MiB)
+//	-runtime name
+//		the container runtime that executes jobs (default runsc)
+//	-tls-cert file, -tls-key file
+//		serve TLS with the given certificate and key
+//	-tls-client-ca file
+//		require clients to present a certificate signed by one of
+//		the CAs in file, which requires -tls-cert
+//
+// Every job is hardened as by eggsy.HardenedDefaults, with a read-only
+// root filesystem, whatever its request asks for.
+//
+// The Docker daemon is chosen by the DOCKER_HOST, DOCKER_CERT_PATH,
+// DOCKER_TLS_VERIFY, and DOCKER_API_VERSION environment variables.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/smasher164/eggsy"
+	"github.com/smasher164/eggsy/rpc"
+	"github.com/smasher164/eggsy/rpc/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	var (
+		addr       = flag.String("addr", "localhost:7447", "address to listen on")
+		tokenFile  = flag.String("token-file", "", "file of the token that calls must carry")
+		parallel   = flag.Int("parallel", 8, "maximum number of jobs executing at once, or 0 for any number")
+		maxWaiting = flag.Int("max-waiting", server.DefaultMaxWaiting, "maximum number of jobs waiting to execute, or 0 for any number")
+		maxTimeout = flag.Duration("max-timeout", time.Minute, "maximum Timeout of a job")
+		maxMemory  = flag.Int64("max-memory", 512<<20, "maximum Memory of a job, which is also the default")
+		maxMessage = flag.Int("max-message", 16<<20, "maximum size of a request")
+		runtime    = flag.String("runtime", string(eggsy.RuntimeRunsc), "container runtime that executes jobs")
+		tlsCert    = flag.String("tls-cert", "", "TLS certificate file")
+		tlsKey     = flag.String("tls-key", "", "TLS key file")
+		clientCA   = flag.String("tls-client-ca", "", "file of the CAs that must sign client certificates")
+	)
+	flag.Parse()
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+	s := server.NewServer(*parallel, func(e *eggsy.Executor) {
+		if e.Timeout < 0 || e.Timeout > *maxTimeout {
+			e.Timeout = *maxTimeout
+		}
+		if e.Memory <= 0 || e.Memory > *maxMemory {
+			e.Memory = *maxMemory
+		}
+		e.Runtime = eggsy.Runtime(*runtime)
+		e.Logger = log
+	})
+	s.MaxWaiting = *maxWaiting
+	if *tokenFile == "" && *clientCA == "" && !loopback(*addr) {
+		log.Error("eggsyd: listening on a non-loopback address requires -token-file or -tls-client-ca", "addr", *addr)
+		os.Exit(2)
+	}
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(*maxMessage)}
+	if *tlsCert != "" {
+		cfg, err := tlsConfig(*tlsCert, *tlsKey, *clientCA)
+		if err != nil {
+			log.Error("eggsyd: loading TLS certificates", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg)))
+	} else if *clientCA != "" {
+		log.Error("eggsyd: -tls-client-ca requires -tls-cert")
+		os.Exit(2)
+	}
+	if *tokenFile != "" {
+		b, err := os.ReadFile(*tokenFile)
+		token := strings.TrimSpace(string(b))
+		if err == nil && token == "" {
+			err = errors.New("token is empty")
+		}
+		if err != nil {
+			log.Error("eggsyd: reading token", "err", err)
+			os.Exit(1)
+		}
+		opts = append(opts, server.RequireToken(token)...)
+	}
+	gs := grpc.NewServer(opts...)
+	rpc.RegisterExecutorServer(gs, s)
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Error("eggsyd: listening", "err", err)
+		os.Exit(1)
+	}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		log.Info("eggsyd: shutting down")
+		gs.GracefulStop()
+	}()
+	log.Info("eggsyd: serving", "addr", l.Addr())
+	if err := gs.Serve(l); err != nil {
+		log.Error("eggsyd: serving", "err", err)
+		os.Exit(1)
+	}
+}
+
+// loopback reports whether addr is on a loopback interface only.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tlsConfig returns the TLS configuration of the certificate and key,
+// which requires client certificates signed by the CAs in clientCA, if
+// it is non-empty.
+func tlsConfig(cert, key, clientCA string) (*tls.Config, error) {
+	c, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{c}, MinVersion: tls.VersionTLS12}
+	if clientCA != "" {
+		pem, err := os.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + clientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,199 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package dockerfile builds Dockerfiles for use as an eggsy.Executor's
+// Dockerfile. Values are quoted as they are written, so that values
+// controlled by users, such as file names, can't add instructions or
+// refer to build variables.
+package dockerfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/smasher164/eggsy"
+)
+
+// ErrNoFrom is returned when a Dockerfile doesn't start with FROM.
+var ErrNoFrom = errors.New("dockerfile: first instruction is not FROM")
+
+// Builder builds a Dockerfile one instruction at a time.
+type Builder struct {
+	lines  []string
+	copies []string // sources of COPY instructions
+	err    error
+}
+
+// New returns an empty Builder, whose first instruction must be From.
+func New() *Builder {
+	return new(Builder)
+}
+
+// From adds a FROM instruction for the base image.
+func (b *Builder) From(image string) *Builder {
+	if strings.ContainsAny(image, " \t\r\n") {
+		b.fail(fmt.Errorf("dockerfile: image %q contains whitespace", image))
+	}
+	return b.add("FROM " + escape(image))
+}
+
+// Stage adds a FROM instruction that begins the build stage name of a
+// multi-stage Dockerfile, which later stages can copy files from, and
+// which can be an Executor's Target.
+func (b *Builder) Stage(image, name string) *Builder {
+	b.From(image)
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		b.fail(fmt.Errorf("dockerfile: invalid stage name %q", name))
+	}
+	b.lines[len(b.lines)-1] += " AS " + escape(name)
+	return b
+}
+
+// Run adds a RUN instruction that executes args without a shell.
+func (b *Builder) Run(args ...string) *Builder {
+	return b.add("RUN " + list(args))
+}
+
+// Copy adds a COPY instruction that copies src of the build context,
+// which may be a pattern in the syntax of path.Match, to dst.
+func (b *Builder) Copy(src, dst string) *Builder {
+	b.copies = append(b.copies, src)
+	return b.add("COPY " + list([]string{escape(src), escape(dst)}))
+}
+
+// CopyFrom adds a COPY instruction that copies src of the build stage
+// from, rather than of the build context, to dst.
+func (b *Builder) CopyFrom(from, src, dst string) *Builder {
+	if from == "" || strings.ContainsAny(from, " \t\r\n") {
+		b.fail(fmt.Errorf("dockerfile: invalid stage name %q", from))
+	}
+	return b.add("COPY --from=" + escape(from) + " " + list([]string{escape(src), escape(dst)}))
+}
+
+// Workdir adds a WORKDIR instruction.
+func (b *Builder) Workdir(dir string) *Builder {
+	return b.add("WORKDIR " + escape(dir))
+}
+
+// User adds a USER instruction.
+func (b *Builder) User(user string) *Builder {
+	if strings.ContainsAny(user, " \t") {
+		b.fail(fmt.Errorf("dockerfile: user %q contains whitespace", user))
+	}
+	return b.add("USER " + escape(user))
+}
+
+// Entrypoint adds an ENTRYPOINT instruction that executes args without
+// a shell.
+func (b *Builder) Entrypoint(args ...string) *Builder {
+	return b.add("ENTRYPOINT " + list(args))
+}
+
+func (b *Builder) add(line string) *Builder {
+	if strings.ContainsAny(line, "\r\n\x00") {
+		b.fail(fmt.Errorf("dockerfile: instruction %q contains a line break", line))
+	}
+	b.lines = append(b.lines, line)
+	return b
+}
+
+func (b *Builder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Dockerfile returns the Dockerfile, or the first mistake found in it.
+// If files is non-nil, it is the build context, which must have a file
+// for the source of every COPY instruction.
+func (b *Builder) Dockerfile(files eggsy.FileSet) (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	if len(b.lines) == 0 || !strings.HasPrefix(b.lines[0], "FROM ") {
+		return "", ErrNoFrom
+	}
+	if files != nil {
+		paths, err := filePaths(files)
+		if err != nil {
+			return "", err
+		}
+		for _, src := range b.copies {
+			if !matchAny(src, paths) {
+				return "", fmt.Errorf("dockerfile: COPY of %q, which is not in the build context", src)
+			}
+		}
+	}
+	return strings.Join(b.lines, "\n") + "\n", nil
+}
+
+// escape escapes the characters of s that the daemon would otherwise
+// substitute build variables for.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `$`, `\$`).Replace(s)
+}
+
+// list returns args in the JSON form of an instruction, which isn't
+// interpreted by a shell.
+func list(args []string) string {
+	if args == nil {
+		args = []string{}
+	}
+	b, _ := json.Marshal(args)
+	return string(b)
+}
+
+func filePaths(files eggsy.FileSet) ([]string, error) {
+	paths := make([]string, files.Len())
+	for i := range paths {
+		f, err := files.At(i)
+		if err != nil {
+			return nil, err
+		}
+		if f.ReadCloser != nil {
+			// directories and symbolic links needn't have contents
+			f.Close()
+		}
+		paths[i] = path.Clean(strings.TrimPrefix(f.Path, "/"))
+	}
+	return paths, nil
+}
+
+// matchAny reports whether src names any of paths, or a directory
+// containing one of them.
+func matchAny(src string, paths []string) bool {
+	src = path.Clean(strings.TrimPrefix(src, "/"))
+	if src == "." {
+		return len(paths) > 0
+	}
+	for _, p := range paths {
+		for q := p; q != "."; q = path.Dir(q) {
+			if ok, _ := path.Match(src, q); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
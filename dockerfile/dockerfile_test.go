@@ -0,0 +1,51 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package dockerfile
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/smasher164/eggsy"
+)
+
+// fileList is a FileSet of the Files in it.
+type fileList []eggsy.File
+
+func (l fileList) Len() int                     { return len(l) }
+func (l fileList) At(i int) (eggsy.File, error) { return l[i], nil }
+
+func TestDockerfileDirsAndSymlinks(t *testing.T) {
+	files := fileList{
+		{Path: "src", Mode: fs.ModeDir | 0755},
+		{Path: "bin/run", Linkname: "../src/run.sh"},
+	}
+	df, err := New().From("alpine").Copy("src", "/src").Copy("bin/run", "/bin/run").Dockerfile(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "FROM alpine\nCOPY [\"src\",\"/src\"]\nCOPY [\"bin/run\",\"/bin/run\"]\n"
+	if df != want {
+		t.Errorf("got Dockerfile %q, want %q", df, want)
+	}
+}
@@ -27,13 +27,19 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
+	"io/fs"
+	"log/slog"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
@@ -42,6 +48,9 @@ import (
 	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type (
@@ -49,15 +58,83 @@ type (
 	// constant definitions for descriptions of valid network modes.
 	Network int
 
+	// Runtime is the name of a container runtime configured on the
+	// daemon. See the constant definitions for well-known runtimes.
+	Runtime string
+
+	// Stream is a set of the container's output streams. See the
+	// constant definitions for the individual streams.
+	Stream int
+
 	// TimeoutError represents an error with a container
 	// finishing its command execution, given its timeout.
-	TimeoutError string
+	TimeoutError struct {
+		Cmd         string
+		ContainerID string
+		Image       string
+
+		// Timeout is the Executor's configured timeout, and Elapsed
+		// is the time from starting the container until it was killed.
+		Timeout time.Duration
+		Elapsed time.Duration
+
+		// Output is the number of bytes of output the command wrote
+		// before it was killed.
+		Output int64
+	}
+
+	// ContextError represents the caller's context being done before
+	// the container finished its command execution. The container
+	// is killed and removed before a ContextError is returned.
+	ContextError struct {
+		Cmd         string
+		ContainerID string
+		Image       string
+
+		// Err is the context's error, either context.Canceled
+		// or context.DeadlineExceeded.
+		Err error
+	}
+
+	// DiskQuotaError is returned when the command fills the container's
+	// writable layer up to its DiskQuota.
+	DiskQuotaError struct {
+		Cmd         string
+		ContainerID string
+		Image       string
+
+		// Quota is the Executor's DiskQuota, and Used is the size of
+		// the container's writable layer once it exited, in bytes.
+		Quota int64
+		Used  int64
+	}
+
+	// Ulimit is a resource limit of the container's processes, as set by
+	// setrlimit(2). Name is the resource without its RLIMIT_ prefix, in
+	// lowercase, such as "nofile", "nproc", "fsize", "stack", or "core".
+	// The limits are counts, or bytes for sizes, except for "cpu",
+	// which is in seconds.
+	Ulimit struct {
+		Name string
+		Soft int64
+		Hard int64
+	}
 
 	// File associates a path with readable data, used in a FileSet
 	// to create a build context for a container environment.
 	File struct {
 		Path string
 		io.ReadCloser
+
+		// Mode holds the file's permission bits, along with fs.ModeDir
+		// if it is a directory, whose contents are then ignored. If its
+		// permission bits are zero, the file is readable and writable by
+		// everyone, and a directory is also searchable by everyone.
+		Mode fs.FileMode
+
+		// Linkname, if set, makes the file a symbolic link to Linkname,
+		// in which case its contents are ignored.
+		Linkname string
 	}
 
 	// FileSet is a list of files used to create a build context
@@ -72,27 +149,223 @@ type (
 		// Dockerfile is the Dockerfile used to construct the container.
 		Dockerfile string
 
+		// Image, if set, is an existing image that the container is created
+		// from in place of building one from Dockerfile. Files are then
+		// copied into the container's working directory before it starts.
+		Image string
+
 		// Files holds the set of files to be transferred into the build context.
 		Files FileSet
 
+		// MaxFileSize and MaxContextSize, if positive, are the maximum
+		// size in bytes of each of the Files and of all of them, beyond
+		// which Execute fails before the image is built. The paths
+		// of Files are made relative, and must not refer to a parent
+		// of the directory they are written to.
+		MaxFileSize    int64
+		MaxContextSize int64
+
+		// InjectFiles copies Files into the container's working directory
+		// before it starts, as when it is created from Image, rather than
+		// into the build context, so the Dockerfile can't COPY them. The
+		// image then depends only on the Dockerfile, so that with a
+		// BuildCache, executions that differ only in their Files don't
+		// build an image at all.
+		InjectFiles bool
+
+		// ContextUID and ContextGID are the owner of the files in the build
+		// context. The default is root. Note that a Dockerfile's COPY makes
+		// files owned by root regardless, unless given a --chown flag.
+		ContextUID int
+		ContextGID int
+
 		// Cmd is the shell command to execute inside the container.
+		// It is passed as is to sh -c.
 		Cmd string
 
+		// Args, if non-empty, is the command to execute inside the container
+		// in place of Cmd, without a shell. Args[0] is the program, which
+		// is looked up in the container's PATH.
+		Args []string
+
+		// Steps, if non-empty, are run one after another in the same
+		// container in place of Cmd, stopping at the first that exits
+		// with a non-zero code or times out. The result is that of the
+		// last step run, and holds the result of each in its Steps.
+		Steps []Step
+
+		// StepsBudget and StepsCPUBudget, if positive, limit the wall-clock
+		// time and the CPU time that the Steps may use in total. Once
+		// either is used up, the step that is running is canceled, no
+		// more are run, and the result has StatusBudgetExceeded. The CPU
+		// time is sampled about once a second.
+		StepsBudget    time.Duration
+		StepsCPUBudget time.Duration
+
+		// WorkingDir is the directory the command runs in. If empty, it is
+		// the image's working directory, which is / unless the Dockerfile
+		// sets WORKDIR.
+		WorkingDir string
+
+		// User is the user the command runs as, in the form "user",
+		// "uid", "user:group", or "uid:gid". If empty, it is the image's
+		// user, which is root unless the Dockerfile sets USER.
+		User string
+
+		// Artifacts are patterns of absolute paths in the container, in the
+		// syntax of path.Match, of files to copy out of the container once
+		// the command exits. A pattern matching a directory matches every
+		// file below it. The files are returned in the result's Artifacts,
+		// with paths relative to the container's root directory.
+		Artifacts []string
+
 		// Timeout represents the timeout for the container to exit after
 		// it has been spawned. A Timeout < 0 means there is no timeout.
 		// If the timeout is reached before the container exits on its own,
-		// Execute will return a TimeoutError.
+		// the container is killed and Execute will return a TimeoutError.
 		Timeout time.Duration
 
+		// CPUTimeLimit, if positive, limits the CPU time the container's
+		// processes may use, unlike Timeout, which limits the wall-clock
+		// time. Each process is sent SIGXCPU once it has used the limit,
+		// rounded up to the second, unless Ulimits has a "cpu" limit,
+		// and the container is killed once its processes have used it
+		// in total, which is sampled about once a second. The result of
+		// a container that exceeded it has StatusCPUTimeExceeded.
+		CPUTimeLimit time.Duration
+
+		// GracePeriod, if positive, is the time between sending SIGTERM
+		// to a container that has timed out, or whose context is done,
+		// and killing it with SIGKILL. By default, it is killed with
+		// SIGKILL right away.
+		GracePeriod time.Duration
+
+		// IdleTimeout, if positive, closes an ExecSession or Pool made
+		// from the Executor once it has run no command for that long,
+		// and Keepalive hasn't been called, so that abandoned sandboxes
+		// don't accumulate.
+		IdleTimeout time.Duration
+
+		// Labels are added to the labels of the Executor's images and
+		// containers, which are also given the Label, LabelID, and
+		// LabelCreated labels.
+		Labels map[string]string
+
+		// AutoRemove has the daemon remove the container as soon as it
+		// exits, rather than Execute removing it once it has inspected it.
+		// The result then lacks the times and OOMKilled status reported
+		// by the daemon, and Artifacts can't be collected.
+		AutoRemove bool
+
+		// Memory limits the container's memory in bytes, and MemorySwap
+		// limits its memory plus swap, where -1 allows unlimited swap.
+		// A command killed for exceeding its memory has OOMKilled set
+		// in its result, and a Status of StatusOOMKilled.
+		//
+		// CPUQuota limits the microseconds of CPU time the container gets
+		// per CPUPeriod, which defaults to 100ms, and CPUShares is its
+		// weight relative to other containers when CPUs are contended.
+		//
+		// PidsLimit limits the number of processes in the container.
+		//
+		// Zero values impose no limit.
+		Memory     int64
+		MemorySwap int64
+		CPUQuota   int64
+		CPUPeriod  int64
+		CPUShares  int64
+		PidsLimit  int64
+
+		// DiskQuota, if positive, limits the size in bytes of the
+		// container's writable layer, so that the command can't fill the
+		// host's disk. Writes past it fail with ENOSPC, and if the command
+		// then fails, or reports ENOSPC on its standard error, Execute
+		// returns a DiskQuotaError. Files written to Tmpfs mounts are
+		// limited by the size of the mount instead. The daemon only
+		// supports it for the overlay2 storage driver on XFS mounted
+		// with pquota, and for the devicemapper, btrfs, and zfs drivers.
+		DiskQuota int64
+
+		// Ulimits are the resource limits of the container's processes,
+		// such as the number of files they may open, or the size of the
+		// files they may write.
+		Ulimits []Ulimit
+
 		// Seccomp is the security profile used to constrain system calls made
-		// from the container to the Linux kernel. The default profile is
-		// provided by docker.
+		// from the container to the Linux kernel, in the JSON format built
+		// by package seccomp. The default profile is provided by docker,
+		// and SEUnconfined disables seccomp.
 		Seccomp string
 
+		// AppArmorProfile is the name of the AppArmor profile loaded on the
+		// host that confines the container, or "unconfined". If empty,
+		// the daemon's default profile is used on hosts with AppArmor.
+		AppArmorProfile string
+
+		// SELinuxLabel sets a part of the container's SELinux label,
+		// such as "type:container_t" or "level:s0:c100,c200", or is
+		// "disable" to disable labeling. If empty, the daemon labels the
+		// container on hosts with SELinux.
+		SELinuxLabel string
+
+		// CapDrop and CapAdd are the Linux capabilities, such as "NET_RAW"
+		// or "ALL", that are dropped from and added to the container's
+		// default set.
+		CapDrop []string
+		CapAdd  []string
+
+		// NoNewPrivileges prevents the command from gaining privileges,
+		// such as through setuid binaries.
+		NoNewPrivileges bool
+
+		// ReadOnlyRootFS mounts the container's root filesystem read-only,
+		// in which case Files can't be copied into a container run from
+		// Image or with InjectFiles, and the command can only write to
+		// the mounts in Tmpfs, and those in Mounts that aren't ReadOnly.
+		ReadOnlyRootFS bool
+
+		// Policy, if non-nil, is checked before the Executor builds or
+		// runs anything, which fail with its error if it rejects the
+		// Executor's configuration. See StrictPolicy.
+		Policy Policy
+
+		// Tmpfs maps the paths of directories in the container to the
+		// options of tmpfs mounts on them, in the format of mount(8),
+		// such as "rw,noexec,nosuid,size=64m" for "/tmp".
+		Tmpfs map[string]string
+
+		// Mounts are filesystems mounted into the container, such as
+		// read-only datasets or toolchains that would otherwise be
+		// copied into the build context of every execution.
+		Mounts []Mount
+
 		// Net is the network mode for the container. The default mode
 		// is a bridge network.
 		Net Network
 
+		// AllowHosts are the hosts that a container in NetRestricted mode
+		// may reach. Each is a hostname, a wildcard like "*.example.com"
+		// that matches its subdomains, an IPv4 address, or an IPv4 CIDR
+		// whose prefix is a whole number of octets, like "10.1.0.0/16".
+		AllowHosts []string
+
+		// NetworkName, if set, is an existing user-defined network that
+		// the container is attached to in place of the network of Net,
+		// so that it can reach the other containers on it by name.
+		NetworkName string
+
+		// DNS are the addresses of the DNS servers the container uses,
+		// and DNSSearch its DNS search domains. If empty, they are the
+		// daemon's.
+		DNS       []string
+		DNSSearch []string
+
+		// ExposePorts maps ports of the container to the ports of the
+		// host's loopback interface that they are published on, where a
+		// host port of 0 lets the daemon choose a free one. The ports
+		// chosen can be found with Ports, and in the result's Ports.
+		ExposePorts map[int]int
+
 		// Stdout and Stderr specify the container's standard output and standard error.
 		//
 		// If either is nil, output will be written to the null device.
@@ -101,11 +374,241 @@ type (
 		Stdout io.Writer
 		Stderr io.Writer
 
-		cli   *client.Client
-		spath string
+		// Stdin specifies the container's standard input. If nil,
+		// the command reads from the null device.
+		Stdin io.Reader
+
+		// StdinPath names an entry in Files to be used as standard input
+		// in place of Stdin. The entry is streamed to the command when it
+		// runs, and is not copied into the build context.
+		StdinPath string
+
+		// Streams selects which of the container's output streams are
+		// copied to Stdout and Stderr. The other stream is not requested
+		// from the daemon at all. The default is both streams.
+		Streams Stream
+
+		// Timestamps requests the time at which each line of output was
+		// produced from the daemon. The times are stripped from the output
+		// and set on the corresponding stdout and stderr Events.
+		Timestamps bool
+
+		// StdoutSinks and StderrSinks receive the same output as Stdout
+		// and Stderr. Each sink is written to by its own goroutine from its
+		// own buffer, so a slow sink holds up neither the others nor the
+		// command: a sink that falls too far behind misses the output
+		// written until it catches up. A sink that returns an error
+		// receives no more output. Sinks that keep up have received the
+		// complete output by the time Execute returns, which waits at most
+		// a few seconds for the others.
+		StdoutSinks []io.Writer
+		StderrSinks []io.Writer
+
+		// MaxOutputBytes, if positive, is the maximum number of bytes of
+		// each of the standard output and standard error that is written
+		// to Stdout and Stderr and every other destination of output but
+		// OutputDir. The rest is discarded, and the result is marked as
+		// truncated. If KillOnOutputLimit is set, the container is then
+		// killed as well.
+		MaxOutputBytes    int64
+		KillOnOutputLimit bool
+
+		// OnStdoutLine and OnStderrLine, if non-nil, are called with each
+		// line of output of their stream as it is produced, without its
+		// newline, along with the time it was produced, which is exact
+		// if Timestamps is set. Lines longer than 64KiB are split. The
+		// line is only valid for the duration of the call.
+		OnStdoutLine func(line []byte, at time.Time)
+		OnStderrLine func(line []byte, at time.Time)
+
+		// OutputDir, if set, is a directory that the container's output
+		// is persisted to as the files stdout.log and stderr.log, which
+		// are written in full regardless of MaxOutputBytes, MaxLineLength,
+		// and Binary.
+		//
+		// OutputFileSize, if positive, caps the size of each file. A full
+		// file is rotated to the same name suffixed with .1, and earlier
+		// rotations are shifted up to a suffix of .OutputRotations, past
+		// which they are discarded.
+		//
+		// OutputCompress gzips the files, which are then named stdout.log.gz
+		// and stderr.log.gz. OutputFileSize counts uncompressed bytes. Use
+		// OpenOutput to read the files back.
+		OutputDir       string
+		OutputFileSize  int64
+		OutputRotations int
+		OutputCompress  bool
+
+		// MaxLineLength, if positive, is the maximum length in bytes of
+		// each line written to Stdout and Stderr. The rest of a longer
+		// line is discarded.
+		MaxLineLength int
+
+		// Binary determines how output that isn't valid UTF-8 is written
+		// to Stdout and Stderr. The default policy is BinaryPass.
+		Binary BinaryPolicy
+
+		// Backend is the container engine that runs the container. If nil,
+		// it is the Docker daemon selected by DockerContext. BackendWASM
+		// and NsjailBackend run the command without a container.
+		Backend Backend
+
+		// DockerContext is the name of a context configured with
+		// `docker context` whose daemon runs the container. If empty,
+		// the daemon is chosen by the DOCKER_HOST, DOCKER_CERT_PATH,
+		// DOCKER_TLS_VERIFY, and DOCKER_API_VERSION environment variables.
+		DockerContext string
+
+		// APIVersion pins the version of the Docker API used to talk to
+		// the daemon, such as "1.37". If empty, the client's latest version
+		// is used, unless NegotiateAPIVersion is set, in which case the
+		// highest version supported by both the client and the daemon is.
+		APIVersion          string
+		NegotiateAPIVersion bool
+
+		// Transport tunes the connections made to the daemon.
+		Transport TransportConfig
+
+		// DockerHost, if set, is the address of the daemon, such as
+		// "tcp://dind:2376" or "unix:///var/run/docker.sock", in place of
+		// the one chosen by the Backend. TLSConfig, if non-nil, secures
+		// the connection to it. The daemon of an "ssh://user@host" address
+		// is reached by running `docker system dial-stdio` on the host
+		// over ssh, which must be able to log in without a prompt.
+		DockerHost string
+		TLSConfig  *tls.Config
+
+		// Client, if non-nil, is used to talk to the daemon in place of
+		// a client configured by the fields above, such as one shared by
+		// a service or a fake for tests. It is not closed by eggsy.
+		Client *client.Client
+
+		// PullPolicy decides when the Image, or the base images of the
+		// Dockerfile, are pulled. By default, they are pulled if the
+		// daemon doesn't have them.
+		PullPolicy PullPolicy
+
+		// Platform is the platform that the image is built for and the
+		// command runs on, such as "linux/arm64". If it differs from the
+		// daemon's architecture, the command runs under qemu emulation
+		// registered with binfmt_misc, which is much slower. The default
+		// is the daemon's platform.
+		Platform string
+
+		// BuildArgs are the values of the ARG instructions of the
+		// Dockerfile, such as the version of a toolchain to install.
+		BuildArgs map[string]string
+
+		// Target, if set, is the stage of a multi-stage Dockerfile that
+		// the image is built from, so that a heavy stage that compiles
+		// the command can be left out of the image that runs it. By
+		// default, the image is the last stage.
+		Target string
+
+		// BuildOutput, if non-nil, receives the output of building the
+		// image, such as the output of the Dockerfile's RUN steps.
+		BuildOutput io.Writer
+
+		// BuildCache, if non-nil, holds the images built by Executors
+		// for reuse by later Executors with the same Dockerfile, Files,
+		// BuildArgs, Target, and Platform, which are otherwise built and
+		// removed each time.
+		BuildCache BuildCache
+
+		// BuildTimeout, if positive, cancels building the image once it
+		// takes longer, in which case Execute returns a BuildTimeoutError.
+		BuildTimeout time.Duration
+
+		// MaxImageSize and MaxImageLayers, if positive, limit the size in
+		// bytes and the number of layers of the built image, so that a
+		// Dockerfile can't leave a huge image on the daemon's host. An
+		// image that exceeds them is removed, and Execute returns an
+		// ImageLimitError.
+		MaxImageSize   int64
+		MaxImageLayers int
+
+		// BuildBudget, if non-nil, cancels building the image once it
+		// takes much longer than builds of the same Dockerfile have before,
+		// in which case Execute returns a BuildBudgetError.
+		BuildBudget *BuildBudget
+
+		// Runtime is the container runtime that runs the command. The
+		// default is gVisor's runsc. Execute fails with an error matching
+		// ErrRuntimeUnavailable if the daemon doesn't have the runtime.
+		Runtime Runtime
+
+		// FallbackRuntime is the container runtime used in place of Runtime
+		// on daemons that don't have it, such as Docker Desktop on macOS and
+		// Windows, which lack gVisor. It is meant for local development,
+		// since runtimes like runc don't isolate the command from the host's
+		// kernel; Probe reports when it will be used. The zero value means
+		// there is no fallback.
+		FallbackRuntime Runtime
+
+		// Tty allocates a pseudo-terminal for the command. The terminal's
+		// output is a single raw stream that is written to Stdout, and
+		// Stderr is unused. The terminal can be sized with Resize.
+		Tty bool
+
+		// Logger, if non-nil, logs the lifecycle of the execution, such
+		// as the image being built and the container exiting, along with
+		// failures to clean up after it.
+		Logger *slog.Logger
+
+		// Tracer, if non-nil, traces the execution, with a span for each of
+		// its phases as a child of the span of the caller's context.
+		Tracer trace.Tracer
+
+		// Events, if non-nil, receives every Event of the execution
+		// as a line of JSON.
+		//
+		// EventC, if non-nil, receives the same events. Sends on EventC
+		// block, so the channel must be drained while Execute runs.
+		Events io.Writer
+		EventC chan<- Event
+
+		cli      *client.Client
+		borrowed bool // whether cli is that of the Executor that built an ImageRef
+		runtime  string
+		emulated bool          // whether the Platform is emulated
+		stdin    io.ReadCloser // entry of Files named by StdinPath
+		em       *emitter
+		onUsage  func(*Usage)  // called with each sample of the container's stats
+		copied   chan struct{} // closed once all output is copied
+		written  int64         // bytes of output copied
+		outLimit *limitWriter  // limit of standard output, if any
+		errLimit *limitWriter  // limit of standard error, if any
+		noSpace  *matchWriter  // matches ENOSPC in standard error, with a DiskQuota
+		network  string        // restricted network of the container
+		env      []string      // environment of the container
+
+		mu     sync.Mutex
+		cID    string // running container, set once it has started
+		execID string // running exec of Steps or an ExecSession, if any
 	}
 )
 
+var (
+	// ErrTimeout matches every TimeoutError under errors.Is.
+	ErrTimeout = errors.New("eggsy: container has timed out")
+
+	// ErrDiskQuota matches every DiskQuotaError under errors.Is.
+	ErrDiskQuota = errors.New("eggsy: container has exceeded its disk quota")
+
+	// ErrUnsafePath is returned when the path of a file is empty or
+	// refers to a parent of the directory it is written to.
+	ErrUnsafePath = errors.New("eggsy: unsafe file path")
+
+	// ErrFileTooLarge and ErrContextTooLarge are returned when files
+	// exceed the Executor's MaxFileSize or MaxContextSize.
+	ErrFileTooLarge    = errors.New("eggsy: file is too large")
+	ErrContextTooLarge = errors.New("eggsy: files are too large")
+
+	// ErrNotRunning is returned when operating on an Executor
+	// whose container has not started or has already exited.
+	ErrNotRunning = errors.New("eggsy: container is not running")
+)
+
 type syncWriter struct {
 	m sync.Mutex
 	w io.Writer
@@ -118,6 +621,11 @@ func (s *syncWriter) Write(p []byte) (n int, err error) {
 	return
 }
 
+// DefaultRunTimeout is the Timeout of the commands run by the eggsy
+// command and the servers of packages eggsyhttp and rpc, unless their
+// caller asks for another.
+const DefaultRunTimeout = 10 * time.Second
+
 const (
 	NoTimeout time.Duration = -1
 
@@ -130,69 +638,298 @@ const (
 
 	// NetNone disables all network access in the container except to localhost.
 	NetNone Network = 1
+
+	// NetRestricted only allows the container to reach the AllowHosts,
+	// through an HTTP proxy given by the HTTP_PROXY and HTTPS_PROXY
+	// environment variables. The container is otherwise attached to an
+	// internal network of its own, with no route to the outside world.
+	NetRestricted Network = 2
+
+	// RuntimeRunsc is gVisor's runtime, which runs containers on a
+	// user-space kernel. It is the default runtime.
+	RuntimeRunsc Runtime = "runsc"
+
+	// RuntimeRunc is the standard runtime, which doesn't isolate
+	// containers from the host's kernel.
+	RuntimeRunc Runtime = "runc"
+
+	// RuntimeKata is the Kata Containers runtime, which runs each
+	// container in a lightweight virtual machine.
+	RuntimeKata Runtime = "kata-runtime"
+
+	// RuntimeKataFC is the Kata Containers runtime configured to use the
+	// Firecracker hypervisor, under the name it is conventionally given
+	// in the daemon's configuration.
+	RuntimeKataFC Runtime = "kata-fc"
+
+	// RuntimeMicroVM is whichever of the Kata Containers runtimes the
+	// daemon has, preferring Firecracker. The guest kernel and root
+	// filesystem images are those of Kata's configuration on the host.
+	RuntimeMicroVM Runtime = "microvm"
+
+	// RuntimeDefault is whichever runtime the daemon defaults to.
+	RuntimeDefault Runtime = "default"
+
+	// StreamStdout and StreamStderr select the container's standard
+	// output and standard error. They may be or'ed together.
+	StreamStdout Stream = 1 << 0
+	StreamStderr Stream = 1 << 1
 )
 
+func (r Runtime) name() string {
+	if r == "" {
+		return string(RuntimeRunsc)
+	}
+	return string(r)
+}
+
+// vm reports whether the runtime isolates containers in virtual machines.
+func (r Runtime) vm() bool { return strings.HasPrefix(string(r), "kata") }
+
+func (s Stream) has(t Stream) bool { return s == 0 || s&t != 0 }
+
+// String returns the name of the network mode, such as "none".
+func (n Network) String() string {
+	switch n {
+	case NetBridge:
+		return "bridge"
+	case NetNone:
+		return "none"
+	case NetRestricted:
+		return "restricted"
+	}
+	return fmt.Sprintf("Network(%d)", int(n))
+}
+
 func (n Network) mode() container.NetworkMode {
 	switch n {
 	case 0:
 		return "bridge"
-	case 1:
+	case 1, 2:
+		// a restricted container's network is chosen by restrict
 		return "none"
 	default:
 		panic(fmt.Sprintf("(%v) doesn't have a corresponding network mode", n))
 	}
 }
 
-func (t TimeoutError) Error() string { return string(t) }
+func (t *TimeoutError) Error() string {
+	return fmt.Sprintf("process %q in container %s from image %s has timed out", t.Cmd, t.ContainerID, t.Image)
+}
+
+// Is reports whether target is ErrTimeout, so that
+// errors.Is(err, ErrTimeout) matches any TimeoutError.
+func (t *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+func (c *ContextError) Error() string {
+	return fmt.Sprintf("process %q in container %s from image %s was aborted: %v", c.Cmd, c.ContainerID, c.Image, c.Err)
+}
+
+func (c *ContextError) Unwrap() error { return c.Err }
+
+func (d *DiskQuotaError) Error() string {
+	return fmt.Sprintf("process %q in container %s from image %s has exceeded its disk quota of %d bytes", d.Cmd, d.ContainerID, d.Image, d.Quota)
+}
+
+// Is reports whether target is ErrDiskQuota, so that
+// errors.Is(err, ErrDiskQuota) matches any DiskQuotaError.
+func (d *DiskQuotaError) Is(target error) bool { return target == ErrDiskQuota }
 
-func (e *Executor) makeBuildContext() (io.Reader, error) {
-	var rb, buf bytes.Buffer
+// makeBuildContext archives the Executor's files, along with its
+// Dockerfile unless the container is created from Image. If the container
+// is created from Image, or InjectFiles is set, the files are archived on
+// their own to be copied into the container, and the build context holds
+// only the Dockerfile.
+func (e *Executor) makeBuildContext() (bc *bytes.Buffer, files []byte, err error) {
+	inject := e.Image != "" || e.InjectFiles
+	var rb bytes.Buffer
 	tw := tar.NewWriter(&rb)
-	n := e.Files.Len()
+	if err := e.writeFiles(tw, e.Files); err != nil {
+		return nil, nil, err
+	}
+	if !inject {
+		writeDockerfile(tw, e.Dockerfile)
+	}
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if !inject {
+		return &rb, nil, nil
+	}
+	files = rb.Bytes()
+	if e.Image != "" {
+		return nil, files, nil
+	}
+	bc = new(bytes.Buffer)
+	tw = tar.NewWriter(bc)
+	writeDockerfile(tw, e.Dockerfile)
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return bc, files, nil
+}
+
+// streamBuildContext archives the Executor's files and Dockerfile as the
+// returned reader is read, so that large files aren't held in memory and
+// the daemon receives the archive right away. wait closes the reader,
+// and returns the error archiving the files failed with, if any.
+func (e *Executor) streamBuildContext() (r io.Reader, wait func() error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := e.writeFiles(tw, e.Files)
+		if err == nil {
+			writeDockerfile(tw, e.Dockerfile)
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	return pr, func() error {
+		pr.Close()
+		return <-done
+	}
+}
+
+func writeDockerfile(tw *tar.Writer, dockerfile string) {
+	tw.WriteHeader(&tar.Header{
+		Name: "Dockerfile",
+		Mode: 0666,
+		Size: int64(len(dockerfile)),
+	})
+	tw.Write([]byte(dockerfile))
+}
+
+// writeFiles writes files to tw, except for the entry named by StdinPath.
+func (e *Executor) writeFiles(tw *tar.Writer, files FileSet) error {
+	if files == nil {
+		return nil
+	}
+	var (
+		buf   bytes.Buffer
+		total int64
+	)
+	n := files.Len()
 	for i := 0; i < n; i++ {
-		f, err := e.Files.At(i)
+		f, err := files.At(i)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		defer f.Close()
-		path := filepath.Clean(f.Path)
+		name, err := cleanPath(f.Path)
 		if err != nil {
-			return nil, err
+			closeFile(f)
+			return err
 		}
-		buf.Reset()
-		size, err := io.Copy(&buf, f)
+		if sp, _ := cleanPath(e.StdinPath); e.StdinPath != "" && name == sp {
+			// delivered at run time instead of being baked into the image
+			e.stdin = f.ReadCloser
+			continue
+		}
+		size, err := e.writeFile(tw, f, name, &buf)
+		closeFile(f)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if total += size; e.MaxContextSize > 0 && total > e.MaxContextSize {
+			return fmt.Errorf("%w: files are larger than %d bytes", ErrContextTooLarge, e.MaxContextSize)
 		}
-		tw.WriteHeader(&tar.Header{
-			Name: path,
-			Mode: 0666,
-			Size: size,
-		})
-		io.Copy(tw, &buf)
 	}
-	tw.WriteHeader(&tar.Header{
-		Name: "Dockerfile",
-		Mode: 0666,
-		Size: int64(len(e.Dockerfile)),
-	})
-	tw.Write([]byte(e.Dockerfile))
-	if e.Seccomp != SEDefault && e.Seccomp != SEUnconfined {
-		e.spath = randN(8) + ".json"
-		tw.WriteHeader(&tar.Header{
-			Name: e.spath,
-			Mode: 0666,
-			Size: int64(len(e.Seccomp)),
-		})
-		tw.Write([]byte(e.Seccomp))
+	return nil
+}
+
+// closeFile closes the contents of f, which a directory or a symbolic
+// link needn't have.
+func closeFile(f File) {
+	if f.ReadCloser != nil {
+		f.Close()
+	}
+}
+
+// writeFile writes f to tw under name, and returns its size. A file whose
+// size can't be known in advance is read into buf before it is written.
+func (e *Executor) writeFile(tw *tar.Writer, f File, name string, buf *bytes.Buffer) (int64, error) {
+	hdr := &tar.Header{
+		Name: name,
+		Mode: int64(f.Mode.Perm()),
+		Uid:  e.ContextUID,
+		Gid:  e.ContextGID,
 	}
-	if e.Seccomp == SEUnconfined {
-		e.spath = "unconfined.json"
+	if hdr.Mode == 0 {
+		hdr.Mode = 0666
+		if f.Mode.IsDir() {
+			hdr.Mode = 0777
+		}
 	}
-	if err := tw.Close(); err != nil {
-		return nil, err
+	switch {
+	case f.Mode.IsDir():
+		hdr.Typeflag, hdr.Name = tar.TypeDir, name+"/"
+		return 0, tw.WriteHeader(hdr)
+	case f.Linkname != "":
+		hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, f.Linkname
+		return 0, tw.WriteHeader(hdr)
+	}
+	var r io.Reader = f
+	size, known := sizeOf(f.ReadCloser)
+	if !known {
+		buf.Reset()
+		lr := r
+		if e.MaxFileSize > 0 {
+			lr = io.LimitReader(r, e.MaxFileSize+1)
+		}
+		var err error
+		if size, err = io.Copy(buf, lr); err != nil {
+			return 0, err
+		}
+		r = buf
+	}
+	if e.MaxFileSize > 0 && size > e.MaxFileSize {
+		return 0, fmt.Errorf("%w: %s is larger than %d bytes", ErrFileTooLarge, name, e.MaxFileSize)
 	}
-	return &rb, nil
+	hdr.Size = size
+	if err := tw.WriteHeader(hdr); err != nil {
+		return 0, err
+	}
+	// a file that shrinks while it is read fails the archive
+	_, err := io.CopyN(tw, r, size)
+	return size, err
+}
+
+// sizeOf returns the size of the data left in r, if it can be known
+// without reading it, such as for an open file or a strings.Reader.
+func sizeOf(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case interface{ Stat() (fs.FileInfo, error) }:
+		fi, err := r.Stat()
+		if err != nil || !fi.Mode().IsRegular() {
+			break
+		}
+		var off int64
+		if s, ok := r.(io.Seeker); ok {
+			// the file may have been read from already
+			if off, err = s.Seek(0, io.SeekCurrent); err != nil {
+				break
+			}
+		}
+		return fi.Size() - off, true
+	}
+	return 0, false
+}
+
+// cleanPath returns the path of a file relative to the directory it is
+// written to, or an error if it is empty or outside of the directory.
+func cleanPath(p string) (string, error) {
+	rel := path.Clean(filepath.ToSlash(p))
+	c := path.Clean("/" + rel)
+	switch {
+	case p == "" || c == "/":
+		return "", fmt.Errorf("%w: %q names no file", ErrUnsafePath, p)
+	case rel == ".." || strings.HasPrefix(rel, "../"):
+		return "", fmt.Errorf("%w: %q is outside of its directory", ErrUnsafePath, p)
+	}
+	return c[1:], nil
 }
 
 func randN(n int) string {
@@ -201,116 +938,811 @@ func randN(n int) string {
 	return hex.EncodeToString(b)
 }
 
-func (e *Executor) runContainer(ctx context.Context, tag, cID string) (err error) {
-	t := int(e.Timeout.Seconds())
-	if e.Timeout < 0 {
-		t = -1
+// argv returns the command line of the Executor's command.
+func (e *Executor) argv() strslice.StrSlice {
+	if len(e.Args) > 0 {
+		return strslice.StrSlice(e.Args)
+	}
+	return strslice.StrSlice{"sh", "-c", e.Cmd}
+}
+
+// command returns the Executor's command for use in errors.
+func (e *Executor) command() string {
+	if len(e.Args) > 0 {
+		return strings.Join(e.Args, " ")
+	}
+	return e.Cmd
+}
+
+// hostConfig returns the configuration of the Executor's containers.
+func (e *Executor) hostConfig() *container.HostConfig {
+	mode := e.Net.mode()
+	switch {
+	case e.network != "":
+		mode = container.NetworkMode(e.network)
+	case e.NetworkName != "":
+		mode = container.NetworkMode(e.NetworkName)
 	}
 	// gvisor
 	hc := &container.HostConfig{
-		NetworkMode: e.Net.mode(),
-		Runtime:     "runsc",
+		NetworkMode:    mode,
+		Runtime:        e.runtime,
+		AutoRemove:     e.AutoRemove,
+		PortBindings:   e.portBindings(),
+		Mounts:         e.mounts(),
+		DNS:            e.DNS,
+		DNSSearch:      e.DNSSearch,
+		CapDrop:        e.CapDrop,
+		CapAdd:         e.CapAdd,
+		ReadonlyRootfs: e.ReadOnlyRootFS,
+		Tmpfs:          e.Tmpfs,
+		Resources: container.Resources{
+			Memory:     e.Memory,
+			MemorySwap: e.MemorySwap,
+			CPUQuota:   e.CPUQuota,
+			CPUPeriod:  e.CPUPeriod,
+			CPUShares:  e.CPUShares,
+			PidsLimit:  e.PidsLimit,
+		},
 	}
 	if e.Seccomp != SEDefault {
-		hc.SecurityOpt = []string{"seccomp=" + e.spath}
+		// the daemon takes the profile itself, not a path to it
+		hc.SecurityOpt = []string{"seccomp=" + e.Seccomp}
+	}
+	if e.AppArmorProfile != "" {
+		hc.SecurityOpt = append(hc.SecurityOpt, "apparmor="+e.AppArmorProfile)
+	}
+	if e.SELinuxLabel != "" {
+		hc.SecurityOpt = append(hc.SecurityOpt, "label="+e.SELinuxLabel)
+	}
+	if e.NoNewPrivileges {
+		hc.SecurityOpt = append(hc.SecurityOpt, "no-new-privileges")
+	}
+	if e.DiskQuota > 0 {
+		hc.StorageOpt = map[string]string{"size": strconv.FormatInt(e.DiskQuota, 10)}
+	}
+	hasCPU := false
+	for _, u := range e.Ulimits {
+		hc.Ulimits = append(hc.Ulimits, &units.Ulimit{Name: u.Name, Soft: u.Soft, Hard: u.Hard})
+		hasCPU = hasCPU || u.Name == "cpu"
 	}
+	if e.CPUTimeLimit > 0 && !hasCPU {
+		// RLIMIT_CPU sends SIGXCPU at the soft limit, and SIGKILL at the hard one
+		secs := int64((e.CPUTimeLimit + time.Second - 1) / time.Second)
+		hc.Ulimits = append(hc.Ulimits, &units.Ulimit{Name: "cpu", Soft: secs, Hard: secs + 1})
+	}
+	return hc
+}
+
+// HardenedDefaults configures e for running untrusted code, such as in a
+// judge: it drops all capabilities, sets NoNewPrivileges, disables the
+// network, and limits the container to 128 processes unless PidsLimit
+// is already set. The Executors of package preset are configured by it.
+func HardenedDefaults(e *Executor) {
+	e.CapDrop = []string{"ALL"}
+	e.CapAdd = nil
+	e.NoNewPrivileges = true
+	e.Net = NetNone
+	if e.PidsLimit == 0 {
+		e.PidsLimit = 128
+	}
+}
+
+// runContainer creates and starts the container from the image tag. If files
+// is non-nil, it is a tar archive copied into the container before it starts.
+func (e *Executor) runContainer(ctx context.Context, tag, cID string, files io.Reader) (err error) {
+	hc := e.hostConfig()
+	stdin := e.Stdin
+	if e.stdin != nil {
+		stdin = e.stdin
+	}
+	cctx, span := e.startSpan(ctx, "eggsy.ContainerCreate")
 	_, err = e.cli.ContainerCreate(
-		ctx, &container.Config{
+		cctx, &container.Config{
+			AttachStdin:  stdin != nil,
 			AttachStdout: true,
 			AttachStderr: true,
-			// TODO: is this correct quoting of a shell command?
-			Cmd:         strslice.StrSlice{"sh", "-c", fmt.Sprintf("\"%q\"", e.Cmd)},
-			Image:       tag,
-			StopTimeout: &t,
+			OpenStdin:    stdin != nil,
+			StdinOnce:    true,
+			Tty:          e.Tty,
+			Cmd:          e.argv(),
+			Env:          e.env,
+			ExposedPorts: e.exposedPorts(),
+			WorkingDir:   e.WorkingDir,
+			User:         e.User,
+			Image:        tag,
+			Labels:       e.labels(cID),
 		}, hc, nil, cID)
+	endSpan(span, err)
 	if err != nil {
 		return err
 	}
-	err = e.cli.ContainerStart(ctx, cID, types.ContainerStartOptions{})
+	defer func() {
+		if err != nil {
+			e.removeContainer(cID)
+		}
+	}()
+	if files != nil {
+		dir := e.workDir(ctx, tag)
+		if err := e.cli.CopyToContainer(ctx, cID, dir, files, types.CopyToContainerOptions{}); err != nil {
+			return err
+		}
+	}
+	if stdin != nil {
+		// attach before starting so that no input is lost
+		hj, err := e.cli.ContainerAttach(ctx, cID, types.ContainerAttachOptions{
+			Stream: true,
+			Stdin:  true,
+		})
+		if err != nil {
+			return err
+		}
+		go func() {
+			io.Copy(hj.Conn, stdin)
+			hj.CloseWrite()
+			hj.Close()
+		}()
+	}
+	sctx, span := e.startSpan(ctx, "eggsy.ContainerStart")
+	err = e.cli.ContainerStart(sctx, cID, types.ContainerStartOptions{})
+	endSpan(span, err)
 	if err != nil {
-		e.cli.ContainerStop(ctx, cID, nil)
 		return err
 	}
+	e.setRunning(cID)
 	// demux output stream into stdout and stderr
-	muxRC, err := e.cli.ContainerLogs(ctx, cID, types.ContainerLogsOptions{
+	lctx, span := e.startSpan(ctx, "eggsy.ContainerLogs")
+	muxRC, err := e.cli.ContainerLogs(lctx, cID, types.ContainerLogsOptions{
 		Follow:     true,
-		ShowStdout: true,
-		ShowStderr: true,
+		ShowStdout: e.Streams.has(StreamStdout),
+		ShowStderr: e.Streams.has(StreamStderr),
+		Timestamps: e.Timestamps,
+	})
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+	stdout, stderr, flush, err := e.wrapOutput()
+	if err != nil {
+		muxRC.Close()
+		endSpan(span, err)
+		return err
+	}
+	e.em.emit(Event{Type: EventStart})
+	e.copied = make(chan struct{})
+	go func() {
+		defer close(e.copied)
+		defer endSpan(span, nil)
+		defer flush()
+		defer muxRC.Close()
+		if e.Tty {
+			// a terminal's output is not multiplexed
+			e.written, _ = io.Copy(stdout, muxRC)
+			return
+		}
+		e.written, _ = stdcopy.StdCopy(stdout, stderr, muxRC)
+	}()
+	return nil
+}
+
+func (e *Executor) setRunning(cID string) {
+	e.mu.Lock()
+	e.cID = cID
+	e.mu.Unlock()
+}
+
+// Signal sends the signal sig, such as "SIGINT", to the running command,
+// so that callers can forward the signals they receive. Like any process
+// with PID 1, the command ignores signals it has no handler for, even
+// SIGINT and SIGTERM. With Steps, the signal is sent to the processes
+// of the step that is running instead. Signal returns ErrNotRunning if
+// the container has not started or has exited.
+func (e *Executor) Signal(ctx context.Context, sig string) error {
+	e.mu.Lock()
+	cID := e.cID
+	e.mu.Unlock()
+	if cID == "" {
+		return ErrNotRunning
+	}
+	if len(e.Steps) > 0 {
+		return e.signalSteps(ctx, cID, sig)
+	}
+	return e.cli.ContainerKill(ctx, cID, sig)
+}
+
+// signalSteps sends sig to every process of the container but its first,
+// which only keeps the container running between steps.
+func (e *Executor) signalSteps(ctx context.Context, cID, sig string) error {
+	ex, err := e.cli.ContainerExecCreate(ctx, cID, types.ExecConfig{
+		Cmd:  []string{"kill", "-s", strings.TrimPrefix(sig, "SIG"), "-1"},
+		User: e.User,
 	})
 	if err != nil {
 		return err
 	}
-	if e.Stdout == nil {
-		e.Stdout = ioutil.Discard
+	return e.cli.ContainerExecStart(ctx, ex.ID, types.ExecStartCheck{})
+}
+
+// exposedPorts returns the ports of the container in ExposePorts.
+func (e *Executor) exposedPorts() nat.PortSet {
+	if len(e.ExposePorts) == 0 {
+		return nil
+	}
+	ps := make(nat.PortSet)
+	for cp := range e.ExposePorts {
+		ps[nat.Port(strconv.Itoa(cp)+"/tcp")] = struct{}{}
+	}
+	return ps
+}
+
+// portBindings returns the ports of the host that ExposePorts are
+// published on.
+func (e *Executor) portBindings() nat.PortMap {
+	if len(e.ExposePorts) == 0 {
+		return nil
+	}
+	pm := make(nat.PortMap)
+	for cp, hp := range e.ExposePorts {
+		b := nat.PortBinding{HostIP: "127.0.0.1"}
+		if hp != 0 {
+			b.HostPort = strconv.Itoa(hp)
+		}
+		pm[nat.Port(strconv.Itoa(cp)+"/tcp")] = []nat.PortBinding{b}
+	}
+	return pm
+}
+
+// Ports returns the ports of the host that the running container's
+// ExposePorts are published on, keyed by the ports of the container.
+// It returns ErrNotRunning if the container has not started or has
+// exited.
+func (e *Executor) Ports(ctx context.Context) (map[int]int, error) {
+	e.mu.Lock()
+	cID := e.cID
+	e.mu.Unlock()
+	if cID == "" {
+		return nil, ErrNotRunning
+	}
+	return e.hostPorts(ctx, cID)
+}
+
+func (e *Executor) hostPorts(ctx context.Context, cID string) (map[int]int, error) {
+	cj, err := e.cli.ContainerInspect(ctx, cID)
+	if err != nil {
+		return nil, err
+	}
+	ports := make(map[int]int)
+	if cj.NetworkSettings == nil {
+		return ports, nil
 	}
-	if e.Stderr == nil {
-		e.Stderr = ioutil.Discard
+	for p, bs := range cj.NetworkSettings.Ports {
+		for _, b := range bs {
+			if hp, err := strconv.Atoi(b.HostPort); err == nil {
+				ports[p.Int()] = hp
+				break
+			}
+		}
 	}
-	if e.Stdout == e.Stderr {
-		e.Stdout = &syncWriter{w: e.Stdout}
-		e.Stderr = e.Stdout
+	return ports, nil
+}
+
+// Resize sets the size of the running container's terminal to the given
+// number of rows and columns. With Steps, the terminal of the step that
+// is running is sized instead. It returns ErrNotRunning if the container
+// has not started or has exited, and is only meaningful when Tty is set.
+func (e *Executor) Resize(ctx context.Context, rows, cols uint) error {
+	e.mu.Lock()
+	cID, execID := e.cID, e.execID
+	e.mu.Unlock()
+	opts := types.ResizeOptions{Height: rows, Width: cols}
+	if execID != "" {
+		return e.cli.ContainerExecResize(ctx, execID, opts)
 	}
-	go stdcopy.StdCopy(e.Stdout, e.Stderr, muxRC)
+	if cID == "" {
+		return ErrNotRunning
+	}
+	return e.cli.ContainerResize(ctx, cID, opts)
+}
+
+// setExec records the exec whose terminal Resize sizes, if any.
+func (e *Executor) setExec(id string) {
+	e.mu.Lock()
+	e.execID = id
+	e.mu.Unlock()
+}
+
+// ImageRef refers to an image that commands can be run from, as returned
+// by Build. Executors that Run an ImageRef share the connection to the
+// daemon of the Executor that built it.
+type ImageRef struct {
+	// Tag is the tag of the image.
+	Tag string
+
+	files    []byte // archive of files copied into containers run from Image
+	keep     bool   // whether the image outlives the ImageRef
+	cli      *client.Client
+	runtime  string
+	emulated bool
+}
+
+// WithFiles returns a reference to the same image, whose containers are
+// given files in addition to those of r, such as the input of a single
+// run. Its files are written as an Executor with no limits would.
+func (r ImageRef) WithFiles(files FileSet) (ImageRef, error) {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	if r.files != nil {
+		tr := tar.NewReader(bytes.NewReader(r.files))
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return ImageRef{}, err
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return ImageRef{}, err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return ImageRef{}, err
+			}
+		}
+	}
+	if err := new(Executor).writeFiles(tw, files); err != nil {
+		return ImageRef{}, err
+	}
+	if err := tw.Close(); err != nil {
+		return ImageRef{}, err
+	}
+	r.files = b.Bytes()
+	return r, nil
+}
+
+// Build builds the Executor's image, so that Run can run commands from
+// it any number of times, and returns a reference to it. If Image is
+// set, it is used instead, and Files are copied into each container run
+// from it. The entry of Files named by StdinPath is not used by Run;
+// its input must be given by Stdin instead. The image must be released
+// with Remove once it is no longer needed.
+func (e *Executor) Build(ctx context.Context) (ref ImageRef, err error) {
+	e.em = newEmitter(e.Events, e.EventC)
+	defer func() {
+		if err != nil {
+			e.em.emit(Event{Type: EventError, Status: StatusOf(err), Error: err.Error()})
+		}
+	}()
+	if err := e.checkPolicy(); err != nil {
+		return ImageRef{}, err
+	}
+	ref, err = e.buildImage(ctx)
+	if err == nil {
+		ref.cli, ref.runtime, ref.emulated = e.cli, e.runtime, e.emulated
+	}
+	if e.stdin != nil {
+		e.stdin.Close()
+		e.stdin = nil
+	}
+	return ref, err
+}
+
+// Run executes the Executor's command in a container created from ref,
+// and waits for the container to exit, as Execute does. The Executor's
+// Dockerfile, Image, and Files are ignored. Unless the Executor has a
+// Client, it uses the connection and runtime of the Executor that built
+// ref, which must not be closed until Run returns.
+func (e *Executor) Run(ctx context.Context, ref ImageRef) (res *ExecResult, err error) {
+	e.em = newEmitter(e.Events, e.EventC)
+	defer func() { e.em.finish(res, err) }()
+	if err := e.checkPolicy(); err != nil {
+		return nil, err
+	}
+	switch {
+	case e.cli != nil:
+	case ref.cli != nil && e.Client == nil:
+		e.cli, e.borrowed = ref.cli, true
+		e.runtime, e.emulated = ref.runtime, ref.emulated
+	default:
+		if err := e.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(e.Steps) > 0 {
+		return e.runSteps(ctx, ref)
+	}
+	return e.runImage(ctx, ref)
+}
+
+// Close closes the connection to the daemon that Build, Run, or Remove
+// opened, if any. The Executor's Client is not closed, nor is the
+// connection of the Executor that built an ImageRef given to Run.
+func (e *Executor) Close() error {
+	if e.cli != nil && !e.borrowed {
+		e.closeClient(e.cli)
+	}
+	e.cli, e.borrowed = nil, false
 	return nil
 }
 
+// Remove removes the image of ref, unless it is the Executor's Image
+// or is held by its BuildCache.
+func (e *Executor) Remove(ctx context.Context, ref ImageRef) error {
+	if ref.keep {
+		return nil
+	}
+	if e.cli == nil {
+		if err := e.connect(ctx); err != nil {
+			return err
+		}
+	}
+	_, err := e.cli.ImageRemove(ctx, ref.Tag, types.ImageRemoveOptions{Force: true})
+	return err
+}
+
 // Execute takes in a context, executes the Executor's command
 // in a container, and waits for the container to exit. The timeout
 // of the provided context is different from the timeout of the
-// container. Execute will return a TimeoutError on a container timeout.
-func (e *Executor) Execute(ctx context.Context) (err error) {
-	bc, err := e.makeBuildContext()
+// container. Execute will return a TimeoutError on a container timeout,
+// and a ContextError if ctx is done before the container exits.
+//
+// Once the command has run, Execute returns its result, even if the
+// command exited with a non-zero code or timed out.
+func (e *Executor) Execute(ctx context.Context) (res *ExecResult, err error) {
+	ctx, span := e.startSpan(ctx, "eggsy.Execute")
+	defer func() { endSpan(span, err) }()
+	e.em = newEmitter(e.Events, e.EventC)
+	defer func() { e.em.finish(res, err) }()
+	if err := e.checkPolicy(); err != nil {
+		return nil, err
+	}
+	switch b := e.Backend.(type) {
+	case WASMBackend:
+		return e.executeWASM(ctx, b)
+	case NsjailBackend:
+		return e.executeNsjail(ctx, b)
+	}
+	if e.cli == nil {
+		// the client Execute connects with is its own to close
+		defer func() {
+			if e.cli != nil {
+				e.closeClient(e.cli)
+			}
+		}()
+	}
+	ref, err := e.buildImage(ctx)
+	if e.stdin != nil {
+		defer e.stdin.Close()
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if e.cli, err = client.NewClientWithOpts(client.FromEnv); err != nil {
-		return err
+	defer e.removeImage(ref)
+	if len(e.Steps) > 0 {
+		return e.runSteps(ctx, ref)
 	}
-	// generate image and container IDs
-	tag := randN(16)
-	cID := randN(16)
+	return e.runImage(ctx, ref)
+}
 
+// buildImage connects to the daemon, and builds the Executor's image
+// unless it is cached or the Executor runs from its Image.
+func (e *Executor) buildImage(ctx context.Context) (ImageRef, error) {
+	// without a cache key to compute, the build context is streamed
+	stream := e.Image == "" && !e.InjectFiles && e.BuildCache == nil
+	var (
+		bc    *bytes.Buffer
+		files []byte
+		err   error
+	)
+	if !stream {
+		_, span := e.startSpan(ctx, "eggsy.makeBuildContext")
+		bc, files, err = e.makeBuildContext()
+		endSpan(span, err)
+		if err != nil {
+			return ImageRef{}, err
+		}
+	}
+	if e.cli == nil {
+		if err := e.connect(ctx); err != nil {
+			return ImageRef{}, err
+		}
+	}
+	if e.Image != "" {
+		if err := e.ensureImage(ctx, e.cli, e.Image); err != nil {
+			return ImageRef{}, err
+		}
+		return ImageRef{Tag: e.Image, files: files, keep: true}, nil
+	}
+	if e.PullPolicy == PullNever {
+		// the daemon would pull them as it builds
+		for _, image := range e.baseImages() {
+			if err := e.ensureImage(ctx, e.cli, image); err != nil {
+				return ImageRef{}, err
+			}
+		}
+	}
+	var key string
+	if e.BuildCache != nil {
+		key = e.cacheKey(bc.Bytes())
+		if tag, ok := e.cached(ctx, key); ok {
+			e.logger().Debug("eggsy: image reused from cache", "image", tag)
+			return ImageRef{Tag: tag, files: files, keep: true}, nil
+		}
+	}
 	// Build image from Dockerfile in environment
-	r, err := e.cli.ImageBuild(ctx, bc, types.ImageBuildOptions{Tags: []string{tag}})
+	tag := randN(16)
+	e.em.emit(Event{Type: EventBuild})
+	bctx, span := e.startSpan(ctx, "eggsy.ImageBuild")
+	start := time.Now()
+	if stream {
+		r, wait := e.streamBuildContext()
+		err = e.build(bctx, r, tag)
+		if werr := wait(); werr != nil && werr != io.ErrClosedPipe {
+			// the daemon only saw the archive end early
+			err = werr
+		}
+	} else {
+		err = e.build(bctx, bc, tag)
+	}
+	endSpan(span, err)
 	if err != nil {
-		return err
+		e.logger().Info("eggsy: image build failed", "image", tag, "err", err)
+		return ImageRef{}, err
+	}
+	e.logger().Info("eggsy: image built", "image", tag, "duration", time.Since(start))
+	ref := ImageRef{Tag: tag, files: files}
+	if e.BuildCache != nil && e.BuildCache.Put(key, tag) == nil {
+		ref.keep = true
+	}
+	return ref, nil
+}
+
+// runImage runs the Executor's command in a container created from ref.
+func (e *Executor) runImage(ctx context.Context, ref ImageRef) (res *ExecResult, err error) {
+	tag := ref.Tag
+	cID := randN(16)
+	var files io.Reader
+	if ref.files != nil {
+		files = bytes.NewReader(ref.files)
+	}
+
+	if e.Net == NetRestricted {
+		release, err := e.restrict(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
 	}
-	io.Copy(ioutil.Discard, r.Body)
-	defer e.cli.ImageRemove(ctx, tag, types.ImageRemoveOptions{Force: true})
 
 	// Run container from image with cmd
-	t0 := time.Now().Format(time.RFC3339Nano)
-	err = e.runContainer(ctx, tag, cID)
+	start := time.Now()
+	t0 := start.Format(time.RFC3339Nano)
+	err = e.runContainer(ctx, tag, cID, files)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	e.cli.ContainerStop(ctx, cID, nil)
-	cx, cancel := context.WithCancel(ctx)
-	// Detect timeout
-	cm, cer := e.cli.Events(cx, types.EventsOptions{
-		Since: t0,
-		Filters: filters.NewArgs(
-			filters.KeyValuePair{"container", cID},
-			filters.KeyValuePair{"image", tag},
-			filters.KeyValuePair{"event", "die"},
-		),
+	var ports map[int]int
+	if len(e.ExposePorts) > 0 {
+		ports, _ = e.hostPorts(ctx, cID)
+	}
+	e.logger().Info("eggsy: container started", "container", cID, "image", tag)
+	var cpuExceeded int32
+	usage := e.watchStats(ctx, cID, func(u *Usage) {
+		if e.CPUTimeLimit > 0 && u.CPU() > e.CPUTimeLimit && atomic.CompareAndSwapInt32(&cpuExceeded, 0, 1) {
+			e.cli.ContainerKill(context.Background(), cID, "SIGKILL")
+		}
+		if e.onUsage != nil {
+			e.onUsage(u)
+		}
+		e.em.emit(statsEvent(u))
 	})
-	for {
-		select {
-		case m := <-cm:
-			cancel()
-			ec, err := strconv.Atoi(m.Actor.Attributes["exitCode"])
-			if err != nil {
-				return err
-			}
-			if ec == 137 {
-				return TimeoutError(fmt.Sprintf("process %q in container %s from image %s has timed out", e.Cmd, cID, tag))
+	if !e.AutoRemove {
+		defer e.removeContainer(cID)
+	}
+	defer e.setRunning("")
+	var timedOut int32
+	if e.Timeout >= 0 {
+		kctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		t := time.AfterFunc(e.Timeout, func() { e.kill(kctx, cID, &timedOut) })
+		defer t.Stop()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, e.abort(tag, cID, err)
+	}
+	image := tag
+	if e.Image != "" {
+		// an existing Image may be reported under another name
+		image = ""
+	}
+	wctx, span := e.startSpan(ctx, "eggsy.wait")
+	ec, err := e.wait(wctx, cID, image, t0)
+	endSpan(span, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, e.abort(tag, cID, ctx.Err())
+		}
+		return nil, err
+	}
+	// the log stream ends shortly after the container dies
+	select {
+	case <-e.copied:
+	case <-ctx.Done():
+		return nil, e.abort(tag, cID, ctx.Err())
+	}
+	res = &ExecResult{Version: SchemaVersion, ExitCode: ec, Started: start, Finished: time.Now(), Ports: ports, Emulated: e.emulated}
+	res.Usage = usage()
+	// a process killed by RLIMIT_CPU exits from SIGXCPU or SIGKILL
+	res.CPUTimeExceeded = atomic.LoadInt32(&cpuExceeded) == 1 ||
+		e.CPUTimeLimit > 0 && (ec == 128+24 || ec == 128+9) && res.Usage != nil && res.Usage.CPU() >= e.CPUTimeLimit-time.Second
+	res.StdoutTruncated = e.outLimit != nil && e.outLimit.truncated
+	res.StderrTruncated = e.errLimit != nil && e.errLimit.truncated
+	if cj, err := e.cli.ContainerInspect(ctx, cID); err == nil && cj.State != nil {
+		res.OOMKilled = cj.State.OOMKilled
+		if t, err := time.Parse(time.RFC3339Nano, cj.State.StartedAt); err == nil {
+			res.Started = t
+		}
+		if t, err := time.Parse(time.RFC3339Nano, cj.State.FinishedAt); err == nil {
+			res.Finished = t
+		}
+	}
+	if len(e.Artifacts) > 0 {
+		if res.Artifacts, err = e.collectArtifacts(ctx, cID); err != nil {
+			return nil, err
+		}
+	}
+	var used int64
+	if e.DiskQuota > 0 {
+		if cj, _, err := e.cli.ContainerInspectWithRaw(ctx, cID, true); err == nil && cj.SizeRw != nil {
+			used = *cj.SizeRw
+		}
+		// the write that failed may have left up to a block unused, and
+		// a command that succeeded in spite of that was within its quota
+		failed := ec != 0 || e.noSpace != nil && e.noSpace.matched
+		res.DiskQuotaExceeded = failed && used+diskSlack >= e.DiskQuota
+	}
+	if atomic.LoadInt32(&timedOut) == 0 || res.OOMKilled || res.CPUTimeExceeded {
+		res.Status = StatusOK
+		switch {
+		case res.OOMKilled:
+			res.Status = StatusOOMKilled
+		case res.CPUTimeExceeded:
+			res.Status = StatusCPUTimeExceeded
+		case res.DiskQuotaExceeded:
+			res.Status = StatusDiskQuotaExceeded
+		}
+		e.em.emit(exitEvent(ec, res.Status))
+		e.logger().Info("eggsy: container exited", "container", cID, "exitCode", ec, "status", res.Status)
+		if res.Status == StatusDiskQuotaExceeded {
+			return res, &DiskQuotaError{
+				Cmd:         e.command(),
+				ContainerID: cID,
+				Image:       tag,
+				Quota:       e.DiskQuota,
+				Used:        used,
 			}
-			return nil
-		case e := <-cer:
-			cancel()
-			return e
 		}
+		return res, nil
 	}
+	res.Status = StatusTimeout
+	res.TimedOut = true
+	e.em.emit(exitEvent(ec, res.Status))
+	e.logger().Info("eggsy: container exited", "container", cID, "exitCode", ec, "status", res.Status)
+	return res, &TimeoutError{
+		Cmd:         e.command(),
+		ContainerID: cID,
+		Image:       tag,
+		Timeout:     e.Timeout,
+		Elapsed:     res.Finished.Sub(res.Started),
+		Output:      e.written,
+	}
+}
+
+// diskSlack is how close to its DiskQuota the writable layer of a container
+// must be for a command that failed to have exceeded the quota.
+const diskSlack = 64 << 10
+
+// wait waits for the container to exit, and returns its exit code. If
+// the daemon fails to wait for it, wait watches for its die event since
+// the time since instead, matched by its image unless image is empty.
+func (e *Executor) wait(ctx context.Context, cID, image, since string) (int, error) {
+	wc, werr := e.cli.ContainerWait(ctx, cID, container.WaitConditionNotRunning)
+	select {
+	case w := <-wc:
+		if w.Error != nil && w.Error.Message != "" {
+			return 0, errors.New(w.Error.Message)
+		}
+		return int(w.StatusCode), nil
+	case err := <-werr:
+		if ctx.Err() != nil {
+			return 0, err
+		}
+	}
+	cx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f := filters.NewArgs(
+		filters.KeyValuePair{"container", cID},
+		filters.KeyValuePair{"event", "die"},
+	)
+	if image != "" {
+		f.Add("image", image)
+	}
+	cm, cer := e.cli.Events(cx, types.EventsOptions{Since: since, Filters: f})
+	select {
+	case m := <-cm:
+		return strconv.Atoi(m.Actor.Attributes["exitCode"])
+	case err := <-cer:
+		return 0, err
+	}
+}
+
+// kill signals the container once it has timed out, first with SIGTERM
+// if there is a GracePeriod, and sets timedOut if it was still running.
+// It gives up once ctx is done.
+func (e *Executor) kill(ctx context.Context, cID string, timedOut *int32) {
+	sig := "SIGKILL"
+	if e.GracePeriod > 0 {
+		sig = "SIGTERM"
+	}
+	if e.cli.ContainerKill(ctx, cID, sig) != nil {
+		return
+	}
+	atomic.StoreInt32(timedOut, 1)
+	if e.GracePeriod <= 0 {
+		return
+	}
+	select {
+	case <-time.After(e.GracePeriod):
+		e.cli.ContainerKill(ctx, cID, "SIGKILL")
+	case <-ctx.Done():
+	}
+}
+
+// workDir returns the working directory of containers created from image.
+func (e *Executor) workDir(ctx context.Context, image string) string {
+	if e.WorkingDir != "" {
+		return e.WorkingDir
+	}
+	if ii, _, err := e.cli.ImageInspectWithRaw(ctx, image); err == nil && ii.Config != nil && ii.Config.WorkingDir != "" {
+		return ii.Config.WorkingDir
+	}
+	return "/"
+}
+
+// connect creates the Executor's client, and chooses the runtime of
+// its containers.
+func (e *Executor) connect(ctx context.Context) (err error) {
+	if e.cli, err = e.newClient(ctx); err != nil {
+		return err
+	}
+	info, err := e.cli.Info(ctx)
+	if err != nil {
+		return err
+	}
+	c := e.capabilities(info, e.cli.DaemonHost())
+	if c.Runtime == "" {
+		return fmt.Errorf("%w: %s", ErrRuntimeUnavailable, e.Runtime.name())
+	}
+	if e.emulated, err = c.checkPlatform(e.Platform); err != nil {
+		return err
+	}
+	e.runtime = c.Runtime.name()
 	return nil
 }
+
+// abort kills and removes the container once the caller's context is done,
+// and reports err as a ContextError. If there is a GracePeriod, the
+// container is sent SIGTERM and given that long to exit first.
+func (e *Executor) abort(tag, cID string, err error) error {
+	if e.GracePeriod > 0 && e.cli.ContainerKill(context.Background(), cID, "SIGTERM") == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), e.GracePeriod)
+		wc, werr := e.cli.ContainerWait(ctx, cID, container.WaitConditionNotRunning)
+		select {
+		case <-wc:
+		case <-werr:
+		}
+		cancel()
+	}
+	e.logger().Info("eggsy: execution canceled", "container", cID, "err", err)
+	e.removeContainer(cID)
+	return &ContextError{Cmd: e.command(), ContainerID: cID, Image: tag, Err: err}
+}
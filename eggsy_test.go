@@ -0,0 +1,332 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/smasher164/eggsy/seccomp"
+)
+
+// fileList is a FileSet of the Files in it.
+type fileList []File
+
+func (l fileList) Len() int               { return len(l) }
+func (l fileList) At(i int) (File, error) { return l[i], nil }
+
+// archive writes files with e, and returns the headers of the archive.
+func archive(t *testing.T, e *Executor, files FileSet) []*tar.Header {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := e.writeFiles(tw, files); err != nil {
+		t.Fatalf("writeFiles: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var hdrs []*tar.Header
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return hdrs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdrs = append(hdrs, hdr)
+	}
+}
+
+func TestWriteFilesDirs(t *testing.T) {
+	files := fileList{
+		{Path: "d", Mode: fs.ModeDir | 0755},
+		{Path: "d/e", Mode: fs.ModeDir},
+	}
+	hdrs := archive(t, new(Executor), files)
+	if len(hdrs) != 2 {
+		t.Fatalf("got %d entries, want 2", len(hdrs))
+	}
+	for i, want := range []struct {
+		name string
+		mode int64
+	}{{"d/", 0755}, {"d/e/", 0777}} {
+		hdr := hdrs[i]
+		if hdr.Typeflag != tar.TypeDir || hdr.Name != want.name || hdr.Mode != want.mode {
+			t.Errorf("entry %d is %q of type %c and mode %o, want directory %q of mode %o", i, hdr.Name, hdr.Typeflag, hdr.Mode, want.name, want.mode)
+		}
+	}
+}
+
+func TestWriteFilesSymlinks(t *testing.T) {
+	files := fileList{
+		{Path: "bin/run", Linkname: "../run.sh"},
+		{Path: "run.sh", ReadCloser: io.NopCloser(bytes.NewReader([]byte("echo hi"))), Mode: 0755},
+	}
+	hdrs := archive(t, new(Executor), files)
+	if len(hdrs) != 2 {
+		t.Fatalf("got %d entries, want 2", len(hdrs))
+	}
+	if hdr := hdrs[0]; hdr.Typeflag != tar.TypeSymlink || hdr.Name != "bin/run" || hdr.Linkname != "../run.sh" {
+		t.Errorf("entry 0 is %q of type %c linked to %q, want symbolic link bin/run to ../run.sh", hdr.Name, hdr.Typeflag, hdr.Linkname)
+	}
+	if hdr := hdrs[1]; hdr.Typeflag != tar.TypeReg || hdr.Mode != 0755 || hdr.Size != 7 {
+		t.Errorf("entry 1 is of type %c, mode %o, and size %d, want a regular file of mode 755 and size 7", hdr.Typeflag, hdr.Mode, hdr.Size)
+	}
+}
+
+func TestArgv(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run commands with")
+	}
+	for _, tt := range []struct {
+		e    *Executor
+		want string
+	}{
+		{&Executor{Cmd: `echo "double quoted"`}, "double quoted\n"},
+		{&Executor{Cmd: `echo 'single "quoted"'`}, "single \"quoted\"\n"},
+		{&Executor{Cmd: `echo "it's" \"escaped\"`}, "it's \"escaped\"\n"},
+		{&Executor{Cmd: `printf 'a\nb\n' | tail -n 1 | tr b c`}, "c\n"},
+		{&Executor{Cmd: `X=expanded; echo "$X" '$X'`}, "expanded $X\n"},
+		{&Executor{Cmd: "echo `echo sub` $(echo shell)"}, "sub shell\n"},
+		{&Executor{Args: []string{"printf", "%s|%s", "$HOME", "a | b"}}, "$HOME|a | b"},
+		{&Executor{Args: []string{"sh", "-c", `echo "$0"`, "arg"}, Cmd: "ignored"}, "arg\n"},
+	} {
+		argv := tt.e.argv()
+		if len(tt.e.Args) == 0 && (len(argv) != 3 || argv[0] != "sh" || argv[1] != "-c" || argv[2] != tt.e.Cmd) {
+			t.Errorf("argv of Cmd %q = %q, want [sh -c %q]", tt.e.Cmd, argv, tt.e.Cmd)
+			continue
+		}
+		name := argv[0]
+		if name == "sh" {
+			name = sh
+		}
+		out, err := exec.Command(name, argv[1:]...).Output()
+		if err != nil {
+			t.Errorf("running %q: %v", argv, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("running %q = %q, want %q", argv, out, tt.want)
+		}
+	}
+}
+
+// fakeDaemon returns a client of a daemon served by h.
+func fakeDaemon(t *testing.T, h http.HandlerFunc) *client.Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://"+strings.TrimPrefix(srv.URL, "http://")), client.WithVersion("1.37"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func TestWaitFastExit(t *testing.T) {
+	// the container has exited before the wait, as a fast command does
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/c/wait"):
+			if c := r.URL.Query().Get("condition"); c != "not-running" {
+				t.Errorf("wait condition = %q, want not-running", c)
+			}
+			fmt.Fprint(w, `{"StatusCode":3}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+	e := &Executor{cli: cli}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ec, err := e.wait(ctx, "c", "img", time.Now().Format(time.RFC3339Nano))
+	if ec != 3 || err != nil {
+		t.Errorf("wait = %d, %v; want 3, nil", ec, err)
+	}
+}
+
+func TestWaitEventsFallback(t *testing.T) {
+	// a daemon that can't wait reports the die event since the start,
+	// even if the container died before the subscription
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/c/wait"):
+			http.Error(w, `{"message":"wait unsupported"}`, http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "/events"):
+			if r.URL.Query().Get("since") == "" {
+				t.Error("events requested without since")
+			}
+			fmt.Fprint(w, `{"Type":"container","Action":"die","Actor":{"ID":"c","Attributes":{"exitCode":"7"}}}`+"\n")
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+	e := &Executor{cli: cli}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ec, err := e.wait(ctx, "c", "img", time.Now().Add(-time.Second).Format(time.RFC3339Nano))
+	if ec != 7 || err != nil {
+		t.Errorf("wait = %d, %v; want 7, nil", ec, err)
+	}
+}
+
+func TestHostConfigSeccomp(t *testing.T) {
+	profile := seccomp.ComputeOnly().String()
+	for _, tt := range []struct {
+		e    *Executor
+		want []string
+	}{
+		{&Executor{}, nil},
+		{&Executor{Seccomp: SEUnconfined}, []string{"seccomp=unconfined"}},
+		{&Executor{Seccomp: profile}, []string{"seccomp=" + profile}},
+		{&Executor{Seccomp: profile, NoNewPrivileges: true}, []string{"seccomp=" + profile, "no-new-privileges"}},
+	} {
+		if got := tt.e.hostConfig().SecurityOpt; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SecurityOpt of Seccomp %.20q = %q, want %q", tt.e.Seccomp, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteClosesClient(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]bool)
+	)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			fmt.Fprint(w, `{"DefaultRuntime":"runsc","OSType":"linux"}`)
+		default:
+			// the image isn't present, so Execute fails after connecting
+			http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+		}
+	}))
+	srv.Config.ConnState = func(c net.Conn, s http.ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		if s == http.StateClosed || s == http.StateHijacked {
+			delete(conns, c)
+		} else {
+			conns[c] = true
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+	host := "tcp://" + strings.TrimPrefix(srv.URL, "http://")
+	for i := 0; i < 5; i++ {
+		e := &Executor{DockerHost: host, APIVersion: "1.37", Image: "img", PullPolicy: PullNever}
+		if _, err := e.Execute(context.Background()); !errors.Is(err, ErrImageNotPresent) {
+			t.Fatalf("Execute = %v, want ErrImageNotPresent", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(conns)
+		mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections open after every Execute returned, want 0", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestImageRefWithFiles(t *testing.T) {
+	e := &Executor{Image: "img", Files: fileList{{Path: "main.c", ReadCloser: io.NopCloser(strings.NewReader("int main;"))}}}
+	_, files, err := e.makeBuildContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := ImageRef{Tag: "img", files: files}
+	cref, err := ref.WithFiles(fileList{{Path: "input.txt", ReadCloser: io.NopCloser(strings.NewReader("1 2"))}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(cref.files))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(tr)
+		names = append(names, hdr.Name+"="+string(b))
+	}
+	if want := []string{"main.c=int main;", "input.txt=1 2"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("files of the ref are %q, want %q", names, want)
+	}
+	if !bytes.Equal(ref.files, files) {
+		t.Error("WithFiles changed the files of the original ref")
+	}
+}
+
+func TestRunSharesConnection(t *testing.T) {
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/info") {
+			t.Error("Run connected to the daemon anew")
+		}
+		http.Error(w, `{"message":"no such image"}`, http.StatusNotFound)
+	})
+	ref := ImageRef{Tag: "img", cli: cli, runtime: "runc"}
+	e := new(Executor)
+	if _, err := e.Run(context.Background(), ref); err == nil {
+		t.Fatal("Run succeeded without a container")
+	}
+	if e.cli != cli || !e.borrowed || e.runtime != "runc" {
+		t.Errorf("Run used client %p of runtime %q, want the ref's %p", e.cli, e.runtime, cli)
+	}
+	e.Close()
+	if e.cli != nil || e.borrowed {
+		t.Error("Close kept the borrowed connection")
+	}
+}
@@ -0,0 +1,319 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package eggsyhttp serves eggsy's Executor over HTTP, with the output
+// of executions streamed as server-sent events, as the backend of an
+// online judge or playground. Its API is:
+//
+//	POST /executions
+//		executes the Request in the body, and responds with
+//		201 Created and {"id": id}, with the execution's URL in the
+//		Location header
+//	GET /executions/{id}
+//		responds with the execution's Result, once it has finished
+//	GET /executions/{id}/events
+//		streams the execution's events as text/event-stream
+//	DELETE /executions/{id}
+//		cancels the execution, and responds with 204 No Content
+//
+// Errors are responded with as {"error": message}. A finished execution
+// is forgotten after ten minutes.
+//
+// A TerminalHandler serves interactive commands, such as shells, over
+// WebSockets instead.
+package eggsyhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smasher164/eggsy"
+	"github.com/smasher164/eggsy/internal/jobs"
+)
+
+const (
+	// DefaultMaxRequestBytes is the default limit of the size of a
+	// Request.
+	DefaultMaxRequestBytes = 16 << 20
+
+	// DefaultMaxParallel is the number of executions a Handler executes
+	// at once, unless NewHandler is given another.
+	DefaultMaxParallel = 8
+
+	// DefaultMaxWaiting is the default limit of the number of executions
+	// waiting for others to finish.
+	DefaultMaxWaiting = 64
+)
+
+// Request is the body of POST /executions. Its fields have the meaning
+// of the Executor's fields of the same name, and Files maps the paths
+// of files to their contents, which are text. The Handler may override
+// them.
+type Request struct {
+	Dockerfile string            `json:"dockerfile,omitempty"`
+	Image      string            `json:"image,omitempty"`
+	Files      map[string]string `json:"files,omitempty"`
+	Cmd        string            `json:"cmd,omitempty"`
+	Args       []string          `json:"args,omitempty"`
+	Stdin      string            `json:"stdin,omitempty"`
+
+	// TimeoutMs is the Timeout in milliseconds. It defaults to
+	// eggsy.DefaultRunTimeout.
+	TimeoutMs int64 `json:"timeoutMs,omitempty"`
+	Memory    int64 `json:"memory,omitempty"`
+	PidsLimit int64 `json:"pidsLimit,omitempty"`
+}
+
+// Result is the body of GET /executions/{id}, and the data of the last
+// event of GET /executions/{id}/events, whose type is "result".
+type Result struct {
+	ID     string            `json:"id"`
+	Status eggsy.Status      `json:"status"`
+	Result *eggsy.ExecResult `json:"result,omitempty"`
+
+	// Error describes the error that the execution failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Handler is an http.Handler that serves the API. It must be created
+// with NewHandler, after which its fields may be set before it serves
+// any requests.
+type Handler struct {
+	// MaxRequestBytes limits the size of the body of POST /executions.
+	// Larger requests are responded to with 413 Request Entity Too
+	// Large.
+	MaxRequestBytes int64
+
+	// MaxWaiting limits the number of executions waiting for others to
+	// finish, beyond which POST /executions is responded to with 429
+	// Too Many Requests, and a Retry-After header estimating when to
+	// retry. Zero means any number.
+	MaxWaiting int
+
+	// Authorize, if non-nil, is called with every request before it is
+	// served. If it returns an error, the request is responded to with
+	// 401 Unauthorized and the error.
+	Authorize func(r *http.Request) error
+
+	opts []func(*eggsy.Executor)
+	jobs *jobs.Registry
+	mux  *http.ServeMux
+}
+
+// NewHandler returns a Handler that executes at most maxParallel
+// executions at once, or DefaultMaxParallel if maxParallel is zero. The
+// Executor of each execution has the fields of its Request, hardened as
+// by eggsy.HardenedDefaults with a read-only root filesystem, and is then
+// configured by each option in turn, which may change any of its fields,
+// such as to cap its Timeout or to set its Runtime.
+func NewHandler(maxParallel int, opts ...func(*eggsy.Executor)) *Handler {
+	if maxParallel <= 0 {
+		maxParallel = DefaultMaxParallel
+	}
+	h := &Handler{
+		MaxRequestBytes: DefaultMaxRequestBytes,
+		MaxWaiting:      DefaultMaxWaiting,
+		opts:            opts,
+		jobs:            jobs.New(maxParallel),
+		mux:             http.NewServeMux(),
+	}
+	h.mux.HandleFunc("POST /executions", h.submit)
+	h.mux.HandleFunc("GET /executions/{id}", h.result)
+	h.mux.HandleFunc("GET /executions/{id}/events", h.events)
+	h.mux.HandleFunc("DELETE /executions/{id}", h.cancel)
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Authorize != nil {
+		if err := h.Authorize(r); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) submit(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if h.MaxRequestBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.MaxRequestBytes)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	j, err := h.jobs.Submit(h.executor(&req), h.MaxWaiting)
+	var qfe *eggsy.QueueFullError
+	if errors.As(err, &qfe) {
+		secs := int((qfe.RetryAfter + time.Second - 1) / time.Second)
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		writeError(w, http.StatusTooManyRequests, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+j.ID)
+	writeJSON(w, http.StatusCreated, map[string]string{"id": j.ID})
+}
+
+// executor returns the Executor of req.
+func (h *Handler) executor(req *Request) *eggsy.Executor {
+	e := &eggsy.Executor{
+		Dockerfile: req.Dockerfile,
+		Image:      req.Image,
+		Cmd:        req.Cmd,
+		Args:       req.Args,
+		Timeout:    eggsy.DefaultRunTimeout,
+		Memory:     req.Memory,
+		PidsLimit:  req.PidsLimit,
+	}
+	if len(req.Files) > 0 {
+		files := make(map[string][]byte, len(req.Files))
+		for path, contents := range req.Files {
+			files[path] = []byte(contents)
+		}
+		e.Files = eggsy.MapFileSet(files)
+	}
+	if req.Stdin != "" {
+		e.Stdin = strings.NewReader(req.Stdin)
+	}
+	if req.TimeoutMs != 0 {
+		e.Timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	jobs.Harden(e)
+	for _, opt := range h.opts {
+		opt(e)
+	}
+	return e
+}
+
+// job returns the execution named by r, or responds with 404 Not Found.
+func (h *Handler) job(w http.ResponseWriter, r *http.Request) (*jobs.Job, bool) {
+	j, ok := h.jobs.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("eggsy: unknown execution"))
+	}
+	return j, ok
+}
+
+func (h *Handler) result(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.job(w, r)
+	if !ok {
+		return
+	}
+	res, ok := result(j)
+	if !ok {
+		writeError(w, http.StatusConflict, errors.New("eggsy: execution hasn't finished"))
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// result returns the Result of j, if it has finished.
+func result(j *jobs.Job) (*Result, bool) {
+	res, err, ok := j.Result()
+	if !ok {
+		return nil, false
+	}
+	out := &Result{ID: j.ID, Status: eggsy.StatusOf(err), Result: res}
+	if res != nil {
+		out.Status = res.Status
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	return out, true
+}
+
+// events streams the events of the execution, starting after the one
+// whose ID is given by the Last-Event-ID header, if any, so that an
+// EventSource that reconnects doesn't see events twice.
+func (h *Handler) events(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.job(w, r)
+	if !ok {
+		return
+	}
+	from := 0
+	if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && id >= 0 {
+		from = id + 1
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	for {
+		events, done, err := j.Events(r.Context(), from)
+		if err != nil {
+			return
+		}
+		for _, ev := range events {
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", from, ev.Type, data)
+			from++
+		}
+		// the result's ID is one past that of the last event
+		if done && from == j.Len() {
+			res, _ := result(j)
+			data, _ := json.Marshal(res)
+			fmt.Fprintf(w, "id: %d\nevent: result\ndata: %s\n\n", from, data)
+		}
+		if err := rc.Flush(); err != nil || done {
+			return
+		}
+	}
+}
+
+func (h *Handler) cancel(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.job(w, r)
+	if !ok {
+		return
+	}
+	j.Cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
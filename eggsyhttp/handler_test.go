@@ -0,0 +1,71 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsyhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/smasher164/eggsy"
+)
+
+func TestExecutorHardened(t *testing.T) {
+	h := NewHandler(0)
+	e := h.executor(&Request{Image: "alpine", Cmd: "true"})
+	if e.Net != eggsy.NetNone || !e.NoNewPrivileges || !e.ReadOnlyRootFS || e.PidsLimit == 0 {
+		t.Errorf("execution not hardened: Net %v, NoNewPrivileges %v, ReadOnlyRootFS %v, PidsLimit %d", e.Net, e.NoNewPrivileges, e.ReadOnlyRootFS, e.PidsLimit)
+	}
+}
+
+func TestSubmitTooManyRequests(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	h := NewHandler(1, func(e *eggsy.Executor) {
+		// the executions wait until the test ends
+		e.Policy = eggsy.PolicyFunc(func(*eggsy.Executor) error {
+			<-release
+			return errors.New("released")
+		})
+	})
+	h.MaxWaiting = 1
+	// one execution runs and one waits, so the third is refused, if the
+	// second isn't already
+	var (
+		codes []int
+		last  *httptest.ResponseRecorder
+	)
+	for i := 0; i < 3; i++ {
+		last = httptest.NewRecorder()
+		h.ServeHTTP(last, httptest.NewRequest("POST", "/executions", strings.NewReader(`{"image":"alpine","cmd":"true"}`)))
+		codes = append(codes, last.Code)
+	}
+	if codes[0] != http.StatusCreated || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("responses = %v, want 201 first and 429 last", codes)
+	}
+	if ra := last.Header().Get("Retry-After"); ra == "" {
+		t.Error("429 response without a Retry-After header")
+	}
+}
@@ -0,0 +1,180 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsyhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/smasher164/eggsy"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultTerminalTimeout is the default Timeout of a terminal's command.
+const DefaultTerminalTimeout = 30 * time.Minute
+
+// TerminalMessage is a message sent over a terminal's WebSocket as a
+// text frame of JSON. The client sends messages of type "stdin", whose
+// Data is written to the terminal, and "resize", which sizes it to Rows
+// and Cols. The server sends the terminal's output in binary frames,
+// followed by a message of type "exit", with the command's ExitCode and
+// Status, or of type "error", with an Error, before closing the socket.
+type TerminalMessage struct {
+	Type     string       `json:"type"`
+	Data     string       `json:"data,omitempty"`
+	Rows     uint         `json:"rows,omitempty"`
+	Cols     uint         `json:"cols,omitempty"`
+	ExitCode int          `json:"exitCode"`
+	Status   eggsy.Status `json:"status,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+// TerminalHandler is an http.Handler that bridges a WebSocket, such as
+// that of a terminal emulator in a browser, to a command run with a
+// terminal, such as a shell. Each connection runs its own command, which
+// is killed once the connection closes. It must be created with
+// NewTerminalHandler, after which its fields may be set before it serves
+// any requests.
+type TerminalHandler struct {
+	// MaxMessageBytes limits the size of the messages sent by clients.
+	// Connections that send a larger message are closed.
+	MaxMessageBytes int
+
+	// Authorize, if non-nil, is called with every request before the
+	// WebSocket is opened. If it returns an error, the request is
+	// responded to with 401 Unauthorized and the error.
+	Authorize func(r *http.Request) error
+
+	opts []func(*eggsy.Executor)
+}
+
+// NewTerminalHandler returns a TerminalHandler whose commands are run by
+// Executors configured by each option in turn, which must give them a
+// command, such as a Cmd of "sh", and an image. Their Tty is set, and
+// their Timeout defaults to DefaultTerminalTimeout.
+func NewTerminalHandler(opts ...func(*eggsy.Executor)) *TerminalHandler {
+	return &TerminalHandler{MaxMessageBytes: 64 << 10, opts: opts}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *TerminalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Authorize != nil {
+		if err := h.Authorize(r); err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+	}
+	websocket.Handler(h.serve).ServeHTTP(w, r)
+}
+
+// serve runs a command for the terminal ws until either exits.
+func (h *TerminalHandler) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	ws.MaxPayloadBytes = h.MaxMessageBytes
+	ctx, cancel := context.WithCancel(ws.Request().Context())
+	defer cancel()
+
+	e := &eggsy.Executor{Timeout: DefaultTerminalTimeout}
+	for _, opt := range h.opts {
+		opt(e)
+	}
+	e.Tty = true
+	stdin, stdinW := io.Pipe()
+	defer stdin.Close()
+	e.Stdin = stdin
+	e.Stdout = frameWriter{ws}
+	e.Stderr = nil
+
+	// the terminal can only be sized once the command has started
+	var (
+		mu      sync.Mutex
+		started bool
+		size    *TerminalMessage
+	)
+	resize := func() {
+		if started && size != nil {
+			e.Resize(ctx, size.Rows, size.Cols)
+			size = nil
+		}
+	}
+	events := make(chan eggsy.Event)
+	e.EventC = events
+	go func() {
+		for ev := range events {
+			if ev.Type == eggsy.EventStart {
+				mu.Lock()
+				started = true
+				resize()
+				mu.Unlock()
+			}
+		}
+	}()
+	go func() {
+		// the connection closing ends the command
+		defer cancel()
+		defer stdinW.Close()
+		for {
+			var m TerminalMessage
+			if err := websocket.JSON.Receive(ws, &m); err != nil {
+				return
+			}
+			switch m.Type {
+			case "stdin":
+				if _, err := io.WriteString(stdinW, m.Data); err != nil {
+					return
+				}
+			case "resize":
+				mu.Lock()
+				size = &m
+				resize()
+				mu.Unlock()
+			}
+		}
+	}()
+
+	res, err := e.Execute(ctx)
+	close(events)
+	var ce *eggsy.ContextError
+	switch {
+	case errors.As(err, &ce) || ctx.Err() != nil:
+		// the client is gone
+	case res != nil:
+		websocket.JSON.Send(ws, TerminalMessage{Type: "exit", ExitCode: res.ExitCode, Status: res.Status})
+	default:
+		websocket.JSON.Send(ws, TerminalMessage{Type: "error", Error: err.Error()})
+	}
+}
+
+// frameWriter writes to a WebSocket in binary frames.
+type frameWriter struct{ ws *websocket.Conn }
+
+func (f frameWriter) Write(p []byte) (int, error) {
+	if err := websocket.Message.Send(f.ws, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,169 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+type (
+	// EventType identifies the kind of an Event. See the constant
+	// definitions for the types of events emitted by an Executor.
+	EventType string
+
+	// Event is a single step in the lifecycle of an execution. Events
+	// are emitted in order, so that a stream of them can be persisted
+	// and replayed.
+	Event struct {
+		// Version is the SchemaVersion of the event.
+		Version int       `json:"v"`
+		Time    time.Time `json:"time"`
+		Type    EventType `json:"type"`
+
+		// Data holds a chunk of output for EventStdout and EventStderr.
+		Data string `json:"data,omitempty"`
+
+		// ExitCode is the exit code of the command for EventExit, and
+		// nil for the other events.
+		ExitCode *int `json:"exitCode,omitempty"`
+
+		// Status is the Status of the execution for EventExit and
+		// EventError, and Error describes the error returned by Execute
+		// for EventError.
+		Status Status `json:"status,omitempty"`
+		Error  string `json:"error,omitempty"`
+
+		// Usage holds the resources the container has used so far for
+		// EventStats.
+		Usage *Usage `json:"usage,omitempty"`
+
+		// Result is the result of the execution for EventResult.
+		Result *ExecResult `json:"result,omitempty"`
+	}
+)
+
+const (
+	// EventBuild is emitted when the image starts building.
+	EventBuild EventType = "build"
+
+	// EventStart is emitted once the container has started.
+	EventStart EventType = "start"
+
+	// EventStdout and EventStderr carry chunks of the command's output.
+	// If the Executor's Timestamps is set, their time is the time the
+	// daemon recorded for the output.
+	EventStdout EventType = "stdout"
+	EventStderr EventType = "stderr"
+
+	// EventStats is emitted with each sample of the container's stats,
+	// about once a second while it runs, if the daemon reports them.
+	EventStats EventType = "stats"
+
+	// EventExit is emitted when the container exits, after all of its
+	// output has been emitted.
+	EventExit EventType = "exit"
+
+	// EventResult is emitted once the command has run, with the result
+	// returned by Execute or Run. It follows EventExit, and precedes
+	// EventError if the result comes with an error.
+	EventResult EventType = "result"
+
+	// EventError is emitted last if Execute returns an error.
+	EventError EventType = "error"
+)
+
+// emitter serializes events to an Executor's Events writer and EventC channel.
+type emitter struct {
+	m   sync.Mutex
+	enc *json.Encoder
+	c   chan<- Event
+}
+
+func newEmitter(w io.Writer, c chan<- Event) *emitter {
+	if w == nil && c == nil {
+		return nil
+	}
+	em := &emitter{c: c}
+	if w != nil {
+		em.enc = json.NewEncoder(w)
+	}
+	return em
+}
+
+// exitEvent returns the EventExit of a command that exited with code.
+func exitEvent(code int, status Status) Event {
+	return Event{Type: EventExit, ExitCode: &code, Status: status}
+}
+
+// statsEvent returns the EventStats of the sample u, which the caller
+// may go on updating.
+func statsEvent(u *Usage) Event {
+	cp := *u
+	return Event{Type: EventStats, Usage: &cp}
+}
+
+// finish emits the EventResult of res, if any, and the EventError of
+// err, if any.
+func (em *emitter) finish(res *ExecResult, err error) {
+	if res != nil {
+		em.emit(Event{Type: EventResult, Result: res})
+	}
+	if err != nil {
+		em.emit(Event{Type: EventError, Status: StatusOf(err), Error: err.Error()})
+	}
+}
+
+func (em *emitter) emit(ev Event) {
+	if em == nil {
+		return
+	}
+	em.m.Lock()
+	defer em.m.Unlock()
+	ev.Version = SchemaVersion
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if em.enc != nil {
+		em.enc.Encode(ev)
+	}
+	if em.c != nil {
+		em.c <- ev
+	}
+}
+
+// streamWriter emits every chunk written to it as an event of type t.
+// The event's time is taken from at, which is set by a stampWriter when
+// the daemon provides timestamps.
+type streamWriter struct {
+	em *emitter
+	t  EventType
+	at *time.Time
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.em.emit(Event{Time: *s.at, Type: s.t, Data: string(p)})
+	return len(p), nil
+}
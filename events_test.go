@@ -0,0 +1,86 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		ev   Event
+		want string
+	}{
+		{exitEvent(0, StatusOK), `"exitCode":0`},
+		{exitEvent(3, StatusOK), `"exitCode":3`},
+		{Event{Type: EventStdout, Data: "x"}, ""},
+	} {
+		b, err := json.Marshal(tt.ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(b), `"exitCode"`); got != (tt.want != "") || !strings.Contains(string(b), tt.want) {
+			t.Errorf("json.Marshal = %s, want exitCode %q", b, tt.want)
+		}
+	}
+}
+
+func TestEventStatsResult(t *testing.T) {
+	c := make(chan Event, 4)
+	em := newEmitter(nil, c)
+	u := &Usage{MaxMemory: 1 << 20, CPUUser: 3 * time.Millisecond}
+	em.emit(statsEvent(u))
+	u.MaxMemory = 2 << 20
+	res := &ExecResult{Version: SchemaVersion, Status: StatusTimeout, ExitCode: 137, TimedOut: true}
+	em.finish(res, &TimeoutError{Timeout: time.Second})
+	em.finish(nil, nil)
+	close(c)
+
+	var evs []Event
+	for ev := range c {
+		evs = append(evs, ev)
+	}
+	if len(evs) != 3 {
+		t.Fatalf("emitted %d events, want 3: %+v", len(evs), evs)
+	}
+	if evs[0].Type != EventStats || evs[0].Usage.MaxMemory != 1<<20 {
+		t.Errorf("first event = %+v, want stats of the sample as it was emitted", evs[0])
+	}
+	if evs[1].Type != EventResult || evs[1].Result != res {
+		t.Errorf("second event = %+v, want the result", evs[1])
+	}
+	if evs[2].Type != EventError || evs[2].Status != StatusTimeout {
+		t.Errorf("third event = %+v, want a timeout error", evs[2])
+	}
+
+	b, err := json.Marshal(evs[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"result":{"v":1,"status":"timeout","exitCode":137`) {
+		t.Errorf("json.Marshal = %s, want the result", b)
+	}
+}
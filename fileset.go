@@ -0,0 +1,201 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// memFile is a file held in memory, used to build a FileSet.
+type memFile struct {
+	path string
+	data string
+	mode fs.FileMode
+}
+
+// memFiles is a FileSet of files held in memory.
+type memFiles []memFile
+
+func (m memFiles) At(i int) (File, error) {
+	return File{
+		Path:       m[i].path,
+		ReadCloser: memReader{strings.NewReader(m[i].data)},
+		Mode:       m[i].mode,
+	}, nil
+}
+
+// memReader reads a memFile, and keeps the Len of its strings.Reader
+// so that the file can be archived without being copied.
+type memReader struct{ *strings.Reader }
+
+func (memReader) Close() error { return nil }
+
+func (m memFiles) Len() int { return len(m) }
+
+// MapFileSet returns a FileSet of the files in m, keyed by their paths.
+// The files are ordered by path, so that the same files always make the
+// same build context.
+func MapFileSet(m map[string][]byte) FileSet {
+	files := make(memFiles, 0, len(m))
+	for path, data := range m {
+		files = append(files, memFile{path: path, data: string(data)})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files
+}
+
+// NewFile returns a FileSet of a single file at path holding contents.
+func NewFile(path string, contents []byte) FileSet {
+	return memFiles{{path: path, data: string(contents)}}
+}
+
+// dirEntry is a file found by walking a directory.
+type dirEntry struct {
+	path     string // relative to the root, slash-separated
+	mode     fs.FileMode
+	linkname string
+}
+
+// dirFileSet is a FileSet of the files under a directory.
+type dirFileSet struct {
+	root    string
+	entries []dirEntry
+}
+
+// DirFileSet returns a FileSet of the regular files, directories, and
+// symbolic links under the directory root, with paths relative to root and their modes
+// preserved. Files and directories whose relative path or name match any
+// of the ignore patterns, in the syntax of filepath.Match, are skipped.
+// The directory is walked once, but files are read when the FileSet is.
+func DirFileSet(root string, ignore ...string) (FileSet, error) {
+	d := &dirFileSet{root: root}
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if ignored(rel, ignore) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			d.entries = append(d.entries, dirEntry{path: rel, mode: fi.Mode(), linkname: target})
+		case fi.Mode().IsRegular(), fi.IsDir():
+			d.entries = append(d.entries, dirEntry{path: rel, mode: fi.Mode()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func ignored(rel string, patterns []string) bool {
+	base := rel[strings.LastIndex(rel, "/")+1:]
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dirFileSet) At(i int) (File, error) {
+	e := d.entries[i]
+	f := File{Path: e.path, Mode: e.mode, Linkname: e.linkname}
+	if e.linkname != "" || e.mode.IsDir() {
+		f.ReadCloser = ioutil.NopCloser(strings.NewReader(""))
+		return f, nil
+	}
+	r, err := os.Open(filepath.Join(d.root, filepath.FromSlash(e.path)))
+	if err != nil {
+		return File{}, err
+	}
+	f.ReadCloser = r
+	return f, nil
+}
+
+func (d *dirFileSet) Len() int { return len(d.entries) }
+
+// fsFileSet is a FileSet of the files in an fs.FS.
+type fsFileSet struct {
+	fsys    fs.FS
+	entries []dirEntry
+}
+
+// FSFileSet returns a FileSet of the regular files and directories in
+// fsys, such as an embed.FS, with their paths and modes preserved. Use
+// fs.Sub to select a subdirectory. The file system is walked once, but
+// files are read when the FileSet is.
+func FSFileSet(fsys fs.FS) (FileSet, error) {
+	f := &fsFileSet{fsys: fsys}
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." || !d.Type().IsRegular() && !d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f.entries = append(f.entries, dirEntry{path: path, mode: fi.Mode()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *fsFileSet) At(i int) (File, error) {
+	e := f.entries[i]
+	if e.mode.IsDir() {
+		return File{Path: e.path, ReadCloser: ioutil.NopCloser(strings.NewReader("")), Mode: e.mode}, nil
+	}
+	r, err := f.fsys.Open(e.path)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Path: e.path, ReadCloser: r, Mode: e.mode}, nil
+}
+
+func (f *fsFileSet) Len() int { return len(f.entries) }
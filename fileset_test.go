@@ -0,0 +1,55 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirFileSetSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "main.sh"), []byte("echo hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("main.sh", filepath.Join(root, "link")); err != nil {
+		t.Skipf("can't create symbolic links: %v", err)
+	}
+	files, err := DirFileSet(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdrs := archive(t, new(Executor), files)
+	got := make(map[string]*tar.Header)
+	for _, hdr := range hdrs {
+		got[hdr.Name] = hdr
+	}
+	if hdr := got["link"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "main.sh" {
+		t.Errorf("link is %+v, want a symbolic link to main.sh", hdr)
+	}
+	if hdr := got["main.sh"]; hdr == nil || hdr.Typeflag != tar.TypeReg || hdr.Mode != 0755 {
+		t.Errorf("main.sh is %+v, want a regular file of mode 755", hdr)
+	}
+}
@@ -0,0 +1,263 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// ErrNoHealthyHost is returned when every host of a HostPool has failed
+// its health check.
+var ErrNoHealthyHost = errors.New("eggsy: no healthy host")
+
+// hostCheckInterval is how often a HostPool checks the health of its hosts.
+const hostCheckInterval = 10 * time.Second
+
+// Host is a daemon that a HostPool executes Jobs on.
+type Host struct {
+	// Addr is the address of the daemon, as in an Executor's DockerHost,
+	// such as "ssh://eggsy@sandbox1" or "tcp://sandbox2:2376".
+	Addr      string
+	TLSConfig *tls.Config
+
+	// MaxParallel limits the number of Jobs executing on the host at
+	// once. Zero imposes no limit.
+	MaxParallel int
+}
+
+// HostPool executes Jobs across several daemons, such as those of a fleet
+// of sandbox machines. Each Job executes on the healthy host with the
+// fewest Jobs executing, and hosts are checked every 10 seconds, so that
+// a host that fails is avoided until it recovers. A HostPool is safe for
+// concurrent use.
+type HostPool struct {
+	opts []func(*Executor)
+
+	mu      sync.Mutex
+	hosts   []*poolHost
+	changed chan struct{} // closed when a host may have become available
+
+	done chan struct{}
+	wg   sync.WaitGroup // health checks
+}
+
+type poolHost struct {
+	Host
+	e       *Executor // connected to the host, or nil
+	healthy bool
+	running int
+}
+
+// NewHostPool connects to the hosts, and returns a HostPool that executes
+// Jobs on them. The Executor of each Job is configured as by NewRunner, and
+// then connected to the host it executes on. NewHostPool fails if it can't
+// connect to any of the hosts.
+func NewHostPool(ctx context.Context, hosts []Host, opts ...func(*Executor)) (*HostPool, error) {
+	p := &HostPool{
+		opts:    opts,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	var err error
+	for _, h := range hosts {
+		ph := &poolHost{Host: h}
+		if cerr := p.connect(ctx, ph); cerr != nil {
+			err = cerr
+		}
+		p.hosts = append(p.hosts, ph)
+	}
+	if p.healthy() == 0 {
+		p.Close()
+		if err == nil {
+			err = ErrNoHealthyHost
+		}
+		return nil, err
+	}
+	p.wg.Add(1)
+	go p.check()
+	return p, nil
+}
+
+// connect connects to the host h.
+func (p *HostPool) connect(ctx context.Context, h *poolHost) error {
+	e := new(Executor)
+	for _, opt := range p.opts {
+		opt(e)
+	}
+	e.DockerHost, e.TLSConfig, e.Client = h.Addr, h.TLSConfig, nil
+	if err := e.connect(ctx); err != nil {
+		if e.cli != nil {
+			e.cli.Close()
+		}
+		return err
+	}
+	p.mu.Lock()
+	h.e, h.healthy = e, true
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *HostPool) healthy() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, h := range p.hosts {
+		if h.healthy {
+			n++
+		}
+	}
+	return n
+}
+
+// check checks the health of the hosts until the HostPool is closed.
+func (p *HostPool) check() {
+	defer p.wg.Done()
+	t := time.NewTicker(hostCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-p.done:
+			return
+		}
+		for _, h := range p.hosts {
+			ctx, cancel := context.WithTimeout(context.Background(), hostCheckInterval)
+			p.mu.Lock()
+			e := h.e
+			p.mu.Unlock()
+			if e == nil {
+				p.connect(ctx, h)
+			} else {
+				_, err := e.cli.Ping(ctx)
+				p.setHealthy(h, err == nil)
+			}
+			cancel()
+		}
+		p.signal()
+	}
+}
+
+func (p *HostPool) setHealthy(h *poolHost, healthy bool) {
+	p.mu.Lock()
+	h.healthy = healthy
+	p.mu.Unlock()
+}
+
+// signal wakes the Jobs waiting for a host. p.mu must not be held.
+func (p *HostPool) signal() {
+	p.mu.Lock()
+	close(p.changed)
+	p.changed = make(chan struct{})
+	p.mu.Unlock()
+}
+
+// acquire returns the healthy host with the fewest Jobs executing, once
+// one can execute another.
+func (p *HostPool) acquire(ctx context.Context) (*poolHost, error) {
+	for {
+		p.mu.Lock()
+		var (
+			best    *poolHost
+			healthy bool
+		)
+		for _, h := range p.hosts {
+			if !h.healthy {
+				continue
+			}
+			healthy = true
+			if h.MaxParallel > 0 && h.running >= h.MaxParallel {
+				continue
+			}
+			if best == nil || h.running < best.running {
+				best = h
+			}
+		}
+		if best != nil {
+			best.running++
+			p.mu.Unlock()
+			return best, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		if !healthy {
+			return nil, ErrNoHealthyHost
+		}
+		select {
+		case <-changed:
+		case <-p.done:
+			return nil, ErrNoHealthyHost
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *HostPool) release(h *poolHost) {
+	p.mu.Lock()
+	h.running--
+	p.mu.Unlock()
+	p.signal()
+}
+
+// Execute executes j like Executor.Execute does, on the healthy host with
+// the fewest Jobs executing, once it can execute another. It returns
+// ErrNoHealthyHost if no host is healthy. A host that can't be reached
+// while executing j is avoided until it passes a health check.
+func (p *HostPool) Execute(ctx context.Context, j Job) (*ExecResult, error) {
+	h, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.release(h)
+	e := j.executor(p.opts)
+	e.cli, e.runtime = h.e.cli, h.e.runtime
+	res, err := e.Execute(ctx)
+	if client.IsErrConnectionFailed(err) {
+		p.setHealthy(h, false)
+	}
+	return res, err
+}
+
+// Close stops checking the health of the hosts, and closes the HostPool's
+// connections to them. Jobs must not be executing.
+func (p *HostPool) Close() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+	}
+	close(p.done)
+	p.wg.Wait()
+	for _, h := range p.hosts {
+		if h.e != nil {
+			h.e.cli.Close()
+		}
+	}
+	return nil
+}
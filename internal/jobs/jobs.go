@@ -0,0 +1,230 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package jobs keeps the executions submitted to eggsy's servers, along
+// with their events, until they have been fetched.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/smasher164/eggsy"
+)
+
+// Retention is how long a Registry keeps a finished Job, for its events
+// and result to be fetched.
+const Retention = 10 * time.Minute
+
+// Harden applies the configuration that the servers give every Job,
+// whatever its request asks for, before their own options: that of
+// eggsy.HardenedDefaults, and a read-only root filesystem with a
+// writable /tmp, unless the Job's Files must be copied into a container
+// run from its Image.
+func Harden(e *eggsy.Executor) {
+	eggsy.HardenedDefaults(e)
+	if e.Image == "" || e.Files == nil || e.Files.Len() == 0 {
+		e.ReadOnlyRootFS = true
+		e.Tmpfs = map[string]string{"/tmp": "rw,nosuid,nodev,size=64m"}
+	}
+}
+
+// Registry executes Jobs, and keeps them until Retention after they
+// finish. A Registry is safe for concurrent use.
+type Registry struct {
+	sem chan struct{}
+
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	waiting int           // Jobs waiting for sem
+	mean    time.Duration // moving average of the durations of Jobs
+}
+
+// Job is an execution submitted to a Registry.
+type Job struct {
+	ID     string
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	events  []eggsy.Event
+	changed chan struct{} // closed and replaced when events is appended to, or the Job finishes
+	done    bool
+	res     *eggsy.ExecResult
+	err     error
+}
+
+// New returns a Registry that executes at most maxParallel Jobs at once,
+// or any number if maxParallel is zero.
+func New(maxParallel int) *Registry {
+	r := &Registry{jobs: make(map[string]*Job)}
+	if maxParallel > 0 {
+		r.sem = make(chan struct{}, maxParallel)
+	}
+	return r
+}
+
+// Submit starts executing e as a new Job. The Registry takes ownership
+// of e, and sets its EventC. If maxWaiting is positive and that many Jobs
+// are already waiting for others to finish, Submit returns an
+// eggsy.QueueFullError instead.
+func (r *Registry) Submit(e *eggsy.Executor, maxWaiting int) (*Job, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	// the Job outlives the request that submitted it
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{ID: hex.EncodeToString(b), cancel: cancel, changed: make(chan struct{})}
+	r.mu.Lock()
+	if r.sem != nil {
+		if maxWaiting > 0 && r.waiting >= maxWaiting {
+			// a Job stops waiting about every mean/maxParallel
+			err := &eggsy.QueueFullError{Len: r.waiting, RetryAfter: r.mean / time.Duration(cap(r.sem))}
+			r.mu.Unlock()
+			cancel()
+			return nil, err
+		}
+		r.waiting++
+	}
+	r.jobs[j.ID] = j
+	r.mu.Unlock()
+	go r.run(ctx, j, e)
+	return j, nil
+}
+
+// Get returns the Job with the given ID, if the Registry has it.
+func (r *Registry) Get(id string) (*Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	j, ok := r.jobs[id]
+	return j, ok
+}
+
+// run executes e as j, and forgets j after Retention.
+func (r *Registry) run(ctx context.Context, j *Job, e *eggsy.Executor) {
+	defer j.cancel()
+	events := make(chan eggsy.Event)
+	e.EventC = events
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for ev := range events {
+			j.update(func() { j.events = append(j.events, ev) })
+		}
+	}()
+	var (
+		res *eggsy.ExecResult
+		err error
+	)
+	if r.sem != nil {
+		select {
+		case r.sem <- struct{}{}:
+			r.doneWaiting()
+			start := time.Now()
+			res, err = e.Execute(ctx)
+			r.observe(time.Since(start))
+			<-r.sem
+		case <-ctx.Done():
+			r.doneWaiting()
+			err = ctx.Err()
+		}
+	} else {
+		res, err = e.Execute(ctx)
+	}
+	close(events)
+	<-drained
+	j.update(func() { j.done, j.res, j.err = true, res, err })
+	time.AfterFunc(Retention, func() {
+		r.mu.Lock()
+		delete(r.jobs, j.ID)
+		r.mu.Unlock()
+	})
+}
+
+func (r *Registry) doneWaiting() {
+	r.mu.Lock()
+	r.waiting--
+	r.mu.Unlock()
+}
+
+// observe adds the duration d of a Job to the moving average.
+func (r *Registry) observe(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.mean == 0 {
+		r.mean = d
+		return
+	}
+	r.mean += (d - r.mean) / 5
+}
+
+func (j *Job) update(f func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f()
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// Cancel cancels the Job if it hasn't finished.
+func (j *Job) Cancel() { j.cancel() }
+
+// Events waits for the Job to have emitted more than from events, or
+// to finish, and returns its events past the first from. done reports
+// whether the Job has finished, in which case there are no more events.
+// If ctx is done first, Events returns ctx's error.
+func (j *Job) Events(ctx context.Context, from int) (events []eggsy.Event, done bool, err error) {
+	for {
+		j.mu.Lock()
+		if from < len(j.events) || j.done {
+			if from < len(j.events) {
+				events = j.events[from:len(j.events):len(j.events)]
+			}
+			done = j.done
+			j.mu.Unlock()
+			return events, done, nil
+		}
+		changed := j.changed
+		j.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// Len returns the number of events the Job has emitted so far.
+func (j *Job) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.events)
+}
+
+// Result returns the result of the Job and the error it finished with.
+// ok reports whether the Job has finished.
+func (j *Job) Result() (res *eggsy.ExecResult, err error, ok bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.res, j.err, j.done
+}
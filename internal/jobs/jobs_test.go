@@ -0,0 +1,63 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package jobs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/smasher164/eggsy"
+)
+
+func TestSubmitMaxWaiting(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	blocked := func() *eggsy.Executor {
+		return &eggsy.Executor{Policy: eggsy.PolicyFunc(func(*eggsy.Executor) error {
+			<-release
+			return errors.New("released")
+		})}
+	}
+	r := New(1)
+	if _, err := r.Submit(blocked(), 1); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		r.mu.Lock()
+		n := r.waiting
+		r.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first job never started executing")
+		}
+	}
+	if _, err := r.Submit(blocked(), 1); err != nil {
+		t.Fatalf("Submit of a job to wait = %v", err)
+	}
+	if _, err := r.Submit(blocked(), 1); !errors.Is(err, eggsy.ErrQueueFull) {
+		t.Fatalf("Submit beyond maxWaiting = %v, want ErrQueueFull", err)
+	}
+}
@@ -0,0 +1,120 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package judge
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strconv"
+)
+
+// Checker judges the output of a submission on a case that ran to
+// completion.
+type Checker interface {
+	Check(ctx context.Context, c *Case, output []byte) (Verdict, error)
+}
+
+// Compare is a Checker that accepts output if the function reports it
+// is equivalent to the expected output of the case.
+type Compare func(output, expected []byte) bool
+
+// Check implements Checker.
+func (f Compare) Check(ctx context.Context, c *Case, output []byte) (Verdict, error) {
+	if f(output, c.Expected) {
+		return Accepted, nil
+	}
+	return WrongAnswer, nil
+}
+
+var (
+	// Exact accepts output that is identical to the expected output.
+	Exact Checker = Compare(bytes.Equal)
+
+	// Trimmed accepts output that is identical to the expected output
+	// but for whitespace at the end of each line, and for blank lines
+	// at the end.
+	Trimmed Checker = Compare(trimmedEqual)
+)
+
+func trimmedEqual(output, expected []byte) bool {
+	a, b := trimLines(output), trimLines(expected)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// trimLines splits b into lines without trailing whitespace, leaving
+// out the blank lines at the end.
+func trimLines(b []byte) [][]byte {
+	lines := bytes.Split(b, []byte("\n"))
+	for i, l := range lines {
+		lines[i] = bytes.TrimRight(l, " \t\r\v\f")
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Float returns a Checker that compares output to the expected output
+// token by token, as separated by whitespace. Tokens that are both
+// numbers are equivalent if they differ by at most tolerance, either
+// absolutely or relative to the expected number; other tokens must be
+// identical.
+func Float(tolerance float64) Checker {
+	return Compare(func(output, expected []byte) bool {
+		a, b := bytes.Fields(output), bytes.Fields(expected)
+		if len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !bytes.Equal(a[i], b[i]) && !floatEqual(a[i], b[i], tolerance) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func floatEqual(a, b []byte, tolerance float64) bool {
+	x, err := strconv.ParseFloat(string(a), 64)
+	if err != nil {
+		return false
+	}
+	y, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return false
+	}
+	if math.IsNaN(x) || math.IsNaN(y) {
+		return math.IsNaN(x) && math.IsNaN(y)
+	}
+	d := math.Abs(x - y)
+	return d <= tolerance || d <= tolerance*math.Abs(y)
+}
@@ -0,0 +1,70 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package judge
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckers(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		checker  Checker
+		output   string
+		expected string
+		want     Verdict
+	}{
+		{"exact", Exact, "1 2\n", "1 2\n", Accepted},
+		{"exact trailing newline", Exact, "1 2", "1 2\n", WrongAnswer},
+		{"exact trailing space", Exact, "1 2 \n", "1 2\n", WrongAnswer},
+
+		{"trimmed", Trimmed, "1 2\n", "1 2\n", Accepted},
+		{"trimmed no trailing newline", Trimmed, "1 2", "1 2\n", Accepted},
+		{"trimmed blank lines at end", Trimmed, "1 2\n\n\n", "1 2\n", Accepted},
+		{"trimmed trailing whitespace", Trimmed, "1 2 \t\r\n3\r\n", "1 2\n3\n", Accepted},
+		{"trimmed leading whitespace", Trimmed, " 1 2\n", "1 2\n", WrongAnswer},
+		{"trimmed inner whitespace", Trimmed, "1  2\n", "1 2\n", WrongAnswer},
+		{"trimmed blank line between", Trimmed, "1\n\n2\n", "1\n2\n", WrongAnswer},
+		{"trimmed empty", Trimmed, "", "\n", Accepted},
+
+		{"float equal", Float(1e-6), "0.5 1\n", "0.5 1\n", Accepted},
+		{"float within absolute", Float(1e-6), "0.1000005", "0.1", Accepted},
+		{"float beyond absolute", Float(1e-6), "0.100002", "0.1", WrongAnswer},
+		{"float within relative", Float(1e-6), "1000000.5", "1000000", Accepted},
+		{"float beyond relative", Float(1e-6), "1000002", "1000000", WrongAnswer},
+		{"float whitespace", Float(1e-6), "1.0\n 2.0  ", "1 2\n", Accepted},
+		{"float words", Float(1e-6), "yes 1.0", "no 1.0", WrongAnswer},
+		{"float fewer tokens", Float(1e-6), "1.0", "1.0 2.0", WrongAnswer},
+		{"float NaN", Float(1e-6), "NaN", "nan", Accepted},
+		{"float NaN and number", Float(1e-6), "NaN", "1", WrongAnswer},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Case{Expected: []byte(tt.expected)}
+			got, err := tt.checker.Check(context.Background(), c, []byte(tt.output))
+			if err != nil || got != tt.want {
+				t.Errorf("Check(%q, %q) = %q, %v; want %q", tt.output, tt.expected, got, err, tt.want)
+			}
+		})
+	}
+}
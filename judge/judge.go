@@ -0,0 +1,232 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package judge runs a submission against test cases in eggsy's sandbox,
+// and judges its output for each, as online judges do:
+//
+//	j := &judge.Judge{Options: []func(*eggsy.Executor){preset.C.Option(source)}}
+//	results, err := j.Run(ctx, cases)
+//
+// The submission's image is built once, and every case is run in a
+// container of its own created from it.
+package judge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/smasher164/eggsy"
+)
+
+// Verdict is the judgement of a submission on a case.
+type Verdict string
+
+// The verdicts of a case.
+const (
+	Accepted            Verdict = "AC"
+	WrongAnswer         Verdict = "WA"
+	TimeLimitExceeded   Verdict = "TLE"
+	MemoryLimitExceeded Verdict = "MLE"
+	RuntimeError        Verdict = "RE"
+)
+
+// DefaultMaxOutputBytes is the default limit of the output of a case.
+const DefaultMaxOutputBytes = 64 << 20
+
+// Case is a test case of a submission.
+type Case struct {
+	// Name identifies the case, such as by the name of its input file.
+	Name string
+
+	// Input is the standard input of the submission, and Expected is
+	// the output the Checker compares its standard output to.
+	Input    []byte
+	Expected []byte
+
+	// Timeout and Memory, if positive, override the Executor's limits
+	// for the case.
+	Timeout time.Duration
+	Memory  int64
+}
+
+// Result is the judgement of a submission on a case. Its JSON encoding
+// has Time and CPU in milliseconds, and Err as its message.
+type Result struct {
+	// Version is the eggsy.SchemaVersion of the result.
+	Version int `json:"v"`
+
+	// Case is the name of the case.
+	Case string `json:"case"`
+
+	// Verdict is the judgement of the case. It is empty if the case
+	// couldn't be judged, in which case Err says why.
+	Verdict Verdict `json:"verdict,omitempty"`
+
+	// Time is the wall-clock time the submission ran for, CPU the CPU
+	// time it used, and Memory the most memory it used in bytes. CPU
+	// and Memory are zero if the backend doesn't report them.
+	Time   time.Duration `json:"-"`
+	CPU    time.Duration `json:"-"`
+	Memory uint64        `json:"memory"`
+
+	// Output and Stderr are the standard output and standard error of
+	// the submission, up to MaxOutputBytes each.
+	Output []byte `json:"output"`
+	Stderr []byte `json:"stderr"`
+
+	// Exec is the result of running the submission, if it ran.
+	Exec *eggsy.ExecResult `json:"exec,omitempty"`
+
+	// Err is the error that running or checking the case failed with.
+	Err error `json:"-"`
+}
+
+// MarshalJSON encodes r with its times in milliseconds.
+func (r *Result) MarshalJSON() ([]byte, error) {
+	type result Result
+	var msg string
+	if r.Err != nil {
+		msg = r.Err.Error()
+	}
+	return json.Marshal(struct {
+		*result
+		TimeMS int64  `json:"timeMs"`
+		CPUMS  int64  `json:"cpuMs"`
+		Err    string `json:"error,omitempty"`
+	}{(*result)(r), r.Time.Milliseconds(), r.CPU.Milliseconds(), msg})
+}
+
+// Judge runs a submission against test cases. A Judge may be used for
+// any number of concurrent runs.
+type Judge struct {
+	// Options configure the Executor that runs the submission for each
+	// case, and describe the submission, such as preset.Go.Option does.
+	// They must give every case the same Dockerfile or Image, as
+	// eggsy.ExecuteAll requires.
+	Options []func(*eggsy.Executor)
+
+	// Checker judges the output of each case that ran to completion,
+	// such as by comparing it to the expected output, or by running a
+	// Program. If nil, Trimmed is used.
+	Checker Checker
+
+	// MaxParallel limits the number of cases running at once. Zero
+	// means any number.
+	MaxParallel int
+
+	// MaxOutputBytes limits each of the standard output and standard
+	// error of a case, whose output is judged a wrong answer if it is
+	// longer. If zero, DefaultMaxOutputBytes is used.
+	MaxOutputBytes int64
+}
+
+// Run builds the submission and runs it against each case. It returns
+// an error only if the submission can't be built, such as an
+// eggsy.BuildError if it fails to compile, and otherwise returns the
+// judgement of each case at the same index.
+func (j *Judge) Run(ctx context.Context, cases []Case) ([]Result, error) {
+	max := j.MaxOutputBytes
+	if max == 0 {
+		max = DefaultMaxOutputBytes
+	}
+	jobs := make([]eggsy.Job, len(cases))
+	outs := make([]struct{ stdout, stderr bytes.Buffer }, len(cases))
+	for i := range cases {
+		c := &cases[i]
+		jobs[i] = eggsy.Job{
+			Stdin:  bytes.NewReader(c.Input),
+			Stdout: &outs[i].stdout,
+			Stderr: &outs[i].stderr,
+			Options: []func(*eggsy.Executor){func(e *eggsy.Executor) {
+				if c.Timeout > 0 {
+					e.Timeout = c.Timeout
+				}
+				if c.Memory > 0 {
+					e.Memory = c.Memory
+				}
+				e.MaxOutputBytes = max
+				e.KillOnOutputLimit = true
+			}},
+		}
+	}
+	brs, err := eggsy.ExecuteAll(ctx, jobs, eggsy.BatchOptions{MaxParallel: j.MaxParallel, Options: j.Options})
+	if err != nil {
+		return nil, err
+	}
+	checker := j.Checker
+	if checker == nil {
+		checker = Trimmed
+	}
+	results := make([]Result, len(cases))
+	for i, br := range brs {
+		r := &results[i]
+		r.Version = eggsy.SchemaVersion
+		r.Case = cases[i].Name
+		r.Output = outs[i].stdout.Bytes()
+		r.Stderr = outs[i].stderr.Bytes()
+		r.Exec = br.Result
+		if res := br.Result; res != nil {
+			r.Time = res.Duration()
+			if res.Usage != nil {
+				r.CPU = res.Usage.CPU()
+				r.Memory = res.Usage.MaxMemory
+			}
+		}
+		r.Verdict, r.Err = verdict(br.Result, br.Err)
+		if r.Verdict == "" && r.Err == nil {
+			r.Verdict, r.Err = checker.Check(ctx, &cases[i], r.Output)
+		}
+	}
+	return results, nil
+}
+
+// verdict judges how the submission ran, and returns an empty Verdict
+// and a nil error if its output is left to be checked.
+func verdict(res *eggsy.ExecResult, err error) (Verdict, error) {
+	switch {
+	case res == nil:
+		return "", err
+	case res.TimedOut || res.CPUTimeExceeded:
+		return TimeLimitExceeded, nil
+	case res.OOMKilled:
+		return MemoryLimitExceeded, nil
+	case res.StdoutTruncated || res.StderrTruncated:
+		return WrongAnswer, nil
+	case res.ExitCode != 0:
+		return RuntimeError, nil
+	}
+	return "", err
+}
+
+// Overall returns the verdict of a submission on every case: the first
+// verdict of results other than Accepted, or Accepted if there is none.
+// It returns an empty Verdict if a case couldn't be judged.
+func Overall(results []Result) Verdict {
+	for _, r := range results {
+		if r.Verdict != Accepted {
+			return r.Verdict
+		}
+	}
+	return Accepted
+}
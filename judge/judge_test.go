@@ -0,0 +1,96 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package judge
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smasher164/eggsy"
+)
+
+func TestResultJSON(t *testing.T) {
+	r := &Result{
+		Version: eggsy.SchemaVersion,
+		Case:    "1.in",
+		Verdict: Accepted,
+		Time:    1500 * time.Millisecond,
+		CPU:     250 * time.Millisecond,
+		Memory:  1 << 20,
+		Exec:    &eggsy.ExecResult{Version: eggsy.SchemaVersion},
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"v":1`, `"case":"1.in"`, `"verdict":"AC"`, `"timeMs":1500`, `"cpuMs":250`, `"memory":1048576`, `"exec":{"v":1,`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("json.Marshal = %s, want %s", b, want)
+		}
+	}
+	if strings.Contains(string(b), `"error"`) {
+		t.Errorf("json.Marshal = %s, want no error", b)
+	}
+
+	r = &Result{Case: "2.in", Err: errors.New("checker failed")}
+	if b, _ = json.Marshal(r); !strings.Contains(string(b), `"error":"checker failed"`) {
+		t.Errorf("json.Marshal = %s, want the error's message", b)
+	}
+}
+
+func TestVerdict(t *testing.T) {
+	errRun := errors.New("daemon gone")
+	for _, tt := range []struct {
+		name    string
+		res     *eggsy.ExecResult
+		err     error
+		want    Verdict
+		wantErr error
+	}{
+		{"not run", nil, errRun, "", errRun},
+		{"ran", &eggsy.ExecResult{}, nil, "", nil},
+		{"nonzero exit", &eggsy.ExecResult{ExitCode: 1}, nil, RuntimeError, nil},
+		{"truncated", &eggsy.ExecResult{StdoutTruncated: true}, nil, WrongAnswer, nil},
+		{"stderr truncated", &eggsy.ExecResult{StderrTruncated: true}, nil, WrongAnswer, nil},
+		{"oom", &eggsy.ExecResult{OOMKilled: true, ExitCode: 137}, nil, MemoryLimitExceeded, nil},
+		{"cpu", &eggsy.ExecResult{CPUTimeExceeded: true, ExitCode: 137}, nil, TimeLimitExceeded, nil},
+
+		// the verdicts take precedence in the order TLE, MLE, WA, RE
+		{"timeout over oom", &eggsy.ExecResult{TimedOut: true, OOMKilled: true, ExitCode: 137}, &eggsy.TimeoutError{}, TimeLimitExceeded, nil},
+		{"cpu over oom", &eggsy.ExecResult{CPUTimeExceeded: true, OOMKilled: true, ExitCode: 137}, nil, TimeLimitExceeded, nil},
+		{"oom over truncated", &eggsy.ExecResult{OOMKilled: true, StdoutTruncated: true, ExitCode: 137}, nil, MemoryLimitExceeded, nil},
+		{"truncated over exit", &eggsy.ExecResult{StdoutTruncated: true, ExitCode: 137}, nil, WrongAnswer, nil},
+		{"exit over error", &eggsy.ExecResult{ExitCode: 1}, errRun, RuntimeError, nil},
+		{"error of a result", &eggsy.ExecResult{}, errRun, "", errRun},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verdict(tt.res, tt.err)
+			if got != tt.want || err != tt.wantErr {
+				t.Errorf("verdict = %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
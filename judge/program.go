@@ -0,0 +1,199 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package judge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/smasher164/eggsy"
+)
+
+// The names of the files a Program is given, in its working directory.
+const (
+	InputFile  = "input.txt"
+	OutputFile = "output.txt"
+	AnswerFile = "answer.txt"
+)
+
+// ErrProgramClosed is returned when checking output with a closed Program.
+var ErrProgramClosed = errors.New("eggsy: checker program is closed")
+
+// CheckerError is returned by a Program whose checker exits with a code
+// other than those of a verdict, which means that it failed to check the
+// output.
+type CheckerError struct {
+	ExitCode int
+	Stderr   []byte
+}
+
+func (c *CheckerError) Error() string {
+	return fmt.Sprintf("eggsy: checker failed with exit code %d: %s", c.ExitCode, bytes.TrimSpace(c.Stderr))
+}
+
+// Program is a Checker that runs a checker program in the sandbox, for
+// problems whose output can't be compared to the expected output, such
+// as those with many correct answers. For each case, the input, the
+// output of the submission, and the expected output are written to
+// InputFile, OutputFile, and AnswerFile in the checker's working
+// directory, and their names are passed to it as arguments, as with
+// testlib. The checker accepts the output by exiting with code 0, and
+// rejects it by exiting with code 1 or 2.
+//
+// With the Docker backend, the checker's image is built once, the first
+// time it is needed, and each case is run from it over the connection
+// it was built with. Close removes the image and closes the connection.
+// A Program is safe for concurrent use.
+type Program struct {
+	// Options configure the Executor that runs the checker, and
+	// describe the checker, such as preset.CPP.Option does.
+	Options []func(*eggsy.Executor)
+
+	mu      sync.Mutex
+	builder *eggsy.Executor
+	ref     eggsy.ImageRef
+	built   bool
+	closed  bool
+}
+
+// Check implements Checker.
+func (p *Program) Check(ctx context.Context, c *Case, output []byte) (Verdict, error) {
+	e := p.executor()
+	files := eggsy.MapFileSet(map[string][]byte{
+		InputFile:  c.Input,
+		OutputFile: output,
+		AnswerFile: c.Expected,
+	})
+	if b, ok := e.Backend.(eggsy.WASMBackend); ok && len(e.Args) == 0 {
+		// a module has no shell to run Cmd
+		e.Args = []string{b.Module}
+	}
+	if len(e.Args) > 0 {
+		e.Args = append(e.Args[:len(e.Args):len(e.Args)], InputFile, OutputFile, AnswerFile)
+	} else {
+		e.Cmd = strings.Join([]string{e.Cmd, InputFile, OutputFile, AnswerFile}, " ")
+	}
+	var stderr bytes.Buffer
+	e.Stderr = &stderr
+	var (
+		res *eggsy.ExecResult
+		err error
+	)
+	switch e.Backend.(type) {
+	case eggsy.WASMBackend, eggsy.NsjailBackend:
+		if e.Files != nil {
+			files = fileSets{e.Files, files}
+		}
+		e.Files = files
+		res, err = e.Execute(ctx)
+	default:
+		var ref eggsy.ImageRef
+		if ref, err = p.build(ctx); err != nil {
+			return "", err
+		}
+		// the files of the case are copied into its container
+		if ref, err = ref.WithFiles(files); err != nil {
+			return "", err
+		}
+		res, err = e.Run(ctx, ref)
+	}
+	if err != nil {
+		return "", err
+	}
+	switch res.ExitCode {
+	case 0:
+		return Accepted, nil
+	case 1, 2:
+		return WrongAnswer, nil
+	}
+	return "", &CheckerError{ExitCode: res.ExitCode, Stderr: stderr.Bytes()}
+}
+
+// executor returns an Executor configured by the Program's Options.
+func (p *Program) executor() *eggsy.Executor {
+	e := new(eggsy.Executor)
+	for _, opt := range p.Options {
+		opt(e)
+	}
+	return e
+}
+
+// build builds the checker's image, unless it has been built already.
+// A failed build is tried again by the next Check.
+func (p *Program) build(ctx context.Context) (eggsy.ImageRef, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return eggsy.ImageRef{}, ErrProgramClosed
+	}
+	if !p.built {
+		b := p.executor()
+		ref, err := b.Build(ctx)
+		if err != nil {
+			b.Close()
+			return eggsy.ImageRef{}, err
+		}
+		p.builder, p.ref, p.built = b, ref, true
+	}
+	return p.ref, nil
+}
+
+// Close removes the checker's image. Checks must not be running.
+func (p *Program) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if !p.built {
+		return nil
+	}
+	defer p.builder.Close()
+	return p.builder.Remove(context.Background(), p.ref)
+}
+
+// fileSets is a FileSet of the files of each FileSet in turn.
+type fileSets []eggsy.FileSet
+
+func (s fileSets) Len() int {
+	n := 0
+	for _, fs := range s {
+		n += fs.Len()
+	}
+	return n
+}
+
+func (s fileSets) At(i int) (eggsy.File, error) {
+	for _, fs := range s {
+		if i < fs.Len() {
+			return fs.At(i)
+		}
+		i -= fs.Len()
+	}
+	return eggsy.File{}, fmt.Errorf("eggsy: file index %d out of range", i)
+}
@@ -0,0 +1,56 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// logger returns the Executor's Logger, or a Logger that discards
+// everything if it has none.
+func (e *Executor) logger() *slog.Logger {
+	if e.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return e.Logger
+}
+
+// removeContainer removes the container, logging any failure.
+func (e *Executor) removeContainer(cID string) {
+	// ctx may already be done by the time the container is removed
+	err := e.cli.ContainerRemove(context.Background(), cID, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !client.IsErrNotFound(err) {
+		e.logger().Warn("eggsy: removing container failed", "container", cID, "err", err)
+	}
+}
+
+// removeImage removes the image of ref, logging any failure.
+func (e *Executor) removeImage(ref ImageRef) {
+	if err := e.Remove(context.Background(), ref); err != nil && !client.IsErrNotFound(err) {
+		e.logger().Warn("eggsy: removing image failed", "image", ref.Tag, "err", err)
+	}
+}
@@ -0,0 +1,82 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import "github.com/docker/docker/api/types/mount"
+
+// MountType is the kind of a Mount.
+type MountType string
+
+const (
+	// MountBind mounts a file or directory of the daemon's host.
+	MountBind MountType = "bind"
+
+	// MountVolume mounts a named volume, which the daemon creates if
+	// it doesn't exist.
+	MountVolume MountType = "volume"
+
+	// MountTmpfs mounts a tmpfs, which is discarded with the container.
+	MountTmpfs MountType = "tmpfs"
+)
+
+// Mount describes a filesystem mounted into the container, such as a
+// large dataset or toolchain that would be slow to copy into it.
+type Mount struct {
+	Type MountType
+
+	// Source is the path on the daemon's host of a MountBind, or the
+	// name of a MountVolume. It is ignored for a MountTmpfs.
+	Source string
+
+	// Target is the absolute path in the container to mount it on.
+	Target string
+
+	// ReadOnly mounts it read-only, which is recommended for anything
+	// shared between executions.
+	ReadOnly bool
+
+	// Size limits the size in bytes of a MountTmpfs. Zero imposes
+	// no limit beyond the container's Memory.
+	Size int64
+}
+
+// mounts returns the Executor's Mounts as the daemon takes them.
+func (e *Executor) mounts() []mount.Mount {
+	var ms []mount.Mount
+	for _, m := range e.Mounts {
+		dm := mount.Mount{
+			Type:     mount.Type(m.Type),
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Type == MountTmpfs {
+			if m.Size > 0 {
+				dm.TmpfsOptions = &mount.TmpfsOptions{SizeBytes: m.Size}
+			}
+		} else {
+			dm.Source = m.Source
+		}
+		ms = append(ms, dm)
+	}
+	return ms
+}
@@ -0,0 +1,381 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// NsjailBackend runs the Executor's command under nsjail, which isolates
+// it in Linux namespaces of its own on the calling process's host. It
+// starts in milliseconds, but the command shares the host's kernel, and
+// sees the host directories in ReadOnly, so it suits commands that are
+// trusted more than those run under gVisor or a VM.
+//
+// Only Execute supports it. The Dockerfile and Image are ignored, and
+// Files are written to a temporary directory that the command runs in,
+// mounted at /work unless WorkingDir is set. The command runs as a user
+// with the caller's uid, mapped to root in its user namespace.
+//
+// Timeout, CPUTimeLimit, Ulimits, Mounts, and Tmpfs work as they do for
+// containers. Memory limits the address space of each process, unless
+// Cgroups is set. Seccomp is translated to a Kafel policy, which nsjail
+// compiles; without one, no system calls are filtered. NetNone leaves
+// the command without a network, NetBridge shares the host's network,
+// and NetRestricted isn't supported. Other fields of the Executor that
+// configure the container, such as Runtime and Artifacts, are ignored.
+type NsjailBackend struct {
+	// Path is the path of the nsjail binary. If empty, nsjail is
+	// looked up in PATH.
+	Path string
+
+	// ReadOnly are the host directories mounted read-only at the same
+	// paths, which the command's programs and libraries are found in.
+	// If nil, they are those of /bin, /sbin, /lib, /lib32, /lib64, /usr,
+	// and /etc that exist.
+	ReadOnly []string
+
+	// Cgroups limits Memory, PidsLimit, and CPUQuota with a cgroup v2
+	// of the command, which nsjail must be able to create under its
+	// --cgroupv2_mount, usually by running as root.
+	Cgroups bool
+}
+
+// ClientOpts implements Backend. It returns ErrNoDaemon.
+func (b NsjailBackend) ClientOpts() ([]func(*client.Client) error, error) {
+	return nil, ErrNoDaemon
+}
+
+var nsjailReadOnly = []string{"/bin", "/sbin", "/lib", "/lib32", "/lib64", "/usr", "/etc"}
+
+// nsjailRlimits maps the names of Ulimits to the flags of nsjail, and
+// whether their values are in MiB rather than counts or seconds.
+var nsjailRlimits = map[string]struct {
+	flag string
+	mib  bool
+}{
+	"as":     {"--rlimit_as", true},
+	"core":   {"--rlimit_core", true},
+	"cpu":    {"--rlimit_cpu", false},
+	"fsize":  {"--rlimit_fsize", true},
+	"nofile": {"--rlimit_nofile", false},
+	"nproc":  {"--rlimit_nproc", false},
+	"stack":  {"--rlimit_stack", true},
+}
+
+// nsjailArgs returns the arguments of nsjail that run the Executor's
+// command with its Files in dir.
+func (e *Executor) nsjailArgs(b NsjailBackend, dir string) ([]string, error) {
+	work := e.WorkingDir
+	if work == "" {
+		work = "/work"
+	}
+	args := []string{"-Mo", "--quiet", "--cwd", work, "-B", dir + ":" + work, "-T", "/tmp",
+		"-R", "/dev/null", "-R", "/dev/zero", "-R", "/dev/urandom",
+		"-E", "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"-E", "HOME=" + work,
+	}
+	ro := b.ReadOnly
+	if ro == nil {
+		for _, d := range nsjailReadOnly {
+			if _, err := os.Stat(d); err == nil {
+				ro = append(ro, d)
+			}
+		}
+	}
+	for _, d := range ro {
+		args = append(args, "-R", d)
+	}
+	for _, m := range e.Mounts {
+		switch {
+		case m.Type == MountTmpfs:
+			opts := "tmpfs"
+			if m.Size > 0 {
+				opts += ":size=" + strconv.FormatInt(m.Size, 10)
+			}
+			args = append(args, "-m", "none:"+m.Target+":"+opts)
+		case m.Type == MountBind && m.ReadOnly:
+			args = append(args, "-R", m.Source+":"+m.Target)
+		case m.Type == MountBind:
+			args = append(args, "-B", m.Source+":"+m.Target)
+		default:
+			return nil, fmt.Errorf("eggsy: nsjail doesn't support %s mounts", m.Type)
+		}
+	}
+	targets := make([]string, 0, len(e.Tmpfs))
+	for target := range e.Tmpfs {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	for _, target := range targets {
+		args = append(args, "-m", "none:"+target+":tmpfs:"+e.Tmpfs[target])
+	}
+	switch e.Net {
+	case NetBridge:
+		args = append(args, "--disable_clone_newnet")
+	case NetRestricted:
+		return nil, errors.New("eggsy: nsjail doesn't support NetRestricted")
+	}
+
+	// nsjail's own defaults are stricter than a container's
+	limits := map[string]string{}
+	names := make([]string, 0, len(nsjailRlimits))
+	for name := range nsjailRlimits {
+		limits[name] = "soft"
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if e.Memory > 0 && !b.Cgroups {
+		limits["as"] = strconv.FormatInt((e.Memory+1<<20-1)>>20, 10)
+	}
+	if e.CPUTimeLimit > 0 {
+		limits["cpu"] = strconv.FormatInt(int64((e.CPUTimeLimit+time.Second-1)/time.Second), 10)
+	}
+	for _, u := range e.Ulimits {
+		r, ok := nsjailRlimits[u.Name]
+		if !ok {
+			return nil, fmt.Errorf("eggsy: nsjail doesn't support ulimit %q", u.Name)
+		}
+		// nsjail sets the soft and hard limits alike
+		v := u.Soft
+		if r.mib {
+			v = (v + 1<<20 - 1) >> 20
+		}
+		limits[u.Name] = strconv.FormatInt(v, 10)
+	}
+	for _, name := range names {
+		args = append(args, nsjailRlimits[name].flag, limits[name])
+	}
+	if b.Cgroups {
+		args = append(args, "--use_cgroupv2")
+		if e.Memory > 0 {
+			args = append(args, "--cgroup_mem_max", strconv.FormatInt(e.Memory, 10))
+		}
+		if e.PidsLimit > 0 {
+			args = append(args, "--cgroup_pids_max", strconv.FormatInt(e.PidsLimit, 10))
+		}
+		if e.CPUQuota > 0 {
+			period := e.CPUPeriod
+			if period <= 0 {
+				period = 100000
+			}
+			args = append(args, "--cgroup_cpu_ms_per_sec", strconv.FormatInt(e.CPUQuota*1000/period, 10))
+		}
+	}
+	if e.Seccomp != SEDefault && e.Seccomp != SEUnconfined {
+		policy, err := kafelPolicy(e.Seccomp)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "--seccomp_string", policy)
+	}
+	args = append(args, "--")
+	if len(e.Args) > 0 {
+		// nsjail doesn't search PATH
+		prog := e.Args[0]
+		if !strings.Contains(prog, "/") {
+			return append(append(args, "/bin/sh", "-c", `exec "$0" "$@"`), e.Args...), nil
+		}
+		return append(args, e.Args...), nil
+	}
+	return append(args, "/bin/sh", "-c", e.Cmd), nil
+}
+
+// kafelPolicy translates a seccomp profile in the JSON format of Docker
+// to a Kafel policy. The profile's architectures are ignored, since the
+// policy applies to those of the host.
+func kafelPolicy(profile string) (string, error) {
+	var p struct {
+		DefaultAction string `json:"defaultAction"`
+		Syscalls      []struct {
+			Names  []string `json:"names"`
+			Action string   `json:"action"`
+		} `json:"syscalls"`
+	}
+	if err := json.Unmarshal([]byte(profile), &p); err != nil {
+		return "", fmt.Errorf("eggsy: invalid seccomp profile: %v", err)
+	}
+	var sb strings.Builder
+	sb.WriteString("POLICY eggsy {\n")
+	for _, s := range p.Syscalls {
+		act, err := kafelAction(s.Action)
+		if err != nil {
+			return "", err
+		}
+		if len(s.Names) > 0 {
+			fmt.Fprintf(&sb, "\t%s { %s }\n", act, strings.Join(s.Names, ", "))
+		}
+	}
+	act, err := kafelAction(p.DefaultAction)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&sb, "}\nUSE eggsy DEFAULT %s\n", act)
+	return sb.String(), nil
+}
+
+func kafelAction(action string) (string, error) {
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return "ALLOW", nil
+	case "SCMP_ACT_ERRNO":
+		return "ERRNO(1)", nil
+	case "SCMP_ACT_KILL":
+		return "KILL", nil
+	case "SCMP_ACT_TRAP":
+		return "TRAP(0)", nil
+	case "SCMP_ACT_LOG":
+		return "LOG", nil
+	}
+	return "", fmt.Errorf("eggsy: nsjail doesn't support seccomp action %q", action)
+}
+
+// executeNsjail executes the Executor's command under nsjail.
+func (e *Executor) executeNsjail(ctx context.Context, b NsjailBackend) (res *ExecResult, err error) {
+	dir, err := ioutil.TempDir("", "eggsy-nsjail-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	err = e.extractFiles(dir)
+	stdin := e.Stdin
+	if e.stdin != nil {
+		defer e.stdin.Close()
+		stdin = e.stdin
+	}
+	if err != nil {
+		return nil, err
+	}
+	args, err := e.nsjailArgs(b, dir)
+	if err != nil {
+		return nil, err
+	}
+	prog := b.Path
+	if prog == "" {
+		prog = "nsjail"
+	}
+
+	cctx := ctx
+	var timedOut int32
+	if e.Timeout >= 0 {
+		var cancel context.CancelFunc
+		cctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		t := time.AfterFunc(e.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		defer t.Stop()
+	}
+	cmd := exec.CommandContext(cctx, prog, args...)
+	// nsjail kills the command as it exits on SIGTERM
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = time.Second
+	stdout, stderr, flush, err := e.wrapOutput()
+	if err != nil {
+		return nil, err
+	}
+	written := &countWriter{}
+	cmd.Stdout = io.MultiWriter(stdout, written)
+	cmd.Stderr = io.MultiWriter(stderr, written)
+	var stdinW io.WriteCloser
+	if stdin != nil {
+		// Wait would wait for stdin to be read to its end, which an
+		// interactive stdin may never reach
+		if stdinW, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	if stdinW != nil {
+		go func() {
+			io.Copy(stdinW, stdin)
+			stdinW.Close()
+		}()
+	}
+	e.em.emit(Event{Type: EventStart})
+	e.logger().Info("eggsy: jail started", "pid", cmd.Process.Pid)
+	err = cmd.Wait()
+	flush()
+	res = &ExecResult{Version: SchemaVersion, Started: start, Finished: time.Now()}
+	if cmd.ProcessState == nil {
+		return nil, err
+	}
+	ec := cmd.ProcessState.ExitCode()
+	if ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		ec = 128 + int(ws.Signal())
+	}
+	res.ExitCode = ec
+	if ru, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		// the usage of nsjail includes that of the command it waited for
+		res.Usage = &Usage{
+			MaxMemory: uint64(ru.Maxrss) << 10,
+			CPUUser:   time.Duration(ru.Utime.Nano()),
+			CPUSystem: time.Duration(ru.Stime.Nano()),
+		}
+	}
+	switch {
+	case ctx.Err() != nil:
+		e.logger().Info("eggsy: execution canceled", "err", ctx.Err())
+		return nil, &ContextError{Cmd: e.command(), Err: ctx.Err()}
+	case atomic.LoadInt32(&timedOut) == 1:
+		res.Status = StatusTimeout
+		res.TimedOut = true
+		e.em.emit(exitEvent(ec, res.Status))
+		e.logger().Info("eggsy: jail exited", "exitCode", ec, "status", res.Status)
+		return res, &TimeoutError{
+			Cmd:     e.command(),
+			Timeout: e.Timeout,
+			Elapsed: res.Duration(),
+			Output:  written.n,
+		}
+	}
+	// a process killed by RLIMIT_CPU exits from SIGXCPU or SIGKILL
+	res.CPUTimeExceeded = e.CPUTimeLimit > 0 && (ec == 128+24 || ec == 128+9) &&
+		res.Usage != nil && res.Usage.CPU() >= e.CPUTimeLimit-time.Second
+	res.Status = StatusOK
+	if res.CPUTimeExceeded {
+		res.Status = StatusCPUTimeExceeded
+	}
+	e.em.emit(exitEvent(ec, res.Status))
+	e.logger().Info("eggsy: jail exited", "exitCode", ec, "status", res.Status)
+	return res, nil
+}
@@ -0,0 +1,626 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// BinaryPolicy determines how output that isn't valid UTF-8 is written.
+// See the constant definitions for the available policies.
+type BinaryPolicy int
+
+const (
+	// BinaryPass writes output unmodified. It is the default policy.
+	BinaryPass BinaryPolicy = 0
+
+	// BinaryHex writes each invalid byte as a \xNN escape.
+	BinaryHex BinaryPolicy = 1
+
+	// BinaryReplace writes each invalid byte as the Unicode
+	// replacement character U+FFFD.
+	BinaryReplace BinaryPolicy = 2
+)
+
+// wrapOutput returns the writers that the container's standard output
+// and standard error are copied to, which wrap Stdout and Stderr, and a
+// function that flushes them once copying is done.
+func (e *Executor) wrapOutput() (stdout, stderr io.Writer, flush func(), err error) {
+	return e.wrapWriters(e.Stdout, e.Stderr, e.Timestamps)
+}
+
+// wrapWriters is like wrapOutput, but wraps the given writers. If stamped
+// is set, each line of output is prefixed by the daemon's timestamp.
+func (e *Executor) wrapWriters(stdout, stderr io.Writer, stamped bool) (_, _ io.Writer, flush func(), err error) {
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	if stdout == stderr {
+		stdout = &syncWriter{w: stdout}
+		stderr = stdout
+	}
+	var fans []*fanout
+	if len(e.StdoutSinks) > 0 {
+		f := newFanout(e.StdoutSinks)
+		stdout = io.MultiWriter(stdout, f)
+		fans = append(fans, f)
+	}
+	if len(e.StderrSinks) > 0 {
+		f := newFanout(e.StderrSinks)
+		stderr = io.MultiWriter(stderr, f)
+		fans = append(fans, f)
+	}
+	// when each line was produced, if Timestamps is set
+	var outAt, errAt time.Time
+	if e.em != nil {
+		stdout = io.MultiWriter(stdout, &streamWriter{e.em, EventStdout, &outAt})
+		stderr = io.MultiWriter(stderr, &streamWriter{e.em, EventStderr, &errAt})
+	}
+	var lines []*lineWriter
+	if e.OnStdoutLine != nil {
+		l := &lineWriter{fn: e.OnStdoutLine, at: &outAt}
+		stdout = io.MultiWriter(stdout, l)
+		lines = append(lines, l)
+	}
+	if e.OnStderrLine != nil {
+		l := &lineWriter{fn: e.OnStderrLine, at: &errAt}
+		stderr = io.MultiWriter(stderr, l)
+		lines = append(lines, l)
+	}
+	var filters []*filterWriter
+	if e.MaxLineLength > 0 || e.Binary != BinaryPass {
+		fout := &filterWriter{w: stdout, max: e.MaxLineLength, policy: e.Binary}
+		ferr := &filterWriter{w: stderr, max: e.MaxLineLength, policy: e.Binary}
+		stdout, stderr = fout, ferr
+		filters = append(filters, fout, ferr)
+	}
+	e.outLimit, e.errLimit = nil, nil
+	if e.MaxOutputBytes > 0 {
+		e.outLimit = &limitWriter{w: stdout, max: e.MaxOutputBytes, hit: e.outputLimitHit}
+		e.errLimit = &limitWriter{w: stderr, max: e.MaxOutputBytes, hit: e.outputLimitHit}
+		stdout, stderr = e.outLimit, e.errLimit
+	}
+	var files []*rotateWriter
+	if e.OutputDir != "" {
+		if err := os.MkdirAll(e.OutputDir, 0755); err != nil {
+			return nil, nil, nil, err
+		}
+		ext := ".log"
+		if e.OutputCompress {
+			ext = ".log.gz"
+		}
+		fout := &rotateWriter{path: filepath.Join(e.OutputDir, "stdout"+ext), max: e.OutputFileSize, keep: e.OutputRotations, gzip: e.OutputCompress}
+		ferr := &rotateWriter{path: filepath.Join(e.OutputDir, "stderr"+ext), max: e.OutputFileSize, keep: e.OutputRotations, gzip: e.OutputCompress}
+		if err := fout.open(); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := ferr.open(); err != nil {
+			fout.Close()
+			return nil, nil, nil, err
+		}
+		// persisted output is subject to neither the filters nor the limit
+		stdout = io.MultiWriter(stdout, fout)
+		stderr = io.MultiWriter(stderr, ferr)
+		files = append(files, fout, ferr)
+	}
+	e.noSpace = nil
+	if e.DiskQuota > 0 {
+		e.noSpace = &matchWriter{pattern: []byte(noSpaceMessage)}
+		stderr = io.MultiWriter(stderr, e.noSpace)
+	}
+	if stamped {
+		stdout = &stampWriter{w: stdout, at: &outAt}
+		stderr = &stampWriter{w: stderr, at: &errAt}
+	}
+	return stdout, stderr, func() {
+		for _, f := range filters {
+			f.flush()
+		}
+		for _, l := range lines {
+			l.flush()
+		}
+		for _, f := range fans {
+			f.close()
+		}
+		for _, f := range files {
+			f.Close()
+		}
+	}, nil
+}
+
+// outputLimitHit kills the running container once its output exceeds
+// MaxOutputBytes, if KillOnOutputLimit is set.
+func (e *Executor) outputLimitHit() {
+	e.mu.Lock()
+	cID := e.cID
+	e.mu.Unlock()
+	if e.KillOnOutputLimit && cID != "" {
+		go e.cli.ContainerKill(context.Background(), cID, "SIGKILL")
+	}
+}
+
+// limitWriter writes up to max bytes to w, and discards the rest. It
+// calls hit once it first discards output.
+type limitWriter struct {
+	w   io.Writer
+	max int64
+	hit func()
+
+	n         int64
+	truncated bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if l.truncated {
+		return len(p), nil
+	}
+	if rem := l.max - l.n; int64(len(p)) > rem {
+		l.truncated = true
+		l.hit()
+		if _, err := l.w.Write(p[:rem]); err != nil {
+			return 0, err
+		}
+		l.n = l.max
+		return len(p), nil
+	}
+	n, err := l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
+
+// noSpaceMessage is the message of ENOSPC, which a command that fills
+// its DiskQuota likely writes to its standard error.
+const noSpaceMessage = "No space left on device"
+
+// matchWriter records whether pattern was written to it, even if it was
+// split across writes.
+type matchWriter struct {
+	pattern []byte
+	tail    []byte // end of the output, shorter than pattern
+	matched bool
+}
+
+func (m *matchWriter) Write(p []byte) (int, error) {
+	if m.matched {
+		return len(p), nil
+	}
+	b := append(m.tail, p...)
+	if bytes.Contains(b, m.pattern) {
+		m.matched = true
+		return len(p), nil
+	}
+	if keep := len(m.pattern) - 1; len(b) > keep {
+		b = b[len(b)-keep:]
+	}
+	m.tail = append(m.tail[:0], b...)
+	return len(p), nil
+}
+
+// maxLine is the length of the longest line passed to a line callback.
+// Longer lines are split.
+const maxLine = 64 << 10
+
+// lineWriter calls fn with each line written to it, without its newline.
+// A line's time is taken from at, like a streamWriter's, or is the time
+// the line was completed.
+type lineWriter struct {
+	fn  func(line []byte, at time.Time)
+	at  *time.Time
+	buf []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			l.buf = append(l.buf, p...)
+			if len(l.buf) >= maxLine {
+				l.line()
+			}
+			break
+		}
+		l.buf = append(l.buf, p[:i]...)
+		l.line()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush passes on the incomplete line, if any.
+func (l *lineWriter) flush() {
+	if len(l.buf) > 0 {
+		l.line()
+	}
+}
+
+// line passes on the buffered line.
+func (l *lineWriter) line() {
+	at := *l.at
+	if at.IsZero() {
+		at = time.Now()
+	}
+	l.fn(l.buf, at)
+	l.buf = l.buf[:0]
+}
+
+// stampWriter strips the timestamp that the daemon prefixes to each line
+// of output when Timestamps is set, and records it in at.
+type stampWriter struct {
+	w  io.Writer
+	at *time.Time
+
+	mid bool   // within a line, past its timestamp
+	pre []byte // timestamp split across writes
+}
+
+func (s *stampWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if !s.mid {
+			i := bytes.IndexByte(p, ' ')
+			if i < 0 {
+				s.pre = append(s.pre, p...)
+				break
+			}
+			s.pre = append(s.pre, p[:i]...)
+			if t, err := time.Parse(time.RFC3339Nano, string(s.pre)); err == nil {
+				*s.at = t
+			}
+			s.pre = s.pre[:0]
+			s.mid = true
+			p = p[i+1:]
+			continue
+		}
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+			s.mid = false
+		}
+		if _, err := s.w.Write(line); err != nil {
+			return 0, err
+		}
+		p = p[len(line):]
+	}
+	return n, nil
+}
+
+// rotateWriter writes to a file, rotating it once max bytes have been
+// written to it. Rotated files are suffixed .1 through .keep, from newest
+// to oldest. If gzip is set, each file is a complete gzip stream.
+type rotateWriter struct {
+	path string
+	max  int64
+	keep int
+	gzip bool
+
+	f  *os.File
+	zw *gzip.Writer
+	w  io.Writer // f or zw
+	n  int64
+}
+
+func (r *rotateWriter) open() (err error) {
+	if r.f, err = os.Create(r.path); err != nil {
+		return err
+	}
+	r.w = r.f
+	if r.gzip {
+		r.zw = gzip.NewWriter(r.f)
+		r.w = r.zw
+	}
+	r.n = 0
+	return nil
+}
+
+func (r *rotateWriter) Write(p []byte) (n int, err error) {
+	if r.max <= 0 {
+		return r.w.Write(p)
+	}
+	for len(p) > 0 {
+		if r.n >= r.max {
+			if err := r.rotate(); err != nil {
+				return n, err
+			}
+		}
+		m := len(p)
+		if room := r.max - r.n; int64(m) > room {
+			m = int(room)
+		}
+		m, err = r.w.Write(p[:m])
+		n += m
+		r.n += int64(m)
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (r *rotateWriter) rotate() error {
+	if err := r.Close(); err != nil {
+		return err
+	}
+	if r.keep <= 0 {
+		// nothing is retained, so start the file over
+		return r.open()
+	}
+	os.Remove(r.path + "." + strconv.Itoa(r.keep))
+	for i := r.keep - 1; i > 0; i-- {
+		os.Rename(r.path+"."+strconv.Itoa(i), r.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotateWriter) Close() error {
+	if r.zw != nil {
+		if err := r.zw.Close(); err != nil {
+			r.f.Close()
+			return err
+		}
+	}
+	return r.f.Close()
+}
+
+// OpenOutput opens a file of output persisted under an Executor's
+// OutputDir. Compressed files are decompressed as they are read.
+func OpenOutput(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return struct {
+			io.Reader
+			io.Closer
+		}{br, f}, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{zr, f}, nil
+}
+
+// fanout copies each write to a set of sinks. Every sink is drained by
+// its own goroutine through its own buffer, so that a slow sink doesn't
+// hold up the others, or the copying of the output. A write that doesn't
+// fit in a sink's buffer is dropped for that sink. A sink that returns an
+// error receives no more output.
+type fanout struct {
+	sinks []*sink
+	wg    sync.WaitGroup
+}
+
+// sinkBuffer is the number of bytes buffered for each sink.
+const sinkBuffer = 1 << 20
+
+// sinkDrain is how long close waits for sinks to catch up.
+const sinkDrain = 5 * time.Second
+
+// sink is the buffer of a sink of a fanout.
+type sink struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	cond   *sync.Cond // signaled when a write is queued or the fanout closes
+	q      [][]byte
+	n      int // bytes in q
+	closed bool
+}
+
+func newFanout(ws []io.Writer) *fanout {
+	f := &fanout{sinks: make([]*sink, len(ws))}
+	f.wg.Add(len(ws))
+	for i, w := range ws {
+		s := &sink{w: w}
+		s.cond = sync.NewCond(&s.mu)
+		f.sinks[i] = s
+		go func() {
+			defer f.wg.Done()
+			s.drain()
+		}()
+	}
+	return f
+}
+
+// drain writes the queued writes to the sink until the fanout closes.
+func (s *sink) drain() {
+	var err error
+	for {
+		s.mu.Lock()
+		for len(s.q) == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if len(s.q) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		p := s.q[0]
+		s.q[0] = nil
+		s.q = s.q[1:]
+		s.n -= len(p)
+		s.mu.Unlock()
+		if err == nil {
+			_, err = s.w.Write(p)
+		}
+	}
+}
+
+func (f *fanout) Write(p []byte) (int, error) {
+	b := append([]byte(nil), p...)
+	for _, s := range f.sinks {
+		s.mu.Lock()
+		// a write that doesn't fit is dropped, as the sink is too far behind
+		if s.n+len(b) <= sinkBuffer {
+			s.q = append(s.q, b)
+			s.n += len(b)
+			s.cond.Signal()
+		}
+		s.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+// close waits for every sink to receive the output written so far, or
+// for sinkDrain to pass, after which the sinks still writing are left
+// to finish on their own.
+func (f *fanout) close() {
+	for _, s := range f.sinks {
+		s.mu.Lock()
+		s.closed = true
+		s.cond.Signal()
+		s.mu.Unlock()
+	}
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	t := time.NewTimer(sinkDrain)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+	}
+}
+
+// filterWriter applies an Executor's binary policy and line length
+// limit to one of its output streams.
+type filterWriter struct {
+	w      io.Writer
+	max    int
+	policy BinaryPolicy
+
+	n       int    // length of the current line
+	partial []byte // incomplete rune at the end of the last write
+}
+
+func (f *filterWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if f.policy != BinaryPass {
+		p = f.encode(p)
+	}
+	if f.max > 0 {
+		p = f.limit(p)
+	}
+	if len(p) > 0 {
+		if _, err := f.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// flush writes out a rune left incomplete at the end of the stream.
+func (f *filterWriter) flush() {
+	if len(f.partial) == 0 {
+		return
+	}
+	p := f.partial
+	f.partial = nil
+	var out []byte
+	for _, b := range p {
+		out = f.invalid(out, b)
+	}
+	if f.max > 0 {
+		out = f.limit(out)
+	}
+	f.w.Write(out)
+}
+
+func (f *filterWriter) encode(p []byte) []byte {
+	if len(f.partial) > 0 {
+		p = append(f.partial, p...)
+		f.partial = nil
+	}
+	out := make([]byte, 0, len(p))
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && size <= 1 {
+			if !utf8.FullRune(p) {
+				// the rest of the rune may arrive in the next write
+				f.partial = append([]byte(nil), p...)
+				break
+			}
+			out = f.invalid(out, p[0])
+			p = p[1:]
+			continue
+		}
+		out = append(out, p[:size]...)
+		p = p[size:]
+	}
+	return out
+}
+
+func (f *filterWriter) invalid(out []byte, b byte) []byte {
+	if f.policy == BinaryHex {
+		return append(out, fmt.Sprintf(`\x%02x`, b)...)
+	}
+	return append(out, "�"...)
+}
+
+// limit drops the bytes of each line past the maximum line length,
+// without splitting a rune.
+func (f *filterWriter) limit(p []byte) []byte {
+	out := make([]byte, 0, len(p))
+	for len(p) > 0 {
+		if p[0] == '\n' {
+			out = append(out, '\n')
+			f.n = 0
+			p = p[1:]
+			continue
+		}
+		_, size := utf8.DecodeRune(p)
+		if f.n+size <= f.max {
+			out = append(out, p[:size]...)
+		}
+		f.n += size
+		p = p[size:]
+	}
+	return out
+}
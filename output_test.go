@@ -0,0 +1,131 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchWriter(t *testing.T) {
+	for _, tt := range []struct {
+		writes []string
+		want   bool
+	}{
+		{[]string{"cp: write error: No space left on device\n"}, true},
+		{[]string{"cp: write error: No space le", "ft on device\n"}, true},
+		{[]string{"No ", "space ", "left ", "on ", "device"}, true},
+		{[]string{"No space left on dev", "ice"}, true},
+		{[]string{"No space left on\n", "device"}, false},
+		{[]string{"all good\n"}, false},
+	} {
+		m := &matchWriter{pattern: []byte(noSpaceMessage)}
+		for _, w := range tt.writes {
+			if n, err := m.Write([]byte(w)); n != len(w) || err != nil {
+				t.Fatalf("Write(%q) = %d, %v", w, n, err)
+			}
+		}
+		if m.matched != tt.want {
+			t.Errorf("writes %q: matched = %v, want %v", tt.writes, m.matched, tt.want)
+		}
+	}
+}
+
+func TestOutputDirUnlimited(t *testing.T) {
+	var out bytes.Buffer
+	e := &Executor{Stdout: &out, OutputDir: t.TempDir(), MaxOutputBytes: 5}
+	stdout, _, flush, err := e.wrapOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const msg = "hello, world\n"
+	io.WriteString(stdout, msg)
+	flush()
+	if got := out.String(); got != msg[:5] {
+		t.Errorf("Stdout = %q, want %q", got, msg[:5])
+	}
+	b, err := os.ReadFile(filepath.Join(e.OutputDir, "stdout.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != msg {
+		t.Errorf("stdout.log = %q, want %q", b, msg)
+	}
+}
+
+// blockingWriter blocks each write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	buf     bytes.Buffer
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return b.buf.Write(p)
+}
+
+// ackWriter acknowledges each write on ack.
+type ackWriter struct {
+	ack chan struct{}
+	buf bytes.Buffer
+}
+
+func (a *ackWriter) Write(p []byte) (int, error) {
+	a.buf.Write(p)
+	a.ack <- struct{}{}
+	return len(p), nil
+}
+
+func TestFanoutBlockingSink(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	fast := &ackWriter{ack: make(chan struct{})}
+	f := newFanout([]io.Writer{slow, fast})
+	line := append(bytes.Repeat([]byte("x"), 1023), '\n')
+	n := 2 * sinkBuffer / len(line)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < n; i++ {
+			f.Write(line)
+			<-fast.ack
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(10 * time.Second):
+		t.Fatal("a blocked sink held up writes")
+	}
+	close(slow.release)
+	f.close()
+	if want := n * len(line); fast.buf.Len() != want {
+		t.Errorf("the fast sink received %d bytes, want all %d", fast.buf.Len(), want)
+	}
+	// the slow sink holds a write besides its buffer
+	if got, max := slow.buf.Len(), sinkBuffer+len(line); got == 0 || got > max {
+		t.Errorf("the slow sink received %d bytes, want at most %d", got, max)
+	}
+}
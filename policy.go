@@ -0,0 +1,338 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrPolicyViolation matches every PolicyError under errors.Is.
+var ErrPolicyViolation = errors.New("eggsy: policy violation")
+
+// PolicyError is returned when an Executor's configuration violates its
+// Policy.
+type PolicyError struct {
+	// Rule names the rule that was violated, such as "MaxTimeout".
+	Rule   string
+	Reason string
+}
+
+func (p *PolicyError) Error() string {
+	return fmt.Sprintf("eggsy: policy violation: %s: %s", p.Rule, p.Reason)
+}
+
+// Is reports whether target is ErrPolicyViolation, so that
+// errors.Is(err, ErrPolicyViolation) matches any PolicyError.
+func (p *PolicyError) Is(target error) bool { return target == ErrPolicyViolation }
+
+// Policy decides whether an Executor may execute, so that the operators
+// of a service can enforce guardrails on what its callers request. It is
+// evaluated before anything is built or run.
+type Policy interface {
+	// Check returns an error, usually a PolicyError, if e may not
+	// execute. It must not modify e.
+	Check(e *Executor) error
+}
+
+// PolicyFunc is a Policy that calls the function.
+type PolicyFunc func(e *Executor) error
+
+// Check implements Policy.
+func (f PolicyFunc) Check(e *Executor) error { return f(e) }
+
+// Rules is a Policy of common guardrails. A zero field imposes no rule.
+type Rules struct {
+	// MaxTimeout, if positive, is the longest Timeout an Executor may
+	// have, or any of its Steps. NoTimeout is then forbidden.
+	MaxTimeout time.Duration
+
+	// MaxMemory, if positive, is the most Memory an Executor may have,
+	// or any of its Steps. No limit on Memory is then forbidden.
+	MaxMemory int64
+
+	// AllowedImages, if non-nil, are patterns of the images an Executor
+	// may use, as its Image, or as the base image or source of a COPY
+	// in its Dockerfile, in the syntax of path.Match. As * doesn't match
+	// a slash, "*" allows the official images of Docker Hub. The stages
+	// of a Dockerfile and the scratch image are always allowed.
+	AllowedImages []string
+
+	// ForbiddenNets are the network modes an Executor may not use.
+	ForbiddenNets []Network
+
+	// RequireSeccomp requires a seccomp profile of the Executor's own,
+	// rather than the daemon's default or none.
+	RequireSeccomp bool
+
+	// RequireNoNewPrivileges requires NoNewPrivileges to be set.
+	RequireNoNewPrivileges bool
+
+	// AllowCapAdd allows CapAdd to add capabilities.
+	AllowCapAdd bool
+
+	// AllowUnconfined allows disabling seccomp, AppArmor, or SELinux
+	// with Seccomp, AppArmorProfile, or SELinuxLabel.
+	AllowUnconfined bool
+
+	// AllowBindMounts allows Mounts of the daemon host's files.
+	AllowBindMounts bool
+
+	// BannedInstructions are the instructions that a Dockerfile may
+	// not have, such as "ONBUILD". An instruction followed by a flag,
+	// such as "RUN --network=host", bans only the instructions that
+	// have a flag that starts with it.
+	BannedInstructions []string
+}
+
+// StrictPolicy returns the Rules recommended for running untrusted code,
+// which the Executors of package preset satisfy. It limits executions to
+// a minute and a gigabyte of memory, allows only official images, and
+// requires that executions have no network, no added privileges, and a
+// seccomp profile.
+func StrictPolicy() *Rules {
+	return &Rules{
+		MaxTimeout:             time.Minute,
+		MaxMemory:              1 << 30,
+		AllowedImages:          []string{"*"},
+		ForbiddenNets:          []Network{NetBridge},
+		RequireSeccomp:         true,
+		RequireNoNewPrivileges: true,
+		BannedInstructions: []string{
+			"ONBUILD",
+			"RUN --security",
+			"RUN --network",
+			"RUN --device",
+			"RUN --mount=type=ssh",
+			"RUN --mount=type=secret",
+		},
+	}
+}
+
+// Check implements Policy.
+func (r *Rules) Check(e *Executor) error {
+	if r.MaxTimeout > 0 {
+		if err := r.checkTimeout(e.Timeout); err != nil {
+			return err
+		}
+		for _, s := range e.Steps {
+			if s.Timeout == 0 {
+				continue
+			}
+			if err := r.checkTimeout(s.Timeout); err != nil {
+				return err
+			}
+		}
+	}
+	if r.MaxMemory > 0 {
+		if e.Memory <= 0 || e.Memory > r.MaxMemory {
+			return r.memoryError()
+		}
+		for _, s := range e.Steps {
+			// a step's Memory replaces the container's limit
+			if s.Memory > r.MaxMemory {
+				return r.memoryError()
+			}
+		}
+	}
+	for _, n := range r.ForbiddenNets {
+		if e.Net == n {
+			return &PolicyError{Rule: "ForbiddenNets", Reason: fmt.Sprintf("network mode %s is forbidden", n)}
+		}
+	}
+	if r.RequireSeccomp && (e.Seccomp == "" || e.Seccomp == SEUnconfined) {
+		return &PolicyError{Rule: "RequireSeccomp", Reason: "a seccomp profile is required"}
+	}
+	if r.RequireNoNewPrivileges && !e.NoNewPrivileges {
+		return &PolicyError{Rule: "RequireNoNewPrivileges", Reason: "NoNewPrivileges is required"}
+	}
+	if !r.AllowCapAdd && len(e.CapAdd) > 0 {
+		return &PolicyError{Rule: "AllowCapAdd", Reason: fmt.Sprintf("adding capabilities %v is forbidden", e.CapAdd)}
+	}
+	if !r.AllowUnconfined && (e.Seccomp == SEUnconfined || e.AppArmorProfile == "unconfined" || e.SELinuxLabel == "disable") {
+		return &PolicyError{Rule: "AllowUnconfined", Reason: "disabling seccomp, AppArmor, or SELinux is forbidden"}
+	}
+	if !r.AllowBindMounts {
+		for _, m := range e.Mounts {
+			if m.Type == MountBind {
+				return &PolicyError{Rule: "AllowBindMounts", Reason: fmt.Sprintf("bind mount of %s is forbidden", m.Source)}
+			}
+		}
+	}
+	if r.AllowedImages != nil && e.Image != "" {
+		if err := r.checkImage(e.Image); err != nil {
+			return err
+		}
+	}
+	if e.Image == "" && (r.AllowedImages != nil || len(r.BannedInstructions) > 0) {
+		return r.checkDockerfile(e.Dockerfile)
+	}
+	return nil
+}
+
+func (r *Rules) checkTimeout(d time.Duration) error {
+	if d < 0 || d > r.MaxTimeout {
+		return &PolicyError{Rule: "MaxTimeout", Reason: fmt.Sprintf("timeout must be at most %v", r.MaxTimeout)}
+	}
+	return nil
+}
+
+func (r *Rules) memoryError() error {
+	return &PolicyError{Rule: "MaxMemory", Reason: fmt.Sprintf("memory must be limited to at most %d bytes", r.MaxMemory)}
+}
+
+func (r *Rules) checkImage(image string) error {
+	if !strings.Contains(image, "$") {
+		for _, p := range r.AllowedImages {
+			if ok, _ := path.Match(p, image); ok {
+				return nil
+			}
+		}
+	}
+	return &PolicyError{Rule: "AllowedImages", Reason: fmt.Sprintf("image %q is not allowed", image)}
+}
+
+// checkDockerfile checks the instructions of a Dockerfile. The bodies
+// of heredocs are checked as instructions as well, which errs on the
+// side of rejecting a Dockerfile.
+func (r *Rules) checkDockerfile(dockerfile string) error {
+	stages := map[string]bool{"scratch": true}
+	for _, inst := range instructions(dockerfile) {
+		fields := strings.Fields(inst)
+		keyword := strings.ToUpper(fields[0])
+		var flags, args []string
+		for i, f := range fields[1:] {
+			if !strings.HasPrefix(f, "--") {
+				args = fields[1+i:]
+				break
+			}
+			flags = append(flags, f)
+		}
+		for _, b := range r.BannedInstructions {
+			bk, bflag, _ := strings.Cut(b, " ")
+			if !strings.EqualFold(bk, keyword) {
+				continue
+			}
+			if bflag == "" {
+				return &PolicyError{Rule: "BannedInstructions", Reason: fmt.Sprintf("instruction %s is banned", keyword)}
+			}
+			for _, f := range flags {
+				if strings.HasPrefix(f, bflag) {
+					return &PolicyError{Rule: "BannedInstructions", Reason: fmt.Sprintf("instruction %s %s is banned", keyword, f)}
+				}
+			}
+		}
+		if r.AllowedImages == nil {
+			continue
+		}
+		switch keyword {
+		case "FROM":
+			if len(args) == 0 {
+				continue
+			}
+			if !stages[strings.ToLower(args[0])] {
+				if err := r.checkImage(args[0]); err != nil {
+					return err
+				}
+			}
+			if len(args) == 3 && strings.EqualFold(args[1], "AS") {
+				stages[strings.ToLower(args[2])] = true
+			}
+		case "COPY", "ADD":
+			for _, f := range flags {
+				from, ok := strings.CutPrefix(f, "--from=")
+				if !ok || stages[strings.ToLower(from)] || isIndex(from) {
+					continue
+				}
+				if err := r.checkImage(from); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isIndex reports whether s is the index of a stage of a Dockerfile.
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// instructions returns the instructions of a Dockerfile, with their
+// continuation lines joined and comments left out.
+func instructions(dockerfile string) []string {
+	escape := '\\'
+	var (
+		insts   []string
+		cur     strings.Builder
+		leading = true // whether parser directives may still appear
+	)
+	for _, line := range strings.Split(dockerfile, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if leading && strings.HasPrefix(trimmed, "#") {
+			k, v, ok := strings.Cut(strings.TrimSpace(trimmed[1:]), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "escape") && strings.TrimSpace(v) == "`" {
+				escape = '`'
+			}
+			continue
+		}
+		leading = false
+		if strings.HasPrefix(trimmed, "#") || trimmed == "" {
+			continue
+		}
+		if strings.HasSuffix(trimmed, string(escape)) {
+			cur.WriteString(strings.TrimSuffix(trimmed, string(escape)))
+			cur.WriteByte(' ')
+			continue
+		}
+		cur.WriteString(trimmed)
+		if s := strings.TrimSpace(cur.String()); s != "" {
+			insts = append(insts, s)
+		}
+		cur.Reset()
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		insts = append(insts, s)
+	}
+	return insts
+}
+
+// checkPolicy checks the Executor against its Policy, if any.
+func (e *Executor) checkPolicy() error {
+	if e.Policy == nil {
+		return nil
+	}
+	return e.Policy.Check(e)
+}
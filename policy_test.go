@@ -0,0 +1,65 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// strictExecutor returns an Executor that satisfies StrictPolicy.
+func strictExecutor() *Executor {
+	return &Executor{
+		Dockerfile:      "FROM gcc:13\nRUN gcc -o /bin/main main.c\n",
+		Timeout:         10 * time.Second,
+		Memory:          256 << 20,
+		Net:             NetNone,
+		Seccomp:         "{}",
+		NoNewPrivileges: true,
+	}
+}
+
+func TestStrictPolicy(t *testing.T) {
+	if err := StrictPolicy().Check(strictExecutor()); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+}
+
+func TestPolicyStepMemory(t *testing.T) {
+	p := StrictPolicy()
+	e := strictExecutor()
+	e.Steps = []Step{
+		{Cmd: "gcc -o main main.c", Memory: 512 << 20},
+		{Cmd: "./main", Memory: p.MaxMemory + 1},
+	}
+	err := p.Check(e)
+	var pe *PolicyError
+	if !errors.As(err, &pe) || pe.Rule != "MaxMemory" {
+		t.Fatalf("Check: got %v, want a violation of MaxMemory", err)
+	}
+	e.Steps[1].Memory = p.MaxMemory
+	if err := p.Check(e); err != nil {
+		t.Fatalf("Check with steps within MaxMemory: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ErrPoolClosed is returned when executing a command in a closed Pool.
+var ErrPoolClosed = errors.New("eggsy: pool is closed")
+
+// Pool is a reusable sandbox for executing commands. It builds its image
+// once, and keeps warm containers created from that image paused until
+// a command claims one. Each container runs a single command and is then
+// replaced, so commands never observe each other. A Pool is safe for
+// concurrent use.
+type Pool struct {
+	e    *Executor
+	tag  string
+	dir  string // working directory of the image
+	keep bool   // whether the image outlives the Pool
+
+	files   []byte // archive of the Executor's files, when run from its Image or injected
+	release func() // removes the restricted network, if any
+
+	warm chan string // IDs of paused containers
+
+	mu     sync.Mutex
+	closed bool
+	busy   map[string]bool // containers running a command
+	done   chan struct{}
+	wg     sync.WaitGroup // replacements being created
+	idle   *idleTimer
+}
+
+// NewPool builds the image described by e, or uses e's Image, and starts
+// size warm containers from it. The image is built as it would be by
+// Execute, and so may be reused from e's BuildCache. The containers are
+// configured by e as they would be by Execute, but e's Cmd, Stdin,
+// StdinPath, and output fields are ignored. The Pool takes ownership of
+// e, which must not be used again.
+func NewPool(ctx context.Context, e *Executor, size int) (*Pool, error) {
+	if err := e.checkPolicy(); err != nil {
+		return nil, err
+	}
+	ref, err := e.buildImage(ctx)
+	if e.stdin != nil {
+		e.stdin.Close()
+	}
+	if err != nil {
+		if e.cli != nil {
+			e.closeClient(e.cli)
+		}
+		return nil, err
+	}
+	p := &Pool{
+		e:     e,
+		tag:   ref.Tag,
+		keep:  ref.keep,
+		files: ref.files,
+		warm:  make(chan string, size),
+		busy:  make(map[string]bool),
+		done:  make(chan struct{}),
+	}
+	p.dir = e.workDir(ctx, p.tag)
+	if e.Net == NetRestricted {
+		if p.release, err = e.restrict(ctx); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+	for i := 0; i < size; i++ {
+		id, err := p.create(ctx)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.warm <- id
+	}
+	p.idle = newIdleTimer(e.IdleTimeout, func() { p.Close() })
+	return p, nil
+}
+
+// create starts a warm container and pauses it.
+func (p *Pool) create(ctx context.Context) (string, error) {
+	id := randN(16)
+	if err := p.e.startIdle(ctx, id, p.tag, p.dir, p.files); err != nil {
+		return "", err
+	}
+	if err := p.e.cli.ContainerPause(ctx, id); err != nil {
+		p.remove(id)
+		return "", err
+	}
+	return id, nil
+}
+
+func (p *Pool) remove(id string) {
+	p.e.removeContainer(id)
+}
+
+// recycle removes a used container, and replaces it with a warm one.
+// If the replacement can't be created, the pool shrinks.
+func (p *Pool) recycle(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		// Close has removed the container already
+		return
+	}
+	delete(p.busy, id)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.remove(id)
+		nid, err := p.create(context.Background())
+		if err != nil {
+			return
+		}
+		select {
+		case p.warm <- nid:
+		case <-p.done:
+			p.remove(nid)
+		}
+	}()
+}
+
+// Execute copies files into the working directory of a warm container,
+// and runs the shell command cmd in it, writing its standard output and
+// standard error to stdout and stderr. If every container is in use,
+// Execute waits for one to be replaced. The Timeout of the Pool's Executor
+// applies to cmd, and Execute returns a TimeoutError once it is reached.
+func (p *Pool) Execute(ctx context.Context, cmd string, files FileSet, stdout, stderr io.Writer) (*ExecResult, error) {
+	return p.ExecuteInput(ctx, cmd, files, nil, stdout, stderr)
+}
+
+// ExecuteInput is like Execute, but feeds stdin to cmd.
+func (p *Pool) ExecuteInput(ctx context.Context, cmd string, files FileSet, stdin io.Reader, stdout, stderr io.Writer) (*ExecResult, error) {
+	p.idle.begin()
+	defer p.idle.end()
+	var id string
+	select {
+	case id = <-p.warm:
+	case <-p.done:
+		return nil, ErrPoolClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		p.remove(id)
+		return nil, ErrPoolClosed
+	}
+	p.busy[id] = true
+	p.mu.Unlock()
+	defer p.recycle(id)
+	cli := p.e.cli
+	if err := cli.ContainerUnpause(ctx, id); err != nil {
+		return nil, err
+	}
+	if files != nil {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		if err := p.e.writeFiles(tw, files); err != nil {
+			return nil, err
+		}
+		if err := tw.Close(); err != nil {
+			return nil, err
+		}
+		if err := cli.CopyToContainer(ctx, id, p.dir, &buf, types.CopyToContainerOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	return p.e.exec(ctx, id, p.tag, cmd, p.e.Timeout, stdin, stdout, stderr)
+}
+
+// Keepalive keeps the Pool from being closed by its Executor's
+// IdleTimeout for another IdleTimeout, as if a command had just run.
+func (p *Pool) Keepalive() {
+	p.idle.touch()
+}
+
+// Close removes the Pool's containers, and its image unless it was the
+// Executor's Image or is held by its BuildCache. Commands that are
+// running are killed.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.idle.stop()
+	close(p.done)
+	for id := range p.busy {
+		p.remove(id)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	for {
+		select {
+		case id := <-p.warm:
+			p.remove(id)
+		default:
+			if p.release != nil {
+				p.release()
+			}
+			var err error
+			if !p.keep {
+				_, err = p.e.cli.ImageRemove(context.Background(), p.tag, types.ImageRemoveOptions{Force: true})
+			}
+			p.e.closeClient(p.e.cli)
+			return err
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package preset provides sandboxes for running programs in common
+// languages, with their images, commands, and limits chosen so that
+// running a program is a single call:
+//
+//	e := preset.Python3.Executor(source)
+//	res, err := e.Execute(ctx)
+//
+// or, to capture its output as well:
+//
+//	stdout, stderr, res, err := preset.RunPython(ctx, source, stdin)
+package preset
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/smasher164/eggsy"
+	"github.com/smasher164/eggsy/seccomp"
+)
+
+// Preset describes how to run programs of a language.
+type Preset struct {
+	// Name is the name of the language, such as "Python 3".
+	Name string
+
+	// Image is the base image of the sandbox, which has the language's
+	// compiler or interpreter installed.
+	Image string
+
+	// Source is the name of the file the program is written to.
+	Source string
+
+	// Compile, if set, is the shell command that compiles the program
+	// while the image is built, so that a program that fails to compile
+	// makes Execute return an eggsy.BuildError.
+	Compile string
+
+	// Run is the shell command that runs the program.
+	Run string
+
+	// Timeout, Memory, and PidsLimit are the limits recommended for the
+	// language, which are set on its Executors.
+	Timeout   time.Duration
+	Memory    int64
+	PidsLimit int64
+
+	// Seccomp is the seccomp profile recommended for the language. The
+	// presets' profiles deny the network, and allow the other system
+	// calls that the toolchains of their languages make.
+	Seccomp *seccomp.Profile
+}
+
+// Dockerfile returns the Dockerfile of the sandbox, which copies the
+// program into /src and compiles it.
+func (p *Preset) Dockerfile() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "FROM %s\nWORKDIR /src\nCOPY %s .\n", p.Image, p.Source)
+	if p.Compile != "" {
+		fmt.Fprintf(&b, "RUN %s\n", p.Compile)
+	}
+	return b.String()
+}
+
+// Executor returns an Executor that runs the program in source in a
+// sandbox hardened by eggsy.HardenedDefaults, with the limits of p. Each
+// option is applied to the Executor after it is configured, and may
+// change any of its fields, such as to set its Stdin or Stdout.
+func (p *Preset) Executor(source string, opts ...func(*eggsy.Executor)) *eggsy.Executor {
+	e := new(eggsy.Executor)
+	p.Option(source)(e)
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Option returns an option that configures an Executor as Executor does,
+// for the Executors made from Jobs, such as by eggsy.ExecuteAll.
+func (p *Preset) Option(source string) func(*eggsy.Executor) {
+	files := eggsy.NewFile(p.Source, []byte(source))
+	return func(e *eggsy.Executor) {
+		e.Dockerfile = p.Dockerfile()
+		e.Files = files
+		e.Cmd = p.Run
+		p.limit(e)
+	}
+}
+
+// limit sets the limits and hardening of p on e.
+func (p *Preset) limit(e *eggsy.Executor) {
+	e.Timeout = p.Timeout
+	e.Memory = p.Memory
+	e.PidsLimit = p.PidsLimit
+	eggsy.HardenedDefaults(e)
+	if p.Seccomp != nil {
+		e.Seccomp = p.Seccomp.String()
+	}
+}
+
+const mb = 1 << 20
+
+// The presets of the languages that are supported.
+var (
+	Go = &Preset{
+		Name:      "Go",
+		Image:     "golang:alpine",
+		Source:    "main.go",
+		Compile:   "go build -o /bin/main main.go",
+		Run:       "/bin/main",
+		Timeout:   10 * time.Second,
+		Memory:    256 * mb,
+		PidsLimit: 64,
+		Seccomp:   seccomp.NoNetworkSyscalls(),
+	}
+
+	Python3 = &Preset{
+		Name:      "Python 3",
+		Image:     "python:3-alpine",
+		Source:    "main.py",
+		Run:       "python3 main.py",
+		Timeout:   10 * time.Second,
+		Memory:    256 * mb,
+		PidsLimit: 64,
+		Seccomp:   seccomp.NoNetworkSyscalls(),
+	}
+
+	C = &Preset{
+		Name:      "C",
+		Image:     "gcc:13",
+		Source:    "main.c",
+		Compile:   "gcc -O2 -std=c17 -o /bin/main main.c -lm",
+		Run:       "/bin/main",
+		Timeout:   10 * time.Second,
+		Memory:    256 * mb,
+		PidsLimit: 64,
+		Seccomp:   seccomp.NoNetworkSyscalls(),
+	}
+
+	CPP = &Preset{
+		Name:      "C++",
+		Image:     "gcc:13",
+		Source:    "main.cpp",
+		Compile:   "g++ -O2 -std=c++20 -o /bin/main main.cpp",
+		Run:       "/bin/main",
+		Timeout:   10 * time.Second,
+		Memory:    256 * mb,
+		PidsLimit: 64,
+		Seccomp:   seccomp.NoNetworkSyscalls(),
+	}
+
+	Rust = &Preset{
+		Name:      "Rust",
+		Image:     "rust:alpine",
+		Source:    "main.rs",
+		Compile:   "rustc -O -o /bin/main main.rs",
+		Run:       "/bin/main",
+		Timeout:   10 * time.Second,
+		Memory:    256 * mb,
+		PidsLimit: 64,
+		Seccomp:   seccomp.NoNetworkSyscalls(),
+	}
+
+	Node = &Preset{
+		Name:      "Node.js",
+		Image:     "node:lts-alpine",
+		Source:    "main.js",
+		Run:       "node main.js",
+		Timeout:   10 * time.Second,
+		Memory:    512 * mb,
+		PidsLimit: 64,
+		Seccomp:   seccomp.NoNetworkSyscalls(),
+	}
+
+	// Shell runs POSIX shell scripts in a minimal Alpine Linux.
+	Shell = &Preset{
+		Name:      "Shell",
+		Image:     "alpine",
+		Source:    "main.sh",
+		Run:       "sh main.sh",
+		Timeout:   10 * time.Second,
+		Memory:    256 * mb,
+		PidsLimit: 64,
+		Seccomp:   seccomp.NoNetworkSyscalls(),
+	}
+)
+
+// All lists every preset.
+var All = []*Preset{Go, Python3, C, CPP, Rust, Node, Shell}
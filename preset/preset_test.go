@@ -0,0 +1,82 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package preset
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/smasher164/eggsy"
+	"github.com/smasher164/eggsy/seccomp"
+)
+
+// toolchainSyscalls are calls made by compilers and interpreters, which
+// the profile of every preset must allow.
+var toolchainSyscalls = []string{
+	"execve", "clone", "mmap", "mprotect", "openat", "read", "write",
+	"fchmod", "chmod", "setrlimit", "fstatfs", "capget", "sched_setaffinity",
+}
+
+func TestPresets(t *testing.T) {
+	for _, p := range All {
+		for _, e := range []*eggsy.Executor{p.Executor("source"), p.runner()} {
+			if err := eggsy.StrictPolicy().Check(e); err != nil {
+				t.Errorf("%s: %v", p.Name, err)
+			}
+			var prof seccomp.Profile
+			if err := json.Unmarshal([]byte(e.Seccomp), &prof); err != nil {
+				t.Errorf("%s: Seccomp is not a profile: %v", p.Name, err)
+				continue
+			}
+			for _, n := range toolchainSyscalls {
+				if action(&prof, n) != seccomp.ActAllow {
+					t.Errorf("%s: profile doesn't allow %s", p.Name, n)
+				}
+			}
+			if action(&prof, "connect") == seccomp.ActAllow {
+				t.Errorf("%s: profile allows connect", p.Name)
+			}
+		}
+	}
+}
+
+// action returns the action of prof on calls to name.
+func action(prof *seccomp.Profile, name string) seccomp.Action {
+	for _, s := range prof.Syscalls {
+		for _, n := range s.Names {
+			if n == name {
+				return s.Action
+			}
+		}
+	}
+	return prof.DefaultAction
+}
+
+func TestCommand(t *testing.T) {
+	if got, want := Go.runner().Cmd, "go build -o /bin/main main.go && /bin/main"; got != want {
+		t.Errorf("Go.runner().Cmd = %q, want %q", got, want)
+	}
+	if got, want := Python3.runner().Cmd, "python3 main.py"; got != want {
+		t.Errorf("Python3.runner().Cmd = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package preset
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/smasher164/eggsy"
+)
+
+// Cache holds the images of the presets run by Execute, which have only
+// their language installed, so that each is built once rather than for
+// every program.
+var Cache eggsy.BuildCache = new(eggsy.MemoryBuildCache)
+
+var (
+	mu    sync.Mutex
+	pools = make(map[*Preset]*eggsy.Pool) // warm containers of the presets
+)
+
+// Execute runs the program in source in the sandbox of p, feeding it stdin,
+// and returns its standard output, standard error, and result. Unlike
+// that of Executor, the image of the sandbox doesn't hold the program,
+// which is compiled as the command runs, so a program that fails to
+// compile exits with a non-zero code. If p has warm containers and no
+// options are given, the program runs in one of them. Otherwise, each
+// option is applied to the Executor before it runs, and may change any
+// of its fields.
+func (p *Preset) Execute(ctx context.Context, source string, stdin io.Reader, opts ...func(*eggsy.Executor)) (stdout, stderr []byte, res *eggsy.ExecResult, err error) {
+	var outb, errb bytes.Buffer
+	files := eggsy.NewFile(p.Source, []byte(source))
+	mu.Lock()
+	pool := pools[p]
+	mu.Unlock()
+	if pool != nil && len(opts) == 0 {
+		res, err = pool.ExecuteInput(ctx, p.command(), files, stdin, &outb, &errb)
+		return outb.Bytes(), errb.Bytes(), res, err
+	}
+	e := p.runner()
+	e.Files = files
+	e.Stdin, e.Stdout, e.Stderr = stdin, &outb, &errb
+	for _, opt := range opts {
+		opt(e)
+	}
+	res, err = e.Execute(ctx)
+	return outb.Bytes(), errb.Bytes(), res, err
+}
+
+// RunGo runs the Go program in source like Go.Execute.
+func RunGo(ctx context.Context, source string, stdin io.Reader, opts ...func(*eggsy.Executor)) (stdout, stderr []byte, res *eggsy.ExecResult, err error) {
+	return Go.Execute(ctx, source, stdin, opts...)
+}
+
+// RunPython runs the Python 3 program in source like Python3.Execute.
+func RunPython(ctx context.Context, source string, stdin io.Reader, opts ...func(*eggsy.Executor)) (stdout, stderr []byte, res *eggsy.ExecResult, err error) {
+	return Python3.Execute(ctx, source, stdin, opts...)
+}
+
+// RunShell runs the shell script script like Shell.Execute.
+func RunShell(ctx context.Context, script string, stdin io.Reader, opts ...func(*eggsy.Executor)) (stdout, stderr []byte, res *eggsy.ExecResult, err error) {
+	return Shell.Execute(ctx, script, stdin, opts...)
+}
+
+// WarmUp builds the images of the given presets into Cache, pulling
+// their base images as needed, so that the first program run after a
+// service starts doesn't wait on the build. If size is positive, it
+// also starts size warm containers for each preset, which Execute runs
+// programs in until Close. With no presets, it warms up every preset.
+func WarmUp(ctx context.Context, size int, presets ...*Preset) error {
+	if len(presets) == 0 {
+		presets = All
+	}
+	for _, p := range presets {
+		e := p.runner()
+		if size <= 0 {
+			if _, err := e.Build(ctx); err != nil {
+				return fmt.Errorf("preset: warming up %s: %w", p.Name, err)
+			}
+			continue
+		}
+		pool, err := eggsy.NewPool(ctx, e, size)
+		if err != nil {
+			return fmt.Errorf("preset: warming up %s: %w", p.Name, err)
+		}
+		mu.Lock()
+		old := pools[p]
+		pools[p] = pool
+		mu.Unlock()
+		if old != nil {
+			old.Close()
+		}
+	}
+	return nil
+}
+
+// Close removes the warm containers started by WarmUp.
+func Close() error {
+	mu.Lock()
+	ps := pools
+	pools = make(map[*Preset]*eggsy.Pool)
+	mu.Unlock()
+	var err error
+	for _, pool := range ps {
+		if cerr := pool.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// runner returns an Executor of the sandbox of p without a program.
+func (p *Preset) runner() *eggsy.Executor {
+	e := &eggsy.Executor{
+		Dockerfile:  fmt.Sprintf("FROM %s\nWORKDIR /src\n", p.Image),
+		InjectFiles: true,
+		BuildCache:  Cache,
+		Cmd:         p.command(),
+	}
+	p.limit(e)
+	return e
+}
+
+// command returns the shell command that compiles and runs the program.
+func (p *Preset) command() string {
+	if p.Compile == "" {
+		return p.Run
+	}
+	return p.Compile + " && " + p.Run
+}
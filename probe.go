@@ -0,0 +1,243 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ErrRuntimeUnavailable is returned when the daemon has no runtime
+// configured by the name an Executor requests.
+var ErrRuntimeUnavailable = errors.New("eggsy: runtime is not configured on the daemon")
+
+// Capabilities describes the daemon selected by an Executor, and the
+// isolation its containers will get.
+type Capabilities struct {
+	// OSType and Architecture are the daemon's operating system
+	// and architecture, such as "linux" and "x86_64".
+	OSType       string
+	Architecture string
+
+	// DockerDesktop reports whether the daemon is run by Docker Desktop
+	// on macOS or Windows, which doesn't provide gVisor.
+	DockerDesktop bool
+
+	// Runtimes lists the container runtimes configured on the daemon,
+	// and Runtime is the one the Executor's containers will use, which
+	// is empty if neither its Runtime nor FallbackRuntime is available.
+	Runtimes []Runtime
+	Runtime  Runtime
+
+	// Emulated lists the architectures, such as "arm64", that the host
+	// can run through qemu registered with binfmt_misc. It is only
+	// known for a daemon on the local host.
+	Emulated []string
+
+	// Sandboxed reports whether containers will run under gVisor.
+	Sandboxed bool
+
+	// Warnings describes every way in which containers will be
+	// less isolated than under gVisor.
+	Warnings []string
+
+	local bool // whether the daemon runs on this host
+}
+
+// Probe queries the daemon selected by the Executor for its capabilities.
+// Services should check the Warnings of the result at startup, since an
+// Executor with a FallbackRuntime runs its command unsandboxed on hosts
+// without gVisor.
+func (e *Executor) Probe(ctx context.Context) (*Capabilities, error) {
+	cli, err := e.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer e.closeClient(cli)
+	info, err := cli.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.capabilities(info, cli.DaemonHost()), nil
+}
+
+// DetectRuntimes returns the container runtimes configured on the
+// daemon selected by the environment.
+func DetectRuntimes(ctx context.Context) ([]Runtime, error) {
+	c, err := new(Executor).Probe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.Runtimes, nil
+}
+
+func (e *Executor) capabilities(info types.Info, host string) *Capabilities {
+	c := &Capabilities{
+		OSType:        info.OSType,
+		Architecture:  info.Architecture,
+		DockerDesktop: isDockerDesktop(info, host),
+		local:         strings.HasPrefix(host, "unix://"),
+	}
+	for name := range info.Runtimes {
+		c.Runtimes = append(c.Runtimes, Runtime(name))
+	}
+	sort.Slice(c.Runtimes, func(i, j int) bool { return c.Runtimes[i] < c.Runtimes[j] })
+	if c.local {
+		c.Emulated = binfmtArchs()
+	}
+	resolve := func(r Runtime) string {
+		switch r {
+		case RuntimeDefault:
+			return info.DefaultRuntime
+		case RuntimeMicroVM:
+			for _, vm := range microVMRuntimes {
+				if hasRuntime(info, string(vm)) {
+					return string(vm)
+				}
+			}
+			return string(RuntimeKata)
+		}
+		return r.name()
+	}
+	want := resolve(e.Runtime)
+	if hasRuntime(info, want) || want == info.DefaultRuntime {
+		c.Runtime = Runtime(want)
+	} else if e.FallbackRuntime != "" {
+		if fb := resolve(e.FallbackRuntime); fb != "" && (fb == info.DefaultRuntime || hasRuntime(info, fb)) {
+			c.Runtime = Runtime(fb)
+			c.Warnings = append(c.Warnings, fmt.Sprintf("the %s runtime is not configured on the daemon, so containers will fall back to the %s runtime", want, fb))
+		}
+	}
+	if c.Runtime == "" {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("the %s runtime is not configured on the daemon, so containers will fail to start", want))
+	}
+	if c.DockerDesktop && want == string(RuntimeRunsc) {
+		c.Warnings = append(c.Warnings, "Docker Desktop does not provide the gVisor runtime")
+	}
+	c.Sandboxed = c.Runtime == RuntimeRunsc
+	if c.Runtime != "" && !c.Sandboxed && !c.Runtime.vm() {
+		c.Warnings = append(c.Warnings, fmt.Sprintf("the %s runtime does not isolate containers from the host kernel", c.Runtime.name()))
+	}
+	return c
+}
+
+// microVMRuntimes are the runtimes RuntimeMicroVM chooses from,
+// in order of preference.
+var microVMRuntimes = []Runtime{RuntimeKataFC, "kata-qemu", RuntimeKata, "kata"}
+
+func hasRuntime(info types.Info, name string) bool {
+	_, ok := info.Runtimes[name]
+	return ok
+}
+
+// isDockerDesktop reports whether the daemon is run by Docker Desktop,
+// including the older Docker for Mac and Docker for Windows, or is
+// reached through a Windows named pipe.
+func isDockerDesktop(info types.Info, host string) bool {
+	switch {
+	case strings.HasPrefix(host, "npipe://"),
+		strings.HasPrefix(info.OperatingSystem, "Docker Desktop"),
+		strings.HasPrefix(info.OperatingSystem, "Docker for Mac"),
+		strings.HasPrefix(info.OperatingSystem, "Docker for Windows"):
+		return true
+	}
+	return false
+}
+
+// binfmtMisc is where the emulators registered with binfmt_misc are listed.
+const binfmtMisc = "/proc/sys/fs/binfmt_misc"
+
+// archs maps the architecture names used by the kernel and qemu to GOARCH.
+var archs = map[string]string{
+	"x86_64":  "amd64",
+	"i386":    "386",
+	"aarch64": "arm64",
+	"arm":     "arm",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+	"riscv64": "riscv64",
+}
+
+// binfmtArchs returns the architectures that have an enabled qemu
+// emulator registered with binfmt_misc.
+func binfmtArchs() []string {
+	fis, err := ioutil.ReadDir(binfmtMisc)
+	if err != nil {
+		return nil
+	}
+	var as []string
+	for _, fi := range fis {
+		name := strings.TrimPrefix(fi.Name(), "qemu-")
+		arch, ok := archs[name]
+		if !ok || name == fi.Name() {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(binfmtMisc, fi.Name()))
+		if err == nil && bytes.HasPrefix(b, []byte("enabled")) {
+			as = append(as, arch)
+		}
+	}
+	sort.Strings(as)
+	return as
+}
+
+// checkPlatform returns an error if the daemon can't run containers
+// for platform, such as "linux/arm64/v8", and reports whether they run
+// under emulation.
+func (c *Capabilities) checkPlatform(platform string) (emulated bool, err error) {
+	if platform == "" {
+		return false, nil
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 {
+		return false, fmt.Errorf("eggsy: invalid platform %q", platform)
+	}
+	os, arch := parts[0], parts[1]
+	if c.OSType != "" && os != c.OSType {
+		return false, fmt.Errorf("eggsy: platform %s can't run on a %s daemon", platform, c.OSType)
+	}
+	native, ok := archs[c.Architecture]
+	if !ok {
+		native = c.Architecture
+	}
+	if arch == native {
+		return false, nil
+	}
+	if !c.local {
+		return true, nil
+	}
+	for _, a := range c.Emulated {
+		if a == arch {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("eggsy: platform %s needs a qemu emulator for %s registered with binfmt_misc", platform, arch)
+}
@@ -0,0 +1,49 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import "testing"
+
+func TestCheckPlatform(t *testing.T) {
+	local := &Capabilities{OSType: "linux", Architecture: "x86_64", Emulated: []string{"arm64"}, local: true}
+	remote := &Capabilities{OSType: "linux", Architecture: "x86_64"}
+	for _, tt := range []struct {
+		c        *Capabilities
+		platform string
+		emulated bool
+		ok       bool
+	}{
+		{local, "", false, true},
+		{local, "linux/amd64", false, true},
+		{local, "linux/arm64/v8", true, true},
+		{local, "linux/riscv64", false, false},
+		{local, "windows/amd64", false, false},
+		{local, "linux", false, false},
+		{remote, "linux/riscv64", true, true},
+	} {
+		emulated, err := tt.c.checkPlatform(tt.platform)
+		if emulated != tt.emulated || (err == nil) != tt.ok {
+			t.Errorf("checkPlatform(%q) on local=%v = %v, %v; want %v, ok=%v", tt.platform, tt.c.local, emulated, err, tt.emulated, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// PullPolicy decides when the images an Executor runs or builds from are
+// pulled from their registry.
+type PullPolicy int
+
+const (
+	// PullIfNotPresent pulls images that the daemon doesn't have.
+	PullIfNotPresent PullPolicy = iota
+
+	// PullAlways pulls images every time they are used, so that a
+	// moving tag such as "latest" is always up to date.
+	PullAlways
+
+	// PullNever never pulls images, and fails if the daemon doesn't have
+	// them, so that an execution never waits on a registry.
+	PullNever
+)
+
+// ErrImageNotPresent is returned when the daemon doesn't have an image
+// that the Executor's PullPolicy forbids pulling.
+var ErrImageNotPresent = errors.New("eggsy: image is not present and PullPolicy is PullNever")
+
+// Prefetch pulls the given images into the Executor's daemon, so that the
+// first execution to use them doesn't wait on the pull, and a registry
+// that is unreachable fails a service as it starts rather than while it
+// runs. With no images, it pulls the Executor's Image, or the base images
+// of its Dockerfile. Images the daemon has are only pulled again if the
+// PullPolicy is PullAlways.
+func (e *Executor) Prefetch(ctx context.Context, images ...string) error {
+	if len(images) == 0 {
+		images = e.baseImages()
+	}
+	cli, err := e.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer e.closeClient(cli)
+	for _, ref := range images {
+		if err := e.ensureImage(ctx, cli, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureImage pulls the image ref if the PullPolicy calls for it.
+func (e *Executor) ensureImage(ctx context.Context, cli *client.Client, ref string) error {
+	if e.PullPolicy != PullAlways {
+		_, _, err := cli.ImageInspectWithRaw(ctx, ref)
+		switch {
+		case err == nil:
+			return nil
+		case !client.IsErrNotFound(err):
+			return err
+		case e.PullPolicy == PullNever:
+			return fmt.Errorf("%w: %s", ErrImageNotPresent, ref)
+		}
+	}
+	r, err := cli.ImagePull(ctx, ref, types.ImagePullOptions{Platform: e.Platform})
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// the pull is done once its progress has been read
+	return jsonmessage.DisplayJSONMessagesStream(r, ioutil.Discard, 0, false, nil)
+}
+
+// baseImages returns the Executor's Image, or the images that the stages
+// of its Dockerfile are built from, up to its Target.
+func (e *Executor) baseImages() []string {
+	if e.Image != "" {
+		return []string{e.Image}
+	}
+	var (
+		images []string
+		target bool // whether the Target stage has begun
+	)
+	stages := make(map[string]bool)
+	sc := bufio.NewScanner(strings.NewReader(e.Dockerfile))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 2 || !strings.EqualFold(f[0], "FROM") {
+			continue
+		}
+		if target {
+			break
+		}
+		f = f[1:]
+		for len(f) > 0 && strings.HasPrefix(f[0], "--") {
+			f = f[1:]
+		}
+		if len(f) == 0 {
+			continue
+		}
+		// a stage built from an earlier one has no image to pull
+		if image := f[0]; !stages[strings.ToLower(image)] && image != "scratch" && !strings.Contains(image, "$") {
+			images = append(images, image)
+		}
+		if len(f) == 3 && strings.EqualFold(f[1], "AS") {
+			stages[strings.ToLower(f[2])] = true
+			target = e.Target != "" && strings.EqualFold(f[2], e.Target)
+		}
+	}
+	return images
+}
@@ -0,0 +1,554 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull matches every QueueFullError under errors.Is.
+	ErrQueueFull = errors.New("eggsy: queue is full")
+
+	// ErrQueueClosed is returned when enqueuing a Job in a closed Queue.
+	ErrQueueClosed = errors.New("eggsy: queue is closed")
+
+	// ErrUnknownJob is returned for the result of a Job that the Queue
+	// doesn't have, or whose result has already been returned or has
+	// expired.
+	ErrUnknownJob = errors.New("eggsy: unknown job")
+)
+
+// QueueFullError is returned when enqueuing a Job in a Queue that holds
+// as many waiting Jobs as it can, which callers should treat as
+// backpressure, such as by responding with 429 Too Many Requests.
+type QueueFullError struct {
+	// Len is the number of Jobs waiting.
+	Len int
+
+	// RetryAfter estimates how long until a Job has left the Queue to
+	// make room for another, or is zero if no Job has executed yet.
+	RetryAfter time.Duration
+}
+
+func (q *QueueFullError) Error() string {
+	return fmt.Sprintf("eggsy: queue is full with %d jobs waiting", q.Len)
+}
+
+// Is reports whether target is ErrQueueFull, so that
+// errors.Is(err, ErrQueueFull) matches any QueueFullError.
+func (q *QueueFullError) Is(target error) bool { return target == ErrQueueFull }
+
+// QueueStats describes the load of a Queue.
+type QueueStats struct {
+	// Waiting is the number of Jobs waiting to execute, as returned by
+	// Len, and Running the number executing.
+	Waiting int
+	Running int
+
+	// MeanDuration is the mean time that recent Jobs took to execute,
+	// weighted toward the latest, or zero if none has executed yet.
+	MeanDuration time.Duration
+
+	// EstimatedWait estimates how long a Job of the lowest Priority
+	// enqueued now would wait to execute, from MeanDuration.
+	EstimatedWait time.Duration
+}
+
+// DefaultResultTTL is how long a Queue keeps the result of a Job for
+// Result, unless its ResultTTL is set.
+const DefaultResultTTL = 10 * time.Minute
+
+// JobID identifies a Job enqueued in a Queue.
+type JobID string
+
+// Queue executes Jobs with a Runner in order of their priority, with at
+// most a fixed number executing at once. A Queue is safe for concurrent
+// use.
+type Queue struct {
+	// ResultTTL is how long the result of a Job is kept for Result once
+	// it has executed, after which it is discarded. If zero, it is
+	// DefaultResultTTL. It must be set before any Job is enqueued.
+	ResultTTL time.Duration
+
+	// Aging, if positive, raises the Priority of a waiting Job by one
+	// for every Aging it has waited, so that a steady stream of Jobs
+	// of a higher Priority can't starve it: a Job executes before those
+	// that were enqueued more than Aging times the difference of their
+	// Priorities after it. It must be set before any Job is enqueued.
+	Aging time.Duration
+
+	// Quota, if non-nil, limits the Jobs executed for each Tenant. A Job
+	// whose Tenant is over a limit isn't refused, but set aside until
+	// the tenant may be within it again: for the RetryAfter of the
+	// QuotaError, or until another of the tenant's Jobs finishes. Jobs
+	// without a Tenant aren't limited. It must be set before any Job is
+	// enqueued.
+	Quota *Quota
+
+	exec    func(context.Context, Job) (*ExecResult, error) // the Runner's Execute
+	workers int
+	epoch   time.Time // when the Queue was created, for aging
+	max     int
+	onDone  func(JobID, *ExecResult, error)
+
+	ctx    context.Context // canceled once Close gives up waiting
+	cancel context.CancelFunc
+	wg     sync.WaitGroup // workers
+
+	mu       sync.Mutex
+	cond     *sync.Cond // signaled when a Job is enqueued or the Queue closes
+	waiting  jobHeap
+	delayed  delayHeap               // Jobs whose NotBefore hasn't come
+	blocked  map[string][]*queuedJob // Jobs over their tenant's concurrency limit
+	running  map[*queuedJob]context.CancelFunc
+	mean     time.Duration // moving average of the durations of Jobs
+	nblocked int
+	timer    *time.Timer // moves delayed Jobs to waiting once they are due
+	seq      uint64
+	closed   bool
+	done     map[JobID]*queuedJob // Jobs whose results haven't been returned
+	expiry   []*queuedJob         // executed Jobs of done, in the order they finished
+}
+
+type queuedJob struct {
+	id   JobID
+	job  Job
+	rank float64 // the Priority, aged from when the Job began waiting
+	seq  uint64  // orders Jobs of equal rank
+
+	preempted   bool // whether the Job was canceled to be executed again
+	preemptions int
+
+	finished chan struct{}
+	at       time.Time // when the Job finished
+	res      *ExecResult
+	err      error
+}
+
+// jobHeap orders Jobs by descending rank, and then by when they were
+// enqueued.
+type jobHeap []*queuedJob
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank > h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+func (h jobHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x interface{}) { *h = append(*h, x.(*queuedJob)) }
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	j := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return j
+}
+
+// delayHeap orders Jobs by their NotBefore.
+type delayHeap []*queuedJob
+
+func (h delayHeap) Len() int { return len(h) }
+func (h delayHeap) Less(i, j int) bool {
+	if !h[i].job.NotBefore.Equal(h[j].job.NotBefore) {
+		return h[i].job.NotBefore.Before(h[j].job.NotBefore)
+	}
+	return h[i].seq < h[j].seq
+}
+func (h delayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *delayHeap) Push(x interface{}) { *h = append(*h, x.(*queuedJob)) }
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	j := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return j
+}
+
+// NewQueue returns a Queue that executes Jobs with r, at most workers at
+// a time, and holds at most maxLen Jobs waiting to execute, or any number
+// if maxLen is zero. If onDone is non-nil, it is called with the result
+// of each Job once it has executed, from the goroutine that executed it,
+// and the result is not kept for Result.
+func NewQueue(r *Runner, workers, maxLen int, onDone func(JobID, *ExecResult, error)) *Queue {
+	return newQueue(r.Execute, workers, maxLen, onDone)
+}
+
+// newQueue returns a Queue that executes Jobs with exec.
+func newQueue(exec func(context.Context, Job) (*ExecResult, error), workers, maxLen int, onDone func(JobID, *ExecResult, error)) *Queue {
+	q := &Queue{
+		exec:    exec,
+		workers: workers,
+		epoch:   time.Now(),
+		max:     maxLen,
+		onDone:  onDone,
+		done:    make(map[JobID]*queuedJob),
+		blocked: make(map[string][]*queuedJob),
+		running: make(map[*queuedJob]context.CancelFunc),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue adds j to the Jobs waiting to execute, ahead of those with a
+// lower Priority, once its NotBefore has come. It returns a
+// QueueFullError if the Queue can't hold more waiting Jobs.
+func (q *Queue) Enqueue(j Job) (JobID, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return "", ErrQueueClosed
+	}
+	if q.max > 0 && q.len() >= q.max {
+		err := &QueueFullError{Len: q.len()}
+		if q.workers > 0 {
+			// a worker takes a waiting Job about every mean/workers
+			err.RetryAfter = q.mean / time.Duration(q.workers)
+		}
+		return "", err
+	}
+	qj := &queuedJob{
+		id:       JobID(randN(16)),
+		job:      j,
+		seq:      q.seq,
+		finished: make(chan struct{}),
+	}
+	q.seq++
+	q.expire()
+	if q.onDone == nil {
+		q.done[qj.id] = qj
+	}
+	if time.Until(j.NotBefore) > 0 {
+		heap.Push(&q.delayed, qj)
+		q.schedule()
+		return qj.id, nil
+	}
+	q.push(qj, time.Now())
+	return qj.id, nil
+}
+
+// push adds qj to the Jobs waiting to execute, as of now. q.mu must be
+// held.
+func (q *Queue) push(qj *queuedJob, now time.Time) {
+	// a Job's aged priority is its Priority plus the Agings it has waited,
+	// and the Agings since the epoch are common to every Job, so ranking
+	// Jobs by their Priority less the Agings from the epoch to when they
+	// began waiting orders them as their aged priorities would
+	qj.rank = float64(qj.job.Priority)
+	if q.Aging > 0 {
+		qj.rank -= float64(now.Sub(q.epoch)) / float64(q.Aging)
+	}
+	heap.Push(&q.waiting, qj)
+	q.cond.Signal()
+	q.preempt()
+}
+
+// preempt cancels the Preemptible Job of the lowest rank that is
+// executing, if every worker is busy and a Job of a higher rank is
+// waiting. A single Job is preempted at a time. q.mu must be held.
+func (q *Queue) preempt() {
+	if len(q.waiting) == 0 || len(q.running) < q.workers {
+		return
+	}
+	var victim *queuedJob
+	for qj := range q.running {
+		if qj.preempted {
+			return
+		}
+		if qj.job.Preemptible && qj.rank < q.waiting[0].rank && (victim == nil || qj.rank < victim.rank) {
+			victim = qj
+		}
+	}
+	if victim != nil {
+		victim.preempted = true
+		q.running[victim]()
+	}
+}
+
+// schedule arms the timer for the first of the delayed Jobs to be due.
+// q.mu must be held.
+func (q *Queue) schedule() {
+	if len(q.delayed) == 0 {
+		return
+	}
+	d := time.Until(q.delayed[0].job.NotBefore)
+	if q.timer == nil {
+		q.timer = time.AfterFunc(d, q.promote)
+	} else {
+		q.timer.Reset(d)
+	}
+}
+
+// promote moves the delayed Jobs that are due to those waiting.
+func (q *Queue) promote() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.delayed) > 0 && time.Until(q.delayed[0].job.NotBefore) <= 0 {
+		q.push(heap.Pop(&q.delayed).(*queuedJob), time.Now())
+	}
+	q.schedule()
+}
+
+// Len returns the number of Jobs waiting to execute, including those
+// whose NotBefore hasn't come, and those set aside by the Quota.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.len()
+}
+
+func (q *Queue) len() int {
+	return len(q.waiting) + len(q.delayed) + q.nblocked
+}
+
+// Stats returns the load of the Queue.
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := QueueStats{Waiting: q.len(), Running: len(q.running), MeanDuration: q.mean}
+	// the Jobs ahead of a new one execute workers at a time, once a
+	// worker is free
+	if ahead := s.Waiting + s.Running - q.workers + 1; ahead > 0 && q.workers > 0 {
+		s.EstimatedWait = q.mean * time.Duration(ahead) / time.Duration(q.workers)
+	}
+	return s
+}
+
+// Result waits for the Job with the given ID to execute, and returns its
+// result. A Job's result is returned once, and not at all if the Queue
+// has an onDone function or once it has expired after ResultTTL.
+func (q *Queue) Result(ctx context.Context, id JobID) (*ExecResult, error) {
+	q.mu.Lock()
+	qj, ok := q.done[id]
+	q.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownJob
+	}
+	select {
+	case <-qj.finished:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	q.mu.Lock()
+	_, ok = q.done[id]
+	delete(q.done, id)
+	q.mu.Unlock()
+	if !ok {
+		// another call returned it first
+		return nil, ErrUnknownJob
+	}
+	return qj.res, qj.err
+}
+
+// Close stops the Queue from accepting Jobs, and waits for the Jobs it
+// holds to execute, but for those whose NotBefore hasn't come, which
+// finish with ErrQueueClosed. If ctx is done first, the Jobs still
+// executing are canceled, those still waiting finish with ErrQueueClosed,
+// and Close returns ctx's error once the workers have stopped.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	delayed := q.delayed
+	q.delayed = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	for _, qj := range delayed {
+		qj.err = ErrQueueClosed
+		q.finish(qj)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+	q.cancel()
+	<-stopped
+	return ctx.Err()
+}
+
+// work executes waiting Jobs until the Queue is closed and empty.
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.waiting) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.waiting) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		qj := heap.Pop(&q.waiting).(*queuedJob)
+		release, err := q.admit(qj)
+		ctx, cancel := context.WithCancel(q.ctx)
+		if err == nil {
+			q.running[qj] = cancel
+		}
+		q.mu.Unlock()
+		switch {
+		case err == errSetAside:
+			cancel()
+			continue
+		case err != nil:
+			qj.err = err
+		case q.ctx.Err() != nil:
+			qj.err = ErrQueueClosed
+		default:
+			start := time.Now()
+			qj.res, qj.err = q.exec(ctx, qj.job)
+			q.observe(time.Since(start))
+		}
+		cancel()
+		if release != nil {
+			release(qj.res)
+			q.unblock(qj.job.Tenant)
+		}
+		if q.requeue(qj) {
+			continue
+		}
+		q.finish(qj)
+	}
+}
+
+// observe adds the duration d of a Job to the moving average.
+func (q *Queue) observe(d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.mean == 0 {
+		q.mean = d
+		return
+	}
+	q.mean += (d - q.mean) / 5
+}
+
+// requeue returns qj to the Jobs waiting to execute, with the rank it
+// had, if it was preempted before it could finish, and reports whether
+// it was.
+func (q *Queue) requeue(qj *queuedJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.running, qj)
+	if !qj.preempted || qj.err == nil || q.ctx.Err() != nil {
+		return false
+	}
+	qj.preempted = false
+	qj.preemptions++
+	qj.res, qj.err = nil, nil
+	heap.Push(&q.waiting, qj)
+	q.cond.Signal()
+	return true
+}
+
+// errSetAside is returned by admit for a Job it has set aside.
+var errSetAside = errors.New("eggsy: job set aside")
+
+// admit counts qj against the Quota of its Tenant, and returns the
+// function that releases it. A Job over its tenant's limits is set
+// aside, and admit returns errSetAside, or its QuotaError if the Queue
+// is closed. q.mu must be held.
+func (q *Queue) admit(qj *queuedJob) (release func(*ExecResult), err error) {
+	if q.Quota == nil || qj.job.Tenant == "" {
+		return nil, nil
+	}
+	release, err = q.Quota.Acquire(qj.job.Tenant)
+	var qe *QuotaError
+	if !errors.As(err, &qe) {
+		return release, err
+	}
+	switch {
+	case q.closed:
+		return nil, err
+	case qe.RetryAfter > 0:
+		qj.job.NotBefore = time.Now().Add(qe.RetryAfter)
+		heap.Push(&q.delayed, qj)
+		q.schedule()
+	default:
+		q.blocked[qj.job.Tenant] = append(q.blocked[qj.job.Tenant], qj)
+		q.nblocked++
+	}
+	return nil, errSetAside
+}
+
+// unblock returns the Jobs of tenant that were set aside until another
+// of its Jobs finished to those waiting, with the rank they had.
+func (q *Queue) unblock(tenant string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, qj := range q.blocked[tenant] {
+		heap.Push(&q.waiting, qj)
+		q.cond.Signal()
+	}
+	q.nblocked -= len(q.blocked[tenant])
+	delete(q.blocked, tenant)
+}
+
+// finish reports the result of qj, which has executed or never will.
+func (q *Queue) finish(qj *queuedJob) {
+	if qj.res != nil {
+		qj.res.Preemptions = qj.preemptions
+	}
+	close(qj.finished)
+	if q.onDone != nil {
+		q.onDone(qj.id, qj.res, qj.err)
+		return
+	}
+	q.mu.Lock()
+	qj.at = time.Now()
+	q.expiry = append(q.expiry, qj)
+	q.expire()
+	q.mu.Unlock()
+}
+
+// expire discards the results that have been kept for longer than the
+// ResultTTL. q.mu must be held.
+func (q *Queue) expire() {
+	ttl := q.ResultTTL
+	if ttl == 0 {
+		ttl = DefaultResultTTL
+	}
+	i := 0
+	for ; i < len(q.expiry) && time.Since(q.expiry[i].at) > ttl; i++ {
+		delete(q.done, q.expiry[i].id)
+		q.expiry[i] = nil
+	}
+	q.expiry = q.expiry[i:]
+}
@@ -0,0 +1,291 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder records the order in which a Queue executes Jobs, by their
+// Cmd, and when.
+type recorder struct {
+	mu    sync.Mutex
+	cmds  []string
+	times []time.Time
+}
+
+func (r *recorder) exec(ctx context.Context, j Job) (*ExecResult, error) {
+	r.mu.Lock()
+	r.cmds = append(r.cmds, j.Cmd)
+	r.times = append(r.times, time.Now())
+	r.mu.Unlock()
+	return &ExecResult{Status: StatusOK}, nil
+}
+
+func TestQueueResultExpiry(t *testing.T) {
+	q := &Queue{ResultTTL: time.Minute, done: make(map[JobID]*queuedJob)}
+	now := time.Now()
+	for _, qj := range []*queuedJob{
+		{id: "old", at: now.Add(-2 * time.Minute)},
+		{id: "returned", at: now.Add(-90 * time.Second)},
+		{id: "recent", at: now.Add(-time.Second)},
+	} {
+		qj.finished = make(chan struct{})
+		close(qj.finished)
+		q.done[qj.id] = qj
+		q.expiry = append(q.expiry, qj)
+	}
+	if _, err := q.Result(context.Background(), "returned"); err != nil {
+		t.Fatalf("Result(returned) = %v", err)
+	}
+	q.expire()
+	if len(q.expiry) != 1 || len(q.done) != 1 {
+		t.Errorf("after expire, %d results kept and %d expiring, want 1 and 1", len(q.done), len(q.expiry))
+	}
+	if _, err := q.Result(context.Background(), "old"); err != ErrUnknownJob {
+		t.Errorf("Result(old) = %v, want ErrUnknownJob", err)
+	}
+	if _, err := q.Result(context.Background(), "recent"); err != nil {
+		t.Errorf("Result(recent) = %v, want nil", err)
+	}
+}
+
+func TestQueueNotBefore(t *testing.T) {
+	var r recorder
+	q := newQueue(r.exec, 1, 0, nil)
+	start := time.Now()
+	later, err := q.Enqueue(Job{Cmd: "later", NotBefore: start.Add(100 * time.Millisecond), Priority: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, _ := q.Enqueue(Job{Cmd: "now"})
+	if n := q.Len(); n < 1 || n > 2 {
+		t.Errorf("Len = %d, want the delayed Job counted", n)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, id := range []JobID{now, later} {
+		if _, err := q.Result(ctx, id); err != nil {
+			t.Fatalf("Result(%s) = %v", id, err)
+		}
+	}
+	if len(r.cmds) != 2 || r.cmds[0] != "now" || r.cmds[1] != "later" {
+		t.Errorf("executed %q, want [now later]", r.cmds)
+	}
+	if d := r.times[1].Sub(start); d < 100*time.Millisecond {
+		t.Errorf("delayed Job executed after %v, before its NotBefore", d)
+	}
+
+	// a Job that isn't due when the Queue closes never executes
+	id, _ := q.Enqueue(Job{Cmd: "never", NotBefore: time.Now().Add(time.Hour)})
+	if err := q.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Result(ctx, id); err != ErrQueueClosed {
+		t.Errorf("Result of a Job delayed past Close = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestQueueAging(t *testing.T) {
+	for _, tt := range []struct {
+		aging time.Duration
+		want  []string
+	}{
+		// without aging, the stream of high Priority Jobs goes first
+		{0, []string{"high 3s", "high 5s", "high 8s", "low"}},
+		// the low Priority Job has aged by more than 2 by the time the third
+		// high Priority Job is enqueued, and so goes before it
+		{3 * time.Second, []string{"high 3s", "high 5s", "low", "high 8s"}},
+	} {
+		q := &Queue{Aging: tt.aging, cond: sync.NewCond(new(sync.Mutex))}
+		q.epoch = time.Now()
+		for _, j := range []struct {
+			cmd      string
+			priority int
+			at       time.Duration
+		}{
+			{"low", 0, 0},
+			{"high 3s", 2, 3 * time.Second},
+			{"high 5s", 2, 5 * time.Second},
+			{"high 8s", 2, 8 * time.Second},
+		} {
+			q.push(&queuedJob{job: Job{Cmd: j.cmd, Priority: j.priority}, seq: q.seq}, q.epoch.Add(j.at))
+			q.seq++
+		}
+		var got []string
+		for q.waiting.Len() > 0 {
+			got = append(got, heap.Pop(&q.waiting).(*queuedJob).job.Cmd)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("with Aging %v, executed %q, want %q", tt.aging, got, tt.want)
+		}
+	}
+}
+
+func TestQueueQuota(t *testing.T) {
+	started := make(chan string, 3)
+	release := map[string]chan struct{}{"a1": make(chan struct{}), "a2": make(chan struct{}), "b1": make(chan struct{})}
+	q := newQueue(func(ctx context.Context, j Job) (*ExecResult, error) {
+		started <- j.Cmd
+		<-release[j.Cmd]
+		return &ExecResult{Status: StatusOK}, nil
+	}, 2, 0, nil)
+	q.Quota = NewQuota(func(string) QuotaLimits { return QuotaLimits{MaxConcurrent: 1} })
+	for _, j := range []Job{{Cmd: "a1", Tenant: "a"}, {Cmd: "a2", Tenant: "a"}, {Cmd: "b1", Tenant: "b"}} {
+		if _, err := q.Enqueue(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait := func() string {
+		select {
+		case cmd := <-started:
+			return cmd
+		case <-time.After(5 * time.Second):
+			t.Fatal("no Job started")
+			return ""
+		}
+	}
+	// the second Job of tenant a is set aside, so b's executes
+	got := []string{wait(), wait()}
+	sort.Strings(got)
+	if got[0] != "a1" || got[1] != "b1" {
+		t.Fatalf("started %q, want a1 and b1", got)
+	}
+	if n := q.Len(); n != 1 {
+		t.Errorf("Len = %d, want the Job set aside counted", n)
+	}
+	select {
+	case cmd := <-started:
+		t.Fatalf("%s started while a1 was executing", cmd)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release["a1"])
+	if cmd := wait(); cmd != "a2" {
+		t.Errorf("started %s once a1 finished, want a2", cmd)
+	}
+	close(release["a2"])
+	close(release["b1"])
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueuePreempt(t *testing.T) {
+	started := make(chan string, 4)
+	release := make(chan struct{})
+	q := newQueue(func(ctx context.Context, j Job) (*ExecResult, error) {
+		started <- j.Cmd
+		select {
+		case <-release:
+			return &ExecResult{Status: StatusOK}, nil
+		case <-ctx.Done():
+			return nil, &ContextError{Cmd: j.Cmd, Err: ctx.Err()}
+		}
+	}, 1, 0, nil)
+	wait := func() string {
+		select {
+		case cmd := <-started:
+			return cmd
+		case <-time.After(5 * time.Second):
+			t.Fatal("no Job started")
+			return ""
+		}
+	}
+	low, _ := q.Enqueue(Job{Cmd: "low", Preemptible: true})
+	if cmd := wait(); cmd != "low" {
+		t.Fatalf("started %s, want low", cmd)
+	}
+	high, _ := q.Enqueue(Job{Cmd: "high", Priority: 1})
+	// the low Priority Job is canceled for the high one, and executed
+	// again once it has finished
+	if cmd := wait(); cmd != "high" {
+		t.Fatalf("started %s while low was executing, want high", cmd)
+	}
+	release <- struct{}{}
+	if cmd := wait(); cmd != "low" {
+		t.Fatalf("started %s after high, want low", cmd)
+	}
+	release <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if res, err := q.Result(ctx, high); err != nil || res.Preemptions != 0 {
+		t.Errorf("Result(high) = %+v, %v; want no preemptions", res, err)
+	}
+	if res, err := q.Result(ctx, low); err != nil || res.Preemptions != 1 {
+		t.Errorf("Result(low) = %+v, %v; want 1 preemption", res, err)
+	}
+
+	// a Job that isn't Preemptible runs to completion
+	q.Enqueue(Job{Cmd: "batch"})
+	wait()
+	q.Enqueue(Job{Cmd: "urgent", Priority: 1})
+	select {
+	case cmd := <-started:
+		t.Fatalf("%s started while a Job that isn't Preemptible was executing", cmd)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := q.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	q := newQueue(func(ctx context.Context, j Job) (*ExecResult, error) {
+		started <- struct{}{}
+		<-release
+		return &ExecResult{}, nil
+	}, 1, 1, nil)
+	q.mu.Lock()
+	q.mean = 2 * time.Second
+	q.mu.Unlock()
+	q.Enqueue(Job{Cmd: "running"})
+	<-started
+	q.Enqueue(Job{Cmd: "waiting"})
+	_, err := q.Enqueue(Job{Cmd: "refused"})
+	var qfe *QueueFullError
+	if !errors.As(err, &qfe) || !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Enqueue into a full Queue = %v, want a QueueFullError", err)
+	}
+	if qfe.Len != 1 || qfe.RetryAfter != 2*time.Second {
+		t.Errorf("QueueFullError = %+v, want Len 1 and RetryAfter 2s", qfe)
+	}
+	// a new Job would wait for the running one and the waiting one
+	want := QueueStats{Waiting: 1, Running: 1, MeanDuration: 2 * time.Second, EstimatedWait: 4 * time.Second}
+	if s := q.Stats(); s != want {
+		t.Errorf("Stats = %+v, want %+v", s, want)
+	}
+	close(release)
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,233 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrQuotaExceeded matches every QuotaError under errors.Is.
+var ErrQuotaExceeded = errors.New("eggsy: quota exceeded")
+
+// QuotaLimit names a limit of a Quota.
+type QuotaLimit string
+
+// The limits of a Quota.
+const (
+	LimitConcurrent QuotaLimit = "concurrent"
+	LimitPerMinute  QuotaLimit = "per-minute"
+	LimitCPUPerHour QuotaLimit = "cpu-per-hour"
+)
+
+// QuotaError is returned when a tenant's execution would exceed one of
+// the limits of its Quota.
+type QuotaError struct {
+	Tenant string
+	Limit  QuotaLimit
+
+	// RetryAfter is how long until the tenant is within the limit again,
+	// or zero if that isn't known, as when the limit is on concurrent
+	// executions.
+	RetryAfter time.Duration
+}
+
+func (q *QuotaError) Error() string {
+	return fmt.Sprintf("eggsy: tenant %q exceeded its %s quota", q.Tenant, q.Limit)
+}
+
+// Is reports whether target is ErrQuotaExceeded, so that
+// errors.Is(err, ErrQuotaExceeded) matches any QuotaError.
+func (q *QuotaError) Is(target error) bool { return target == ErrQuotaExceeded }
+
+// QuotaLimits are the limits of a tenant's executions. A zero field
+// means that there is no such limit.
+type QuotaLimits struct {
+	// MaxConcurrent limits the executions running at once.
+	MaxConcurrent int
+
+	// MaxPerMinute limits the executions started in the last minute.
+	MaxPerMinute int
+
+	// MaxCPUPerHour limits the CPU time used by the executions that
+	// finished in the last hour. An execution may start as long as
+	// the tenant is below it, and is then not cut short by it.
+	MaxCPUPerHour time.Duration
+}
+
+// QuotaUsage describes what a tenant has used of its limits.
+type QuotaUsage struct {
+	Running     int
+	LastMinute  int
+	CPULastHour time.Duration
+}
+
+// Quota limits the executions of each tenant, as identified by a string
+// chosen by the caller, such as the ID of an account. A Quota is safe for
+// concurrent use.
+type Quota struct {
+	limits func(tenant string) QuotaLimits
+	now    func() time.Time // the clock, replaced by tests
+
+	mu      sync.Mutex
+	tenants map[string]*tenantUsage
+}
+
+type tenantUsage struct {
+	running int
+	starts  []time.Time // of the executions in the last minute
+	cpu     []cpuUse    // of the executions that finished in the last hour
+	cpuSum  time.Duration
+}
+
+type cpuUse struct {
+	at time.Time
+	d  time.Duration
+}
+
+// prune forgets the executions that are too old to count.
+func (t *tenantUsage) prune(now time.Time) {
+	i := 0
+	for i < len(t.starts) && now.Sub(t.starts[i]) >= time.Minute {
+		i++
+	}
+	t.starts = t.starts[i:]
+	i = 0
+	for i < len(t.cpu) && now.Sub(t.cpu[i].at) >= time.Hour {
+		t.cpuSum -= t.cpu[i].d
+		i++
+	}
+	t.cpu = t.cpu[i:]
+}
+
+// NewQuota returns a Quota that gives each tenant the limits returned
+// by limits, which is called before each of the tenant's executions.
+func NewQuota(limits func(tenant string) QuotaLimits) *Quota {
+	return &Quota{limits: limits, now: time.Now, tenants: make(map[string]*tenantUsage)}
+}
+
+// Acquire counts an execution of tenant against its limits, and returns
+// a function that must be called with its result once it has finished,
+// which may be nil. If the execution would exceed a limit, Acquire
+// returns a QuotaError instead.
+//
+// The CPU time of an execution is taken from the Usage of its result,
+// or is its wall-clock time if the backend doesn't report its usage.
+func (q *Quota) Acquire(tenant string) (func(*ExecResult), error) {
+	l := q.limits(tenant)
+	now := q.now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := q.tenant(tenant, now)
+	if l.MaxConcurrent > 0 && t.running >= l.MaxConcurrent {
+		return nil, &QuotaError{Tenant: tenant, Limit: LimitConcurrent}
+	}
+	if l.MaxPerMinute > 0 && len(t.starts) >= l.MaxPerMinute {
+		// wait for enough starts to be a minute old
+		at := t.starts[len(t.starts)-l.MaxPerMinute]
+		return nil, &QuotaError{Tenant: tenant, Limit: LimitPerMinute, RetryAfter: at.Add(time.Minute).Sub(now)}
+	}
+	if l.MaxCPUPerHour > 0 && t.cpuSum >= l.MaxCPUPerHour {
+		// wait for enough CPU time to be an hour old
+		sum := t.cpuSum
+		var at time.Time
+		for _, u := range t.cpu {
+			sum -= u.d
+			at = u.at
+			if sum < l.MaxCPUPerHour {
+				break
+			}
+		}
+		return nil, &QuotaError{Tenant: tenant, Limit: LimitCPUPerHour, RetryAfter: at.Add(time.Hour).Sub(now)}
+	}
+	t.running++
+	t.starts = append(t.starts, now)
+	var once sync.Once
+	return func(res *ExecResult) {
+		once.Do(func() { q.release(tenant, res) })
+	}, nil
+}
+
+// tenant returns the usage of tenant, pruned at now.
+func (q *Quota) tenant(tenant string, now time.Time) *tenantUsage {
+	t, ok := q.tenants[tenant]
+	if !ok {
+		t = new(tenantUsage)
+		q.tenants[tenant] = t
+	}
+	t.prune(now)
+	return t
+}
+
+func (q *Quota) release(tenant string, res *ExecResult) {
+	var d time.Duration
+	if res != nil {
+		if res.Usage != nil {
+			d = res.Usage.CPU()
+		} else {
+			d = res.Duration()
+		}
+	}
+	now := q.now()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := q.tenant(tenant, now)
+	t.running--
+	if d > 0 {
+		t.cpu = append(t.cpu, cpuUse{at: now, d: d})
+		t.cpuSum += d
+	}
+	q.forget(tenant, t)
+}
+
+// forget removes the usage of tenant once there is nothing left of it,
+// so that the Quota doesn't grow with every tenant it has seen.
+func (q *Quota) forget(tenant string, t *tenantUsage) {
+	if t.running == 0 && len(t.starts) == 0 && len(t.cpu) == 0 {
+		delete(q.tenants, tenant)
+	}
+}
+
+// Usage returns what tenant has used of its limits.
+func (q *Quota) Usage(tenant string) QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t := q.tenant(tenant, q.now())
+	defer q.forget(tenant, t)
+	return QuotaUsage{Running: t.running, LastMinute: len(t.starts), CPULastHour: t.cpuSum}
+}
+
+// Execute executes e as an execution of tenant, unless that would exceed
+// one of its limits, in which case it returns a QuotaError.
+func (q *Quota) Execute(ctx context.Context, tenant string, e *Executor) (*ExecResult, error) {
+	release, err := q.Acquire(tenant)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.Execute(ctx)
+	release(res)
+	return res, err
+}
@@ -0,0 +1,165 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that moves only when told to.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestQuota(l QuotaLimits) (*Quota, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	q := NewQuota(func(string) QuotaLimits { return l })
+	q.now = clock.now
+	return q, clock
+}
+
+func quotaError(t *testing.T, err error, limit QuotaLimit, retryAfter time.Duration) {
+	t.Helper()
+	var qe *QuotaError
+	if !errors.As(err, &qe) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("err = %v, want a QuotaError", err)
+	}
+	if qe.Limit != limit || qe.RetryAfter != retryAfter {
+		t.Errorf("QuotaError of limit %s retrying after %v, want %s after %v", qe.Limit, qe.RetryAfter, limit, retryAfter)
+	}
+}
+
+func TestQuotaConcurrent(t *testing.T) {
+	q, _ := newTestQuota(QuotaLimits{MaxConcurrent: 2})
+	r1, err := q.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Acquire("a"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = q.Acquire("a")
+	quotaError(t, err, LimitConcurrent, 0)
+	if _, err := q.Acquire("b"); err != nil {
+		t.Errorf("other tenant: %v", err)
+	}
+	r1(nil)
+	r1(nil) // released once
+	if u := q.Usage("a"); u.Running != 1 {
+		t.Errorf("Running = %d after a release, want 1", u.Running)
+	}
+	if _, err := q.Acquire("a"); err != nil {
+		t.Errorf("after a release: %v", err)
+	}
+}
+
+func TestQuotaPerMinute(t *testing.T) {
+	q, clock := newTestQuota(QuotaLimits{MaxPerMinute: 2})
+	for i := 0; i < 2; i++ {
+		release, err := q.Acquire("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release(nil)
+		clock.advance(10 * time.Second)
+	}
+	// the first start is a minute old 40s from now
+	_, err := q.Acquire("a")
+	quotaError(t, err, LimitPerMinute, 40*time.Second)
+	if u := q.Usage("a"); u.LastMinute != 2 {
+		t.Errorf("LastMinute = %d, want 2", u.LastMinute)
+	}
+
+	clock.advance(40*time.Second - time.Nanosecond)
+	_, err = q.Acquire("a")
+	quotaError(t, err, LimitPerMinute, time.Nanosecond)
+	clock.advance(time.Nanosecond)
+	if _, err := q.Acquire("a"); err != nil {
+		t.Errorf("a minute after the first start: %v", err)
+	}
+}
+
+func TestQuotaCPUPerHour(t *testing.T) {
+	q, clock := newTestQuota(QuotaLimits{MaxCPUPerHour: 10 * time.Second})
+	run := func(cpu time.Duration) {
+		t.Helper()
+		release, err := q.Acquire("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release(&ExecResult{Usage: &Usage{CPUUser: cpu}})
+	}
+	run(4 * time.Second)
+	clock.advance(10 * time.Minute)
+	run(4 * time.Second)
+	clock.advance(10 * time.Minute)
+	// below the limit, so it may start, and isn't cut short
+	run(4 * time.Second)
+	if u := q.Usage("a"); u.CPULastHour != 12*time.Second {
+		t.Errorf("CPULastHour = %v, want 12s", u.CPULastHour)
+	}
+	// the first 4s must be an hour old to be below 10s, 40 minutes on
+	_, err := q.Acquire("a")
+	quotaError(t, err, LimitCPUPerHour, 40*time.Minute)
+
+	clock.advance(40 * time.Minute)
+	if u := q.Usage("a"); u.CPULastHour != 8*time.Second {
+		t.Errorf("CPULastHour = %v an hour on, want 8s", u.CPULastHour)
+	}
+	if _, err := q.Acquire("a"); err != nil {
+		t.Errorf("an hour after the first execution: %v", err)
+	}
+}
+
+func TestQuotaCPUWallClock(t *testing.T) {
+	q, clock := newTestQuota(QuotaLimits{MaxCPUPerHour: time.Second})
+	release, err := q.Acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := clock.now()
+	clock.advance(3 * time.Second)
+	// without a Usage, the wall-clock time counts
+	release(&ExecResult{Started: start, Finished: clock.now()})
+	if u := q.Usage("a"); u.CPULastHour != 3*time.Second {
+		t.Errorf("CPULastHour = %v, want 3s", u.CPULastHour)
+	}
+	_, err = q.Acquire("a")
+	quotaError(t, err, LimitCPUPerHour, time.Hour)
+}
+
+func TestQuotaForget(t *testing.T) {
+	q, clock := newTestQuota(QuotaLimits{MaxPerMinute: 1})
+	release, _ := q.Acquire("a")
+	release(nil)
+	clock.advance(time.Minute)
+	if u := q.Usage("a"); u != (QuotaUsage{}) {
+		t.Errorf("Usage = %+v a minute on, want none", u)
+	}
+	if len(q.tenants) != 0 {
+		t.Errorf("Quota holds %d tenants with nothing left, want 0", len(q.tenants))
+	}
+}
@@ -0,0 +1,213 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// The proxy that restricted containers reach the AllowHosts through.
+const (
+	proxyImage      = "eggsy-proxy:1"
+	proxyDockerfile = "FROM alpine:3.18\nRUN apk add --no-cache tinyproxy\n"
+	proxyAddr       = "http://proxy:8888"
+
+	// The subnets of the restricted network are appended as Allow
+	// directives, so that the proxy serves only its containers.
+	proxyConfig = `Port 8888
+Listen 0.0.0.0
+Timeout 600
+LogLevel Warning
+FilterDefaultDeny Yes
+FilterType ere
+FilterURLs No
+Filter "/etc/tinyproxy/filter"
+ConnectPort 443
+ConnectPort 80
+`
+)
+
+// hostFilter returns the tinyproxy filter that allows the given hosts.
+func hostFilter(hosts []string) (string, error) {
+	var b strings.Builder
+	for _, h := range hosts {
+		var re string
+		switch {
+		case strings.HasPrefix(h, "*."):
+			re = `^.+\.` + regexp.QuoteMeta(h[2:]) + `$`
+		case strings.Contains(h, "/"):
+			ip, ipn, err := net.ParseCIDR(h)
+			if err != nil {
+				return "", err
+			}
+			ones, _ := ipn.Mask.Size()
+			if ip.To4() == nil || ones%8 != 0 {
+				return "", fmt.Errorf("eggsy: unsupported allowed network %q", h)
+			}
+			octets := strings.Split(ipn.IP.To4().String(), ".")
+			for i := ones / 8; i < 4; i++ {
+				octets[i] = "[0-9]+"
+			}
+			re = "^" + strings.Join(octets, `\.`) + "$"
+		default:
+			re = "^" + regexp.QuoteMeta(h) + "$"
+		}
+		fmt.Fprintln(&b, re)
+	}
+	return b.String(), nil
+}
+
+// restrict creates an internal network for the Executor's containers,
+// along with a proxy on it that only forwards requests to the
+// AllowHosts. The proxy reaches the hosts through a network of its own,
+// and serves only the containers of the internal network. The networks
+// and proxy are removed by release.
+func (e *Executor) restrict(ctx context.Context) (release func(), err error) {
+	filter, err := hostFilter(e.AllowHosts)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.buildProxy(ctx); err != nil {
+		return nil, err
+	}
+	name := "eggsy-" + randN(8)
+	nw, err := e.cli.NetworkCreate(ctx, name, types.NetworkCreate{
+		CheckDuplicate: true,
+		Internal:       true,
+		Labels:         e.labels(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	pID := name + "-proxy"
+	var egress string
+	release = func() {
+		e.removeContainer(pID)
+		// ctx may already be done by the time they are removed
+		for _, id := range []string{nw.ID, egress} {
+			if id == "" {
+				continue
+			}
+			if err := e.cli.NetworkRemove(context.Background(), id); err != nil {
+				e.logger().Warn("eggsy: removing network failed", "network", id, "err", err)
+			}
+		}
+		e.network, e.env = "", nil
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+	ni, err := e.cli.NetworkInspect(ctx, nw.ID, types.NetworkInspectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	config := proxyConfig
+	for _, c := range ni.IPAM.Config {
+		config += "Allow " + c.Subnet + "\n"
+	}
+	// unlike the default bridge, no other container can reach the
+	// proxy through its network to the hosts
+	en, err := e.cli.NetworkCreate(ctx, name+"-egress", types.NetworkCreate{
+		CheckDuplicate: true,
+		Labels:         e.labels(name + "-egress"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	egress = en.ID
+	_, err = e.cli.ContainerCreate(ctx, &container.Config{
+		Image:  proxyImage,
+		Cmd:    []string{"tinyproxy", "-d", "-c", "/etc/tinyproxy/eggsy.conf"},
+		Labels: e.labels(pID),
+	}, &container.HostConfig{NetworkMode: container.NetworkMode(egress)}, nil, pID)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct{ name, data string }{{"eggsy.conf", config}, {"filter", filter}} {
+		tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data))})
+		tw.Write([]byte(f.data))
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := e.cli.CopyToContainer(ctx, pID, "/etc/tinyproxy", &buf, types.CopyToContainerOptions{}); err != nil {
+		return nil, err
+	}
+	if err := e.cli.NetworkConnect(ctx, nw.ID, pID, &network.EndpointSettings{Aliases: []string{"proxy"}}); err != nil {
+		return nil, err
+	}
+	if err := e.cli.ContainerStart(ctx, pID, types.ContainerStartOptions{}); err != nil {
+		return nil, err
+	}
+	e.network = name
+	e.env = append(e.env[:0],
+		"HTTP_PROXY="+proxyAddr, "HTTPS_PROXY="+proxyAddr,
+		"http_proxy="+proxyAddr, "https_proxy="+proxyAddr,
+		"NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1",
+	)
+	return release, nil
+}
+
+// buildProxy builds the proxy's image unless the daemon already has it.
+func (e *Executor) buildProxy(ctx context.Context) error {
+	if _, _, err := e.cli.ImageInspectWithRaw(ctx, proxyImage); err == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0666, Size: int64(len(proxyDockerfile))})
+	tw.Write([]byte(proxyDockerfile))
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	r, err := e.cli.ImageBuild(ctx, &buf, types.ImageBuildOptions{
+		Tags:   []string{proxyImage},
+		Labels: e.labels(proxyImage),
+	})
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	berr, err := e.readBuild(r.Body)
+	if err != nil {
+		return err
+	}
+	if berr != nil {
+		return berr
+	}
+	return nil
+}
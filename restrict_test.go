@@ -0,0 +1,111 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRestrictProxyNetworks(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		created  []string // networks, in order
+		internal []bool
+		mode     string // network mode of the proxy
+		config   string
+		removed  []string
+	)
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		p := r.URL.Path
+		switch {
+		case r.Method == "GET" && strings.Contains(p, "/images/"):
+			fmt.Fprint(w, `{"Id":"proxy"}`)
+		case r.Method == "POST" && strings.HasSuffix(p, "/networks/create"):
+			var req struct {
+				Name     string
+				Internal bool
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			created = append(created, req.Name)
+			internal = append(internal, req.Internal)
+			fmt.Fprintf(w, `{"Id":%q}`, req.Name)
+		case r.Method == "GET" && strings.Contains(p, "/networks/"):
+			fmt.Fprint(w, `{"IPAM":{"Config":[{"Subnet":"172.30.0.0/16"}]}}`)
+		case r.Method == "POST" && strings.HasSuffix(p, "/containers/create"):
+			var req struct{ HostConfig struct{ NetworkMode string } }
+			json.NewDecoder(r.Body).Decode(&req)
+			mode = req.HostConfig.NetworkMode
+			fmt.Fprint(w, `{"Id":"proxy"}`)
+		case r.Method == "PUT" && strings.HasSuffix(p, "/archive"):
+			tr := tar.NewReader(r.Body)
+			for {
+				h, err := tr.Next()
+				if err != nil {
+					break
+				}
+				if h.Name == "eggsy.conf" {
+					b, _ := io.ReadAll(tr)
+					config = string(b)
+				}
+			}
+		case r.Method == "POST" && (strings.HasSuffix(p, "/connect") || strings.HasSuffix(p, "/start")):
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == "DELETE":
+			removed = append(removed, p[strings.LastIndex(p, "/")+1:])
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, p)
+			http.NotFound(w, r)
+		}
+	})
+	e := &Executor{cli: cli, AllowHosts: []string{"example.com"}}
+	release, err := e.restrict(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(created) != 2 || !internal[0] || internal[1] {
+		t.Fatalf("created networks %q, internal %v; want an internal network and an egress network", created, internal)
+	}
+	if mode != created[1] {
+		t.Errorf("proxy created on network %q, want the egress network %q", mode, created[1])
+	}
+	if !strings.Contains(config, "\nAllow 172.30.0.0/16\n") {
+		t.Errorf("proxy config doesn't allow only the restricted network:\n%s", config)
+	}
+	if want := strings.Join([]string{created[0] + "-proxy", created[0], created[1]}, " "); strings.Join(removed, " ") != want {
+		t.Errorf("release removed %q, want %s", removed, want)
+	}
+}
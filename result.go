@@ -0,0 +1,92 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import "time"
+
+// ExecResult describes how the command of an Executor ran.
+type ExecResult struct {
+	// Version is the SchemaVersion of the result.
+	Version int `json:"v"`
+
+	// Status is StatusOK if the command ran to completion,
+	// StatusOOMKilled if it ran out of memory, StatusCPUTimeExceeded
+	// if it exceeded its CPUTimeLimit, StatusDiskQuotaExceeded if it
+	// filled its DiskQuota, StatusBudgetExceeded if its Steps used up
+	// their budget, and StatusTimeout if it timed out.
+	Status Status `json:"status"`
+
+	// ExitCode is the exit code of the command.
+	ExitCode int `json:"exitCode"`
+
+	// Started and Finished are when the container started and exited.
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+
+	// OOMKilled reports whether the command was killed for running
+	// out of memory, and TimedOut whether it was killed by its timeout.
+	OOMKilled bool `json:"oomKilled"`
+	TimedOut  bool `json:"timedOut"`
+
+	// CPUTimeExceeded reports whether the command was killed for
+	// exceeding the Executor's CPUTimeLimit. The CPU time it used
+	// is reported in Usage.
+	CPUTimeExceeded bool `json:"cpuTimeExceeded,omitempty"`
+
+	// DiskQuotaExceeded reports whether the command filled the
+	// container's writable layer up to the Executor's DiskQuota.
+	DiskQuotaExceeded bool `json:"diskQuotaExceeded,omitempty"`
+
+	// StdoutTruncated and StderrTruncated report whether the command's
+	// standard output and standard error exceeded MaxOutputBytes.
+	StdoutTruncated bool `json:"stdoutTruncated,omitempty"`
+	StderrTruncated bool `json:"stderrTruncated,omitempty"`
+
+	// Emulated reports whether the command ran under qemu emulation,
+	// as its Executor's Platform differs from the daemon's architecture.
+	Emulated bool `json:"emulated,omitempty"`
+
+	// Ports maps the container's ExposePorts to the ports of the host
+	// they were published on.
+	Ports map[int]int `json:"ports,omitempty"`
+
+	// Usage describes the resources the container used. It is nil
+	// if the daemon doesn't report them.
+	Usage *Usage `json:"usage,omitempty"`
+
+	// Preemptions is the number of times a Queue canceled the execution
+	// of its Job to execute one of a higher Priority, before executing
+	// it again to this result.
+	Preemptions int `json:"preemptions,omitempty"`
+
+	// Steps holds the result of each of the Executor's Steps that ran,
+	// with the usage of the step alone, read from the container's
+	// cgroup between steps. The Usage of the whole is their sum.
+	Steps []*ExecResult `json:"steps,omitempty"`
+
+	// Artifacts holds the files matched by the Executor's Artifacts.
+	Artifacts FileSet `json:"-"`
+}
+
+// Duration returns the wall-clock time the container ran for.
+func (r *ExecResult) Duration() time.Duration { return r.Finished.Sub(r.Started) }
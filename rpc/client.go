@@ -0,0 +1,138 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package rpc exposes eggsy's Executor over gRPC, so that programs that
+// aren't written in Go, or that can't reach a Docker daemon, can execute
+// commands in sandboxes. Its service is defined in eggsy.proto, and is
+// implemented by package server, which eggsyd serves. This package holds
+// the service's generated code and a Client, and doesn't link eggsy.
+package rpc
+
+import (
+	"context"
+	"io"
+
+	"google.golang.org/grpc"
+)
+
+// EventResult is the type of the last Event of a job, which holds its
+// Result.
+const EventResult = "result"
+
+// Client executes jobs on an eggsyd server.
+type Client struct {
+	ExecutorClient
+	conn *grpc.ClientConn
+}
+
+// Dial returns a Client of the server at target, such as "localhost:7447".
+// The connection is made lazily, as by grpc.NewClient, and opts must
+// give it credentials, such as those of insecure.NewCredentials.
+func Dial(target string, opts ...grpc.DialOption) (*Client, error) {
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{ExecutorClient: NewExecutorClient(conn), conn: conn}, nil
+}
+
+// Token is the credentials of the calls to a server that requires a
+// token, as eggsyd does with -token-file. It is given to Dial with
+// grpc.WithPerRPCCredentials, and is sent as is, so the connection
+// should be secured with TLS unless it is to the loopback interface.
+type Token string
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (t Token) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials.
+func (t Token) RequireTransportSecurity() bool { return false }
+
+// Execute submits j, writes its output to stdout and stderr until it has
+// finished, and returns its result. If ctx is done first, the job is
+// canceled. The result's Id names the job for Artifacts.
+func (c *Client) Execute(ctx context.Context, j *Job, stdout, stderr io.Writer) (*Result, error) {
+	sub, err := c.Submit(ctx, &SubmitRequest{Job: j})
+	if err != nil {
+		return nil, err
+	}
+	stream, err := c.Stream(ctx, &StreamRequest{Id: sub.Id})
+	if err != nil {
+		c.cancel(sub.Id)
+		return nil, err
+	}
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			c.cancel(sub.Id)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch ev.Type {
+		case "stdout":
+			if stdout != nil {
+				stdout.Write(ev.Data)
+			}
+		case "stderr":
+			if stderr != nil {
+				stderr.Write(ev.Data)
+			}
+		case EventResult:
+			return ev.Result, nil
+		}
+	}
+}
+
+// cancel cancels the job with the given ID, regardless of the caller's
+// context, which may be done.
+func (c *Client) cancel(id string) {
+	c.Cancel(context.Background(), &CancelRequest{Id: id})
+}
+
+// ReadArtifacts returns the artifacts of the finished job with the
+// given ID.
+func (c *Client) ReadArtifacts(ctx context.Context, id string) ([]*File, error) {
+	stream, err := c.Artifacts(ctx, &ArtifactsRequest{Id: id})
+	if err != nil {
+		return nil, err
+	}
+	var files []*File
+	for {
+		f, err := stream.Recv()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+}
+
+// Close closes the Client's connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
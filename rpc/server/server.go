@@ -0,0 +1,236 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package server implements the Executor service of package rpc with
+// eggsy's Executor.
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+
+	"github.com/smasher164/eggsy"
+	"github.com/smasher164/eggsy/internal/jobs"
+	"github.com/smasher164/eggsy/rpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// DefaultMaxWaiting is the default MaxWaiting of a Server.
+const DefaultMaxWaiting = 64
+
+// Server implements rpc.ExecutorServer with eggsy's Executor. It keeps
+// a finished job for ten minutes, for its events and artifacts to be
+// fetched. A Server is safe for concurrent use.
+type Server struct {
+	rpc.UnimplementedExecutorServer
+
+	// MaxWaiting limits the number of jobs waiting for others to finish,
+	// beyond which Submit fails with ResourceExhausted, and a RetryInfo
+	// estimating when to retry. Zero means any number.
+	MaxWaiting int
+
+	opts []func(*eggsy.Executor)
+	jobs *jobs.Registry
+}
+
+// NewServer returns a Server that executes at most maxParallel jobs at
+// once, or any number if maxParallel is zero. The Executor of each job
+// has the fields of its request, hardened as by eggsy.HardenedDefaults
+// with a read-only root filesystem, and is then configured by each
+// option in turn, which may change any of its fields, such as to cap
+// its Timeout or to set its Runtime.
+func NewServer(maxParallel int, opts ...func(*eggsy.Executor)) *Server {
+	return &Server{MaxWaiting: DefaultMaxWaiting, opts: opts, jobs: jobs.New(maxParallel)}
+}
+
+// Submit implements rpc.ExecutorServer.
+func (s *Server) Submit(ctx context.Context, req *rpc.SubmitRequest) (*rpc.SubmitResponse, error) {
+	j := req.GetJob()
+	if j == nil {
+		return nil, status.Error(codes.InvalidArgument, "eggsy: missing job")
+	}
+	sj, err := s.jobs.Submit(s.executor(j), s.MaxWaiting)
+	var qfe *eggsy.QueueFullError
+	if errors.As(err, &qfe) {
+		st := status.New(codes.ResourceExhausted, err.Error())
+		if ds, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(qfe.RetryAfter)}); err == nil {
+			st = ds
+		}
+		return nil, st.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rpc.SubmitResponse{Id: sj.ID}, nil
+}
+
+// executor returns the Executor of j.
+func (s *Server) executor(j *rpc.Job) *eggsy.Executor {
+	e := &eggsy.Executor{
+		Dockerfile: j.Dockerfile,
+		Image:      j.Image,
+		Files:      fileSet(j.Files),
+		Cmd:        j.Cmd,
+		Args:       j.Args,
+		Timeout:    eggsy.DefaultRunTimeout,
+		Memory:     j.Memory,
+		PidsLimit:  j.PidsLimit,
+		Artifacts:  j.Artifacts,
+	}
+	if j.Stdin != nil {
+		e.Stdin = bytes.NewReader(j.Stdin)
+	}
+	if j.Timeout != nil {
+		e.Timeout = j.Timeout.AsDuration()
+	}
+	jobs.Harden(e)
+	for _, opt := range s.opts {
+		opt(e)
+	}
+	return e
+}
+
+// job returns the job with the given ID.
+func (s *Server) job(id string) (*jobs.Job, error) {
+	j, ok := s.jobs.Get(id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "eggsy: unknown job %q", id)
+	}
+	return j, nil
+}
+
+// Stream implements rpc.ExecutorServer.
+func (s *Server) Stream(req *rpc.StreamRequest, stream rpc.Executor_StreamServer) error {
+	j, err := s.job(req.GetId())
+	if err != nil {
+		return err
+	}
+	for i := 0; ; {
+		events, done, err := j.Events(stream.Context(), i)
+		if err != nil {
+			return status.FromContextError(err).Err()
+		}
+		for _, ev := range events {
+			rev := &rpc.Event{
+				Type:   string(ev.Type),
+				Time:   timestamppb.New(ev.Time),
+				Data:   []byte(ev.Data),
+				Status: string(ev.Status),
+				Error:  ev.Error,
+			}
+			if ev.ExitCode != nil {
+				rev.ExitCode = int32(*ev.ExitCode)
+			}
+			if err := stream.Send(rev); err != nil {
+				return err
+			}
+		}
+		i += len(events)
+		if done {
+			return stream.Send(&rpc.Event{Type: rpc.EventResult, Time: timestamppb.Now(), Result: result(j)})
+		}
+	}
+}
+
+// result returns the Result of the finished job j.
+func result(j *jobs.Job) *rpc.Result {
+	res, err, _ := j.Result()
+	r := &rpc.Result{Id: j.ID, Status: string(eggsy.StatusOf(err))}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	if res != nil {
+		r.Status = string(res.Status)
+		r.ExitCode = int32(res.ExitCode)
+		r.Started = timestamppb.New(res.Started)
+		r.Finished = timestamppb.New(res.Finished)
+		r.OomKilled = res.OOMKilled
+		r.TimedOut = res.TimedOut
+	}
+	return r
+}
+
+// Cancel implements rpc.ExecutorServer.
+func (s *Server) Cancel(ctx context.Context, req *rpc.CancelRequest) (*rpc.CancelResponse, error) {
+	j, err := s.job(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	j.Cancel()
+	return &rpc.CancelResponse{}, nil
+}
+
+// Artifacts implements rpc.ExecutorServer. It fails with
+// FailedPrecondition if the job hasn't finished.
+func (s *Server) Artifacts(req *rpc.ArtifactsRequest, stream rpc.Executor_ArtifactsServer) error {
+	j, err := s.job(req.GetId())
+	if err != nil {
+		return err
+	}
+	res, _, ok := j.Result()
+	if !ok {
+		return status.Error(codes.FailedPrecondition, "eggsy: job hasn't finished")
+	}
+	if res == nil || res.Artifacts == nil {
+		return nil
+	}
+	files := res.Artifacts
+	for i := 0; i < files.Len(); i++ {
+		f, err := files.At(i)
+		if err != nil {
+			return err
+		}
+		if f.ReadCloser == nil {
+			continue
+		}
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&rpc.File{Path: f.Path, Contents: b, Mode: uint32(f.Mode.Perm())}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileSet is a FileSet of the files of a request.
+type fileSet []*rpc.File
+
+func (s fileSet) Len() int { return len(s) }
+
+func (s fileSet) At(i int) (eggsy.File, error) {
+	f := s[i]
+	return eggsy.File{
+		Path:       f.Path,
+		ReadCloser: ioutil.NopCloser(bytes.NewReader(f.Contents)),
+		Mode:       fs.FileMode(f.Mode).Perm(),
+	}, nil
+}
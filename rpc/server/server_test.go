@@ -0,0 +1,112 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/smasher164/eggsy"
+	"github.com/smasher164/eggsy/rpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestExecutorHardened(t *testing.T) {
+	s := NewServer(0)
+	e := s.executor(&rpc.Job{Image: "alpine", Cmd: "true", PidsLimit: 1 << 20})
+	if e.Net != eggsy.NetNone || !e.NoNewPrivileges || !e.ReadOnlyRootFS || e.Tmpfs["/tmp"] == "" {
+		t.Errorf("job not hardened: Net %v, NoNewPrivileges %v, ReadOnlyRootFS %v, Tmpfs %v", e.Net, e.NoNewPrivileges, e.ReadOnlyRootFS, e.Tmpfs)
+	}
+	if len(e.CapDrop) != 1 || e.CapDrop[0] != "ALL" {
+		t.Errorf("CapDrop = %q, want [ALL]", e.CapDrop)
+	}
+
+	// the files of a job run from an image are copied into its container
+	e = s.executor(&rpc.Job{Image: "alpine", Files: []*rpc.File{{Path: "a"}}})
+	if e.ReadOnlyRootFS {
+		t.Error("root filesystem is read-only for a job with files to copy")
+	}
+
+	// options override the hardening
+	s = NewServer(0, func(e *eggsy.Executor) { e.ReadOnlyRootFS = false })
+	if e := s.executor(&rpc.Job{Image: "alpine"}); e.ReadOnlyRootFS {
+		t.Error("option didn't override the hardening")
+	}
+}
+
+func TestSubmitResourceExhausted(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s := NewServer(1, func(e *eggsy.Executor) {
+		// the jobs wait until the test ends
+		e.Policy = eggsy.PolicyFunc(func(*eggsy.Executor) error {
+			<-release
+			return errors.New("released")
+		})
+	})
+	s.MaxWaiting = 1
+	// one job runs and one waits, so the third is refused, if the
+	// second isn't already
+	var err error
+	for i := 0; i < 3; i++ {
+		_, err = s.Submit(context.Background(), &rpc.SubmitRequest{Job: &rpc.Job{Image: "alpine"}})
+	}
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("Submit beyond MaxWaiting = %v, want ResourceExhausted", err)
+	}
+	if d := st.Details(); len(d) != 1 {
+		t.Errorf("details = %v, want a RetryInfo", d)
+	} else if _, ok := d[0].(*errdetails.RetryInfo); !ok {
+		t.Errorf("detail is %T, want a RetryInfo", d[0])
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	for _, tt := range []struct {
+		auth string
+		ok   bool
+	}{
+		{"Bearer secret", true},
+		{"Bearer wrong", false},
+		{"secret", false},
+		{"", false},
+	} {
+		ctx := context.Background()
+		if tt.auth != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.auth))
+		}
+		err := checkToken(ctx, "secret")
+		if tt.ok != (err == nil) || err != nil && status.Code(err) != codes.Unauthenticated {
+			t.Errorf("checkToken with authorization %q = %v, want ok %v", tt.auth, err, tt.ok)
+		}
+	}
+	md, _ := rpc.Token("secret").GetRequestMetadata(context.Background())
+	if err := checkToken(metadata.NewIncomingContext(context.Background(), metadata.New(md)), "secret"); err != nil {
+		t.Errorf("token sent by rpc.Token rejected: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// Job is a command for a Runner to execute, along with the environment
+// it runs in. Its fields have the meaning of the Executor's fields of
+// the same name.
+type Job struct {
+	Dockerfile string
+	Image      string
+	Files      FileSet
+	Cmd        string
+	Args       []string
+	Stdin      io.Reader
+	Stdout     io.Writer
+	Stderr     io.Writer
+
+	// Priority orders the Job among the Jobs waiting in a Queue, which
+	// executes those with a higher Priority first. Runners ignore it.
+	Priority int
+
+	// Preemptible lets a Queue whose workers are all busy cancel the
+	// Job while it executes, when a Job of a higher Priority is waiting,
+	// and execute it again once a worker is free. It suits batch Jobs
+	// that can be repeated. Runners ignore it.
+	Preemptible bool
+
+	// Tenant, if non-empty, is whose Job it is, whose limits a Queue's
+	// Quota applies to it. Runners ignore it.
+	Tenant string
+
+	// NotBefore, if non-zero, is the time before which a Queue doesn't
+	// execute the Job, such as to retry it after a delay. Runners
+	// ignore it.
+	NotBefore time.Time
+
+	// Options configure the Job's Executor after the options of its
+	// Runner or batch, such as to give it limits of its own.
+	Options []func(*Executor)
+}
+
+// Runner executes Jobs with a shared connection to the daemon. Unlike
+// an Executor, a Runner is safe for concurrent use.
+type Runner struct {
+	opts []func(*Executor)
+	sem  chan struct{}
+
+	cli     *client.Client
+	shared  bool // whether cli is an Executor's Client, which isn't closed
+	runtime string
+}
+
+// NewRunner connects to the daemon, and returns a Runner that executes
+// at most maxParallel Jobs at once, or any number if maxParallel is zero.
+// The Executor of each Job has the Job's fields, and is then configured
+// by each option in turn, which may change any of its fields. The options
+// are also applied to the Executor that connects to the daemon, so that
+// they may choose the daemon and the runtime.
+func NewRunner(ctx context.Context, maxParallel int, opts ...func(*Executor)) (*Runner, error) {
+	e := new(Executor)
+	for _, opt := range opts {
+		opt(e)
+	}
+	if err := e.connect(ctx); err != nil {
+		return nil, err
+	}
+	r := &Runner{opts: opts, cli: e.cli, runtime: e.runtime, shared: e.Client != nil}
+	if maxParallel > 0 {
+		r.sem = make(chan struct{}, maxParallel)
+	}
+	return r, nil
+}
+
+// Execute executes j like Executor.Execute does, once fewer than the
+// Runner's maxParallel Jobs are executing. If ctx is done while waiting
+// to do so, it returns ctx's error.
+func (r *Runner) Execute(ctx context.Context, j Job) (*ExecResult, error) {
+	if r.sem != nil {
+		select {
+		case r.sem <- struct{}{}:
+			defer func() { <-r.sem }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	e := j.executor(r.opts)
+	e.cli, e.runtime = r.cli, r.runtime
+	return e.Execute(ctx)
+}
+
+// executor returns an Executor with the Job's fields, configured by
+// each option in turn, and then by the Job's Options.
+func (j *Job) executor(opts []func(*Executor)) *Executor {
+	e := &Executor{
+		Dockerfile: j.Dockerfile,
+		Image:      j.Image,
+		Files:      j.Files,
+		Cmd:        j.Cmd,
+		Args:       j.Args,
+		Stdin:      j.Stdin,
+		Stdout:     j.Stdout,
+		Stderr:     j.Stderr,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	for _, opt := range j.Options {
+		opt(e)
+	}
+	return e
+}
+
+// Close closes the Runner's connection to the daemon, unless the options
+// gave it a Client. Jobs must not be executing.
+func (r *Runner) Close() error {
+	if r.shared {
+		return nil
+	}
+	return r.cli.Close()
+}
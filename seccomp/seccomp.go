@@ -0,0 +1,201 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+// Package seccomp builds seccomp profiles in the JSON format of Docker,
+// for use as an eggsy.Executor's Seccomp.
+package seccomp
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+type (
+	// Action is what the kernel does when a system call matches a rule.
+	Action string
+
+	// Arch is an architecture that system calls are made with.
+	Arch string
+
+	// Profile is a seccomp profile. Its system calls are matched against
+	// its rules in order, and a call that matches none of them takes
+	// its DefaultAction.
+	Profile struct {
+		DefaultAction Action    `json:"defaultAction"`
+		Architectures []Arch    `json:"architectures,omitempty"`
+		Syscalls      []Syscall `json:"syscalls"`
+	}
+
+	// Syscall is a rule of a Profile, which takes Action on calls
+	// to the named system calls.
+	Syscall struct {
+		Names  []string `json:"names"`
+		Action Action   `json:"action"`
+	}
+)
+
+const (
+	// ActAllow allows the call.
+	ActAllow Action = "SCMP_ACT_ALLOW"
+
+	// ActErrno fails the call with EPERM.
+	ActErrno Action = "SCMP_ACT_ERRNO"
+
+	// ActKill kills the thread that made the call.
+	ActKill Action = "SCMP_ACT_KILL"
+
+	// ActTrap sends SIGSYS to the thread that made the call.
+	ActTrap Action = "SCMP_ACT_TRAP"
+
+	// ActLog allows the call after logging it.
+	ActLog Action = "SCMP_ACT_LOG"
+)
+
+// The architectures supported by Docker's seccomp profiles.
+const (
+	ArchX86     Arch = "SCMP_ARCH_X86"
+	ArchX86_64  Arch = "SCMP_ARCH_X86_64"
+	ArchX32     Arch = "SCMP_ARCH_X32"
+	ArchARM     Arch = "SCMP_ARCH_ARM"
+	ArchAARCH64 Arch = "SCMP_ARCH_AARCH64"
+	ArchPPC64LE Arch = "SCMP_ARCH_PPC64LE"
+	ArchS390X   Arch = "SCMP_ARCH_S390X"
+	ArchRISCV64 Arch = "SCMP_ARCH_RISCV64"
+)
+
+// New returns an empty Profile that takes action on every system call.
+func New(action Action) *Profile {
+	return &Profile{DefaultAction: action}
+}
+
+// Default sets the action of calls that match no rule.
+func (p *Profile) Default(action Action) *Profile {
+	p.DefaultAction = action
+	return p
+}
+
+// Arch restricts the profile to calls made with the given architectures.
+// Calls made with any other architecture fail.
+func (p *Profile) Arch(archs ...Arch) *Profile {
+	p.Architectures = append(p.Architectures, archs...)
+	return p
+}
+
+// AllowSyscalls adds a rule that allows the named system calls.
+func (p *Profile) AllowSyscalls(names ...string) *Profile {
+	return p.Rule(ActAllow, names...)
+}
+
+// DenySyscalls adds a rule that fails the named system calls with EPERM.
+func (p *Profile) DenySyscalls(names ...string) *Profile {
+	return p.Rule(ActErrno, names...)
+}
+
+// Rule adds a rule that takes action on calls to the named system calls.
+func (p *Profile) Rule(action Action, names ...string) *Profile {
+	if len(names) > 0 {
+		p.Syscalls = append(p.Syscalls, Syscall{Names: names, Action: action})
+	}
+	return p
+}
+
+// JSON returns the profile in the JSON format of Docker, which is what
+// an Executor's Seccomp is set to. The names of each rule are sorted, so
+// that equal profiles have the same JSON. The profile is not modified.
+func (p *Profile) JSON() (string, error) {
+	q := *p
+	// Docker rejects a null list of rules
+	q.Syscalls = make([]Syscall, len(p.Syscalls))
+	for i, s := range p.Syscalls {
+		s.Names = append([]string(nil), s.Names...)
+		sort.Strings(s.Names)
+		q.Syscalls[i] = s
+	}
+	b, err := json.Marshal(&q)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// String returns the JSON of the profile, or the error that JSON returns,
+// which the daemon rejects as a profile rather than running without one.
+func (p *Profile) String() string {
+	s, err := p.JSON()
+	if err != nil {
+		return err.Error()
+	}
+	return s
+}
+
+// NoNetworkSyscalls returns a profile that allows every system call
+// except those that create and use sockets, other than socketpair, so
+// that the command can't reach the network even if the container can.
+func NoNetworkSyscalls() *Profile {
+	return New(ActAllow).DenySyscalls(
+		"socket", "connect", "bind", "listen", "accept", "accept4",
+		"sendto", "sendmsg", "sendmmsg", "recvfrom", "recvmsg", "recvmmsg",
+		"getsockname", "getpeername", "setsockopt", "getsockopt", "shutdown",
+	)
+}
+
+// ComputeOnly returns a profile that fails every system call except
+// those needed to run a program that only computes: it can read and
+// write files it has been given, allocate memory, and start threads
+// and processes, but not use the network or change the system. It is
+// stricter than the profiles of the presets, and programs that need
+// more, such as compilers, fail under it.
+func ComputeOnly() *Profile {
+	return New(ActErrno).AllowSyscalls(computeSyscalls...)
+}
+
+var computeSyscalls = []string{
+	// processes and threads
+	"execve", "execveat", "exit", "exit_group", "clone", "clone3", "fork", "vfork",
+	"wait4", "waitid", "getpid", "getppid", "gettid", "getuid", "geteuid",
+	"getgid", "getegid", "getgroups", "getpgrp", "getpgid", "setpgid", "getsid",
+	"set_tid_address", "set_robust_list", "get_robust_list", "rseq",
+	"arch_prctl", "prctl", "prlimit64", "getrlimit", "setrlimit", "uname",
+	"sched_yield", "sched_getaffinity", "getrusage", "times", "sysinfo",
+	"membarrier",
+	// the runtime's setup before the command starts
+	"capget", "capset", "close_range",
+	// memory
+	"brk", "mmap", "munmap", "mremap", "mprotect", "madvise", "mlock", "munlock",
+	// signals
+	"rt_sigaction", "rt_sigprocmask", "rt_sigreturn", "rt_sigsuspend",
+	"sigaltstack", "kill", "tgkill", "tkill",
+	// time
+	"clock_gettime", "clock_getres", "clock_nanosleep", "gettimeofday",
+	"nanosleep", "time",
+	// synchronization
+	"futex", "epoll_create", "epoll_create1", "epoll_ctl", "epoll_wait",
+	"epoll_pwait", "poll", "ppoll", "select", "pselect6", "eventfd", "eventfd2",
+	"pipe", "pipe2",
+	// files
+	"read", "write", "readv", "writev", "pread64", "pwrite64", "open", "openat",
+	"close", "stat", "fstat", "lstat", "newfstatat", "statx", "statfs", "fstatfs",
+	"lseek", "access", "faccessat", "faccessat2", "readlink", "readlinkat",
+	"getdents", "getdents64", "getcwd", "chdir", "fcntl", "dup", "dup2", "dup3",
+	"ioctl", "mkdir", "mkdirat", "unlink", "unlinkat", "rename", "renameat",
+	"ftruncate", "fsync", "fadvise64", "umask", "getrandom",
+}
@@ -0,0 +1,91 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package seccomp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestProfileJSON(t *testing.T) {
+	p := New(ActErrno).Arch(ArchX86_64, ArchAARCH64).AllowSyscalls("read", "write").Rule(ActKill, "ptrace")
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(p.String()), &got); err != nil {
+		t.Fatalf("String() is not JSON: %v", err)
+	}
+	want := map[string]interface{}{
+		"defaultAction": "SCMP_ACT_ERRNO",
+		"architectures": []interface{}{"SCMP_ARCH_X86_64", "SCMP_ARCH_AARCH64"},
+		"syscalls": []interface{}{
+			map[string]interface{}{"names": []interface{}{"read", "write"}, "action": "SCMP_ACT_ALLOW"},
+			map[string]interface{}{"names": []interface{}{"ptrace"}, "action": "SCMP_ACT_KILL"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("String() = %v, want %v", got, want)
+	}
+}
+
+func TestProfileJSONEmpty(t *testing.T) {
+	// Docker rejects a profile whose rules are null
+	if got, want := New(ActAllow).String(), `{"defaultAction":"SCMP_ACT_ALLOW","syscalls":[]}`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+}
+
+func TestProfileJSONUnmodified(t *testing.T) {
+	p := New(ActAllow)
+	_ = p.String()
+	if p.Syscalls != nil {
+		t.Errorf("String() set the rules of an empty profile to %v", p.Syscalls)
+	}
+	p.DenySyscalls("socket", "bind", "connect")
+	s, err := p.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"defaultAction":"SCMP_ACT_ALLOW","syscalls":[{"names":["bind","connect","socket"],"action":"SCMP_ACT_ERRNO"}]}`; s != want {
+		t.Errorf("JSON() = %s, want %s", s, want)
+	}
+	if got := p.Syscalls[0].Names; !reflect.DeepEqual(got, []string{"socket", "bind", "connect"}) {
+		t.Errorf("JSON() reordered the names of the rule to %q", got)
+	}
+}
+
+func TestComputeOnlyStartup(t *testing.T) {
+	// the runtime makes these calls as it starts the command
+	allowed := make(map[string]bool)
+	for _, s := range ComputeOnly().Syscalls {
+		if s.Action == ActAllow {
+			for _, n := range s.Names {
+				allowed[n] = true
+			}
+		}
+	}
+	for _, n := range []string{"execve", "capget", "capset", "setrlimit", "prctl", "fstatfs", "close_range"} {
+		if !allowed[n] {
+			t.Errorf("ComputeOnly doesn't allow %s", n)
+		}
+	}
+}
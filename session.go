@@ -0,0 +1,344 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// ErrSessionClosed is returned when executing a command in a closed
+// ExecSession.
+var ErrSessionClosed = errors.New("eggsy: session is closed")
+
+// ExecSession is a sandbox whose container stays alive between commands,
+// so that each command sees the files and processes left behind by the
+// ones before it, as in a REPL or notebook. Commands run one at a time.
+// An ExecSession is safe for concurrent use.
+type ExecSession struct {
+	e   *Executor
+	ref ImageRef
+	id  string
+	dir string // working directory of the container
+
+	mu      sync.Mutex // held while a command runs
+	closed  bool
+	release func() // removes the restricted network, if any
+	idle    *idleTimer
+}
+
+// NewExecSession builds the image described by e, or uses e's Image, and
+// starts a container from it that commands are run in by Exec. The
+// container is configured by e as it would be by Execute, but e's Cmd,
+// Stdin, StdinPath, and output fields are ignored. The ExecSession takes
+// ownership of e, which must not be used again.
+func NewExecSession(ctx context.Context, e *Executor) (s *ExecSession, err error) {
+	if err := e.checkPolicy(); err != nil {
+		return nil, err
+	}
+	ref, err := e.buildImage(ctx)
+	if e.stdin != nil {
+		e.stdin.Close()
+		e.stdin = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s = &ExecSession{e: e, ref: ref, id: randN(16)}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+	if e.Net == NetRestricted {
+		if s.release, err = e.restrict(ctx); err != nil {
+			return nil, err
+		}
+	}
+	s.dir = e.workDir(ctx, ref.Tag)
+	if err := e.startIdle(ctx, s.id, ref.Tag, s.dir, ref.files); err != nil {
+		return nil, err
+	}
+	s.idle = newIdleTimer(e.IdleTimeout, func() { s.Close() })
+	return s, nil
+}
+
+// Exec runs the shell command cmd in the session's container, feeding it
+// stdin if non-nil, and writing its standard output and standard error
+// to stdout and stderr. If the Executor's Tty is set, cmd runs in a
+// terminal whose output is written to stdout. The Timeout of the
+// session's Executor applies to cmd. Since a command can't be killed on
+// its own, the session is closed if cmd times out, in which case Exec
+// returns a TimeoutError, or if ctx is done before cmd exits. Exec
+// returns ErrSessionClosed once the session is closed, such as after its
+// Executor's IdleTimeout.
+func (s *ExecSession) Exec(ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer) (*ExecResult, error) {
+	s.idle.begin()
+	defer s.idle.end()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+	res, err := s.e.exec(ctx, s.id, s.ref.Tag, cmd, s.e.Timeout, stdin, stdout, stderr)
+	var ce *ContextError
+	if errors.Is(err, ErrTimeout) || errors.As(err, &ce) {
+		s.close()
+	}
+	return res, err
+}
+
+// Resize sets the size of the terminal of the command that is running to
+// the given number of rows and columns. It returns ErrNotRunning if no
+// command is running, and is only meaningful when the Executor's Tty is
+// set.
+func (s *ExecSession) Resize(ctx context.Context, rows, cols uint) error {
+	s.e.mu.Lock()
+	execID := s.e.execID
+	s.e.mu.Unlock()
+	if execID == "" {
+		return ErrNotRunning
+	}
+	return s.e.cli.ContainerExecResize(ctx, execID, types.ResizeOptions{Height: rows, Width: cols})
+}
+
+// Keepalive keeps the session from being closed by its Executor's
+// IdleTimeout for another IdleTimeout, as if a command had just run.
+func (s *ExecSession) Keepalive() {
+	s.idle.touch()
+}
+
+// Close removes the session's container, and its image unless it was
+// the Executor's Image or is held by its BuildCache. A command that is
+// running is killed.
+func (s *ExecSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.close()
+}
+
+func (s *ExecSession) close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.idle.stop()
+	s.e.removeContainer(s.id)
+	if s.release != nil {
+		s.release()
+	}
+	return s.e.Remove(context.Background(), s.ref)
+}
+
+// idleTimer calls a function once it has been idle for a timeout, unless
+// it is busy or has been touched since. A nil idleTimer is never idle.
+type idleTimer struct {
+	timeout time.Duration
+	t       *time.Timer
+
+	mu   sync.Mutex
+	busy int       // calls of begin without end
+	last time.Time // the last activity
+}
+
+// newIdleTimer returns an idleTimer that calls fn after timeout, or nil
+// if timeout isn't positive.
+func newIdleTimer(timeout time.Duration, fn func()) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	it := &idleTimer{timeout: timeout, last: time.Now()}
+	it.t = time.AfterFunc(timeout, func() {
+		it.mu.Lock()
+		left := it.timeout - time.Since(it.last)
+		if it.busy > 0 || left > 0 {
+			// end or touch have moved the deadline
+			if it.busy == 0 {
+				it.t.Reset(left)
+			}
+			it.mu.Unlock()
+			return
+		}
+		it.mu.Unlock()
+		fn()
+	})
+	return it
+}
+
+// begin marks the start of an activity, during which it isn't idle.
+func (it *idleTimer) begin() {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	it.busy++
+	it.mu.Unlock()
+}
+
+// end marks the end of an activity begun by begin.
+func (it *idleTimer) end() {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	it.busy--
+	it.last = time.Now()
+	if it.busy == 0 {
+		it.t.Reset(it.timeout)
+	}
+	it.mu.Unlock()
+}
+
+// touch resets the timeout, as if an activity had just ended.
+func (it *idleTimer) touch() {
+	if it == nil {
+		return
+	}
+	it.mu.Lock()
+	it.last = time.Now()
+	it.mu.Unlock()
+}
+
+func (it *idleTimer) stop() {
+	if it != nil {
+		it.t.Stop()
+	}
+}
+
+// idleCmd keeps a container running until it is given commands to exec.
+var idleCmd = strslice.StrSlice{"sh", "-c", "while :; do sleep 3600; done"}
+
+// startIdle starts a container named id from the image tag that idles
+// until it is removed. If files is non-nil, it is a tar archive copied
+// into dir before the container starts.
+func (e *Executor) startIdle(ctx context.Context, id, tag, dir string, files []byte) (err error) {
+	_, err = e.cli.ContainerCreate(ctx, &container.Config{
+		Cmd:          idleCmd,
+		Env:          e.env,
+		ExposedPorts: e.exposedPorts(),
+		Image:        tag,
+		Labels:       e.labels(id),
+	}, e.hostConfig(), nil, id)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			e.removeContainer(id)
+		}
+	}()
+	if files != nil {
+		if err := e.cli.CopyToContainer(ctx, id, dir, bytes.NewReader(files), types.CopyToContainerOptions{}); err != nil {
+			return err
+		}
+	}
+	return e.cli.ContainerStart(ctx, id, types.ContainerStartOptions{})
+}
+
+// exec runs the shell command cmd in the running container id, created
+// from the image tag, for up to timeout unless it is negative. A command
+// that times out or whose ctx is done is left running, and its container
+// must be removed.
+func (e *Executor) exec(ctx context.Context, id, tag, cmd string, timeout time.Duration, stdin io.Reader, stdout, stderr io.Writer) (*ExecResult, error) {
+	cli := e.cli
+	ex, err := cli.ContainerExecCreate(ctx, id, types.ExecConfig{
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          e.Tty,
+		Cmd:          []string{"sh", "-c", cmd},
+		WorkingDir:   e.WorkingDir,
+		User:         e.User,
+	})
+	if err != nil {
+		return nil, err
+	}
+	hj, err := cli.ContainerExecAttach(ctx, ex.ID, types.ExecStartCheck{Tty: e.Tty})
+	if err != nil {
+		return nil, err
+	}
+	defer hj.Close()
+	e.setExec(ex.ID)
+	defer e.setExec("")
+	start := time.Now()
+	if stdin != nil {
+		go func() {
+			io.Copy(hj.Conn, stdin)
+			hj.CloseWrite()
+		}()
+	}
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+	if stdout == stderr {
+		stdout = &syncWriter{w: stdout}
+		stderr = stdout
+	}
+	// the output ends once the command exits
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		if e.Tty {
+			// a terminal's output is not multiplexed
+			io.Copy(stdout, hj.Reader)
+			return
+		}
+		stdcopy.StdCopy(stdout, stderr, hj.Reader)
+	}()
+	var timer <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	select {
+	case <-copied:
+	case <-timer:
+		hj.Close()
+		<-copied
+		return &ExecResult{Version: SchemaVersion, Status: StatusTimeout, ExitCode: 137, Started: start, Finished: time.Now(), TimedOut: true},
+			&TimeoutError{Cmd: cmd, ContainerID: id, Image: tag, Timeout: timeout, Elapsed: time.Since(start)}
+	case <-ctx.Done():
+		hj.Close()
+		<-copied
+		return nil, &ContextError{Cmd: cmd, ContainerID: id, Image: tag, Err: ctx.Err()}
+	}
+	ins, err := cli.ContainerExecInspect(ctx, ex.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecResult{Version: SchemaVersion, Status: StatusOK, ExitCode: ins.ExitCode, Started: start, Finished: time.Now()}, nil
+}
@@ -0,0 +1,132 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdleTimer(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	fired := make(chan time.Time, 1)
+	start := time.Now()
+	it := newIdleTimer(timeout, func() { fired <- time.Now() })
+	defer it.stop()
+
+	// busy for longer than the timeout
+	it.begin()
+	time.Sleep(2 * timeout)
+	select {
+	case <-fired:
+		t.Fatal("idle timer fired while busy")
+	default:
+	}
+	it.end()
+	ended := time.Now()
+
+	// touched before the timeout
+	time.Sleep(timeout / 2)
+	it.touch()
+	touched := time.Now()
+
+	select {
+	case at := <-fired:
+		if at.Sub(ended) < timeout || at.Sub(touched) < timeout {
+			t.Errorf("idle timer fired %v after the end and %v after the touch, want at least %v", at.Sub(ended), at.Sub(touched), timeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("idle timer didn't fire %v after it started", time.Since(start))
+	}
+}
+
+func TestIdleTimerNil(t *testing.T) {
+	it := newIdleTimer(0, func() { t.Error("idle timer without a timeout fired") })
+	if it != nil {
+		t.Fatal("newIdleTimer(0) != nil")
+	}
+	it.begin()
+	it.end()
+	it.touch()
+	it.stop()
+}
+
+func TestExecTty(t *testing.T) {
+	var resized string
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/c/exec"):
+			var cfg struct{ Tty bool }
+			json.NewDecoder(r.Body).Decode(&cfg)
+			if !cfg.Tty {
+				t.Error("exec created without a terminal")
+			}
+			fmt.Fprint(w, `{"Id":"x"}`)
+		case strings.HasSuffix(r.URL.Path, "/exec/x/start"):
+			var check struct{ Tty bool }
+			json.NewDecoder(r.Body).Decode(&check)
+			if !check.Tty {
+				t.Error("exec attached without a terminal")
+			}
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			// a terminal's output is raw, with no stream headers
+			buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\nraw output")
+			buf.Flush()
+			conn.Close()
+		case strings.HasSuffix(r.URL.Path, "/exec/x/json"):
+			fmt.Fprint(w, `{"ExitCode":0}`)
+		case strings.HasSuffix(r.URL.Path, "/exec/x/resize"):
+			resized = r.URL.Query().Get("h") + "x" + r.URL.Query().Get("w")
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+	e := &Executor{cli: cli, Tty: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var stdout bytes.Buffer
+	res, err := e.exec(ctx, "c", "img", "true", -1, nil, &stdout, nil)
+	if err != nil || res.ExitCode != 0 {
+		t.Fatalf("exec = %v, %v", res, err)
+	}
+	if stdout.String() != "raw output" {
+		t.Errorf("stdout = %q, want %q", stdout.String(), "raw output")
+	}
+
+	// the terminal of a running exec is sized in place of the container's
+	e.setExec("x")
+	if err := e.Resize(ctx, 24, 80); err != nil || resized != "24x80" {
+		t.Errorf("Resize = %v, sized %q; want nil, 24x80", err, resized)
+	}
+}
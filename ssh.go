@@ -0,0 +1,116 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// sshOpts returns the options of a client that reaches the daemon of
+// the host named by the ssh:// URL addr, as the docker CLI does: by
+// running `docker system dial-stdio` on the host over ssh for each
+// connection. ssh must be able to log in to the host without a prompt.
+func sshOpts(addr string) ([]func(*client.Client) error, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("eggsy: invalid ssh host %q", addr)
+	}
+	var args []string
+	if u.User != nil {
+		args = append(args, "-l", u.User.Username())
+	}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, "--", u.Hostname(), "docker", "system", "dial-stdio")
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		// the connection outlives the context it is dialed with
+		return dialCommand("ssh", args...)
+	}
+	return []func(*client.Client) error{
+		// the host only names the daemon in requests
+		client.WithHost("tcp://docker.example.com:80"),
+		client.WithHTTPClient(&http.Client{
+			Transport:     &http.Transport{DialContext: dial},
+			CheckRedirect: client.CheckRedirect,
+		}),
+	}, nil
+}
+
+// commandConn is a connection to the standard input and output of a
+// command.
+type commandConn struct {
+	cmd *exec.Cmd
+	io.ReadCloser
+	io.WriteCloser
+	once sync.Once
+}
+
+func dialCommand(name string, args ...string) (net.Conn, error) {
+	cmd := exec.Command(name, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &commandConn{cmd: cmd, ReadCloser: stdout, WriteCloser: stdin}, nil
+}
+
+func (c *commandConn) Close() error {
+	c.once.Do(func() {
+		c.WriteCloser.Close()
+		c.cmd.Process.Kill()
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr                { return commandAddr{} }
+func (c *commandConn) RemoteAddr() net.Addr               { return commandAddr{} }
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type commandAddr struct{}
+
+func (commandAddr) Network() string { return "command" }
+func (commandAddr) String() string  { return "command" }
@@ -0,0 +1,289 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Usage describes the resources used by a container over its lifetime.
+type Usage struct {
+	// MaxMemory is the most memory the container used, in bytes.
+	MaxMemory uint64 `json:"maxMemory"`
+
+	// CPUUser and CPUSystem are the CPU time the container spent in
+	// user and kernel mode.
+	CPUUser   time.Duration `json:"-"`
+	CPUSystem time.Duration `json:"-"`
+
+	// BlockRead and BlockWrite are the bytes read from and written to
+	// block devices.
+	BlockRead  uint64 `json:"blockRead"`
+	BlockWrite uint64 `json:"blockWrite"`
+
+	// NetRx and NetTx are the bytes received and sent over the network.
+	NetRx uint64 `json:"netRx"`
+	NetTx uint64 `json:"netTx"`
+}
+
+// CPU returns the total CPU time the container used.
+func (u *Usage) CPU() time.Duration { return u.CPUUser + u.CPUSystem }
+
+// add updates u with a sample of the container's stats. Every counter
+// but memory is cumulative, so the latest sample is kept.
+func (u *Usage) add(s *types.StatsJSON) {
+	mem := s.MemoryStats.MaxUsage
+	if s.MemoryStats.Usage > mem {
+		// cgroup v2 doesn't report the maximum
+		mem = s.MemoryStats.Usage
+	}
+	if mem > u.MaxMemory {
+		u.MaxMemory = mem
+	}
+	u.CPUUser = time.Duration(s.CPUStats.CPUUsage.UsageInUsermode)
+	u.CPUSystem = time.Duration(s.CPUStats.CPUUsage.UsageInKernelmode)
+	u.BlockRead, u.BlockWrite = 0, 0
+	for _, b := range s.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(b.Op) {
+		case "read":
+			u.BlockRead += b.Value
+		case "write":
+			u.BlockWrite += b.Value
+		}
+	}
+	u.NetRx, u.NetTx = 0, 0
+	for _, n := range s.Networks {
+		u.NetRx += n.RxBytes
+		u.NetTx += n.TxBytes
+	}
+}
+
+// ContainerStats is a sample of the stats of a running container.
+type ContainerStats struct {
+	// Read is when the sample was taken.
+	Read time.Time
+
+	// Memory is the memory the container is using, and MemoryLimit
+	// the most it may use, in bytes.
+	Memory      uint64
+	MemoryLimit uint64
+
+	// CPUPercent is the CPU the container used since the previous
+	// sample, as a percentage of one CPU, so that a container busy
+	// on two CPUs uses 200 percent.
+	CPUPercent float64
+
+	// Pids is the number of processes in the container.
+	Pids uint64
+
+	// Usage holds the resources the container has used so far.
+	Usage Usage
+}
+
+func (c *ContainerStats) set(s *types.StatsJSON) {
+	c.Read = s.Read
+	c.Memory, c.MemoryLimit = s.MemoryStats.Usage, s.MemoryStats.Limit
+	c.Pids = s.PidsStats.Current
+	cpu := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	sys := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if n := s.CPUStats.OnlineCPUs; cpu > 0 && sys > 0 && n > 0 {
+		c.CPUPercent = cpu / sys * float64(n) * 100
+	}
+	c.Usage.add(s)
+}
+
+// Stats samples the stats of the running container about once a second,
+// so that callers can watch its resources or kill it by their own policy.
+// The channel is closed once the container exits or ctx is done. Stats
+// returns ErrNotRunning if the container has not started or has exited.
+func (e *Executor) Stats(ctx context.Context) (<-chan ContainerStats, error) {
+	e.mu.Lock()
+	cID := e.cID
+	e.mu.Unlock()
+	if cID == "" {
+		return nil, ErrNotRunning
+	}
+	r, err := e.cli.ContainerStats(ctx, cID, true)
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan ContainerStats)
+	go func() {
+		defer close(c)
+		defer r.Body.Close()
+		var cs ContainerStats
+		readStats(r.Body, func(s *types.StatsJSON) {
+			cs.set(s)
+			select {
+			case c <- cs:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return c, nil
+}
+
+// readStats calls f with each sample decoded from the stream r,
+// skipping the empty samples of a container that has stopped.
+func readStats(r io.Reader, f func(*types.StatsJSON)) {
+	dec := json.NewDecoder(r)
+	for {
+		var s types.StatsJSON
+		if err := dec.Decode(&s); err != nil {
+			return
+		}
+		if !s.Read.IsZero() {
+			f(&s)
+		}
+	}
+}
+
+// watchStats streams the stats of the running container until it exits,
+// calling check, if non-nil, with the Usage after every sample.
+// The returned function waits briefly for the stream to end once the
+// container has exited, and returns the Usage sampled, or nil if no
+// samples were taken, such as when the daemon doesn't report stats for
+// the container's runtime.
+func (e *Executor) watchStats(ctx context.Context, cID string, check func(*Usage)) (stop func() *Usage) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan *Usage, 1)
+	go func() {
+		var u *Usage
+		defer func() { done <- u }()
+		r, err := e.cli.ContainerStats(ctx, cID, true)
+		if err != nil {
+			return
+		}
+		defer r.Body.Close()
+		readStats(r.Body, func(s *types.StatsJSON) {
+			if u == nil {
+				u = new(Usage)
+			}
+			u.add(s)
+			if check != nil {
+				check(u)
+			}
+		})
+	}()
+	return func() *Usage {
+		defer cancel()
+		select {
+		case u := <-done:
+			return u
+		case <-time.After(time.Second):
+			cancel()
+			return <-done
+		}
+	}
+}
+
+// cgroupFiles are the files of a container's cgroup that sampleCgroup
+// reads, under cgroup v2 and then v1.
+var cgroupFiles = []string{
+	"/sys/fs/cgroup/memory.events",
+	"/sys/fs/cgroup/memory.peak",
+	"/sys/fs/cgroup/cpu.stat",
+	"/sys/fs/cgroup/memory/memory.oom_control",
+	"/sys/fs/cgroup/memory/memory.max_usage_in_bytes",
+	"/sys/fs/cgroup/cpuacct/cpuacct.stat",
+}
+
+// cgroupSample is what the cgroup of a container has counted so far.
+type cgroupSample struct {
+	oomKills  uint64
+	peak      uint64 // most memory used, if the kernel reports it
+	cpuUser   time.Duration
+	cpuSystem time.Duration
+}
+
+// sampleCgroup reads the cgroup of the running container id with a
+// command of its shell, as the stats of a container don't tell apart
+// the commands run in it, and Inspect reports OOMKilled only once the
+// container exits.
+func (e *Executor) sampleCgroup(ctx context.Context, id, tag string) (*cgroupSample, error) {
+	// only builtins of sh, as the image may have nothing else
+	cmd := "for f in " + strings.Join(cgroupFiles, " ") + `; do [ -r "$f" ] && while read -r l; do echo "$f $l"; done < "$f"; done; true`
+	var out bytes.Buffer
+	res, err := e.exec(ctx, id, tag, cmd, 10*time.Second, nil, &out, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.ExitCode != 0 {
+		return nil, fmt.Errorf("eggsy: reading the cgroup of %s exited with code %d", id, res.ExitCode)
+	}
+	return parseCgroup(out.String()), nil
+}
+
+// parseCgroup parses lines of a cgroup file's path and one of its lines.
+func parseCgroup(out string) *cgroupSample {
+	var s cgroupSample
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(f[len(f)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		file, key := path.Base(f[0]), ""
+		if len(f) > 2 {
+			key = f[1]
+		}
+		switch file + " " + key {
+		case "memory.events oom_kill", "memory.oom_control oom_kill":
+			s.oomKills = n
+		case "memory.peak ", "memory.max_usage_in_bytes ":
+			s.peak = n
+		case "cpu.stat user_usec":
+			s.cpuUser = time.Duration(n) * time.Microsecond
+		case "cpu.stat system_usec":
+			s.cpuSystem = time.Duration(n) * time.Microsecond
+		case "cpuacct.stat user":
+			// in USER_HZ, which is 100 on Linux
+			s.cpuUser = time.Duration(n) * 10 * time.Millisecond
+		case "cpuacct.stat system":
+			s.cpuSystem = time.Duration(n) * 10 * time.Millisecond
+		}
+	}
+	return &s
+}
+
+// since returns the Usage of the commands run between prev and s.
+func (s *cgroupSample) since(prev *cgroupSample) *Usage {
+	return &Usage{
+		MaxMemory: s.peak,
+		CPUUser:   s.cpuUser - prev.cpuUser,
+		CPUSystem: s.cpuSystem - prev.cpuSystem,
+	}
+}
@@ -0,0 +1,204 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// SchemaVersion is the version of the JSON encoding of Events, Statuses,
+// ExecResults, and errors. It is incremented whenever a field is removed or changes
+// meaning, but not when fields are added.
+const SchemaVersion = 1
+
+// Status summarizes how an execution ended. Its values are stable,
+// and are used as-is in JSON encodings.
+type Status string
+
+const (
+	// StatusOK means the command ran to completion, whatever its exit code.
+	StatusOK Status = "ok"
+
+	// StatusTimeout means the container was killed by its timeout.
+	StatusTimeout Status = "timeout"
+
+	// StatusOOMKilled means the command was killed for exceeding
+	// its memory limit.
+	StatusOOMKilled Status = "oomKilled"
+
+	// StatusCPUTimeExceeded means the command was killed for
+	// exceeding its CPUTimeLimit.
+	StatusCPUTimeExceeded Status = "cpuTimeExceeded"
+
+	// StatusDiskQuotaExceeded means the command filled the container's
+	// writable layer up to its DiskQuota.
+	StatusDiskQuotaExceeded Status = "diskQuotaExceeded"
+
+	// StatusBudgetExceeded means the execution was canceled, or never
+	// started, because its batch or Steps used up their budget.
+	StatusBudgetExceeded Status = "budgetExceeded"
+
+	// StatusCanceled means the caller's context was done before the
+	// container exited.
+	StatusCanceled Status = "canceled"
+
+	// StatusError means the execution failed for any other reason,
+	// such as the image failing to build.
+	StatusError Status = "error"
+)
+
+// StatusOf returns the Status of an execution that returned err.
+func StatusOf(err error) Status {
+	var ce *ContextError
+	switch {
+	case err == nil:
+		return StatusOK
+	case errors.Is(err, ErrTimeout):
+		return StatusTimeout
+	case errors.Is(err, ErrDiskQuota):
+		return StatusDiskQuotaExceeded
+	case errors.Is(err, ErrBudgetExceeded):
+		return StatusBudgetExceeded
+	case errors.As(err, &ce), errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return StatusCanceled
+	default:
+		return StatusError
+	}
+}
+
+type timeoutErrorJSON struct {
+	Status      Status `json:"status"`
+	Message     string `json:"message"`
+	Cmd         string `json:"cmd"`
+	ContainerID string `json:"containerId"`
+	Image       string `json:"image"`
+	TimeoutMS   int64  `json:"timeoutMs"`
+	ElapsedMS   int64  `json:"elapsedMs"`
+	OutputBytes int64  `json:"outputBytes"`
+}
+
+// MarshalJSON encodes t with its durations in milliseconds.
+func (t *TimeoutError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(timeoutErrorJSON{
+		Status:      StatusTimeout,
+		Message:     t.Error(),
+		Cmd:         t.Cmd,
+		ContainerID: t.ContainerID,
+		Image:       t.Image,
+		TimeoutMS:   t.Timeout.Milliseconds(),
+		ElapsedMS:   t.Elapsed.Milliseconds(),
+		OutputBytes: t.Output,
+	})
+}
+
+type contextErrorJSON struct {
+	Status      Status `json:"status"`
+	Message     string `json:"message"`
+	Cmd         string `json:"cmd"`
+	ContainerID string `json:"containerId"`
+	Image       string `json:"image"`
+	Err         string `json:"err"`
+}
+
+// MarshalJSON encodes c with its context's error as a string.
+func (c *ContextError) MarshalJSON() ([]byte, error) {
+	var msg string
+	if c.Err != nil {
+		msg = c.Err.Error()
+	}
+	return json.Marshal(contextErrorJSON{
+		Status:      StatusCanceled,
+		Message:     c.Error(),
+		Cmd:         c.Cmd,
+		ContainerID: c.ContainerID,
+		Image:       c.Image,
+		Err:         msg,
+	})
+}
+
+type diskQuotaErrorJSON struct {
+	Status      Status `json:"status"`
+	Message     string `json:"message"`
+	Cmd         string `json:"cmd"`
+	ContainerID string `json:"containerId"`
+	Image       string `json:"image"`
+	Quota       int64  `json:"quota"`
+	Used        int64  `json:"used"`
+}
+
+// MarshalJSON encodes d with its quota and usage in bytes.
+func (d *DiskQuotaError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(diskQuotaErrorJSON{
+		Status:      StatusDiskQuotaExceeded,
+		Message:     d.Error(),
+		Cmd:         d.Cmd,
+		ContainerID: d.ContainerID,
+		Image:       d.Image,
+		Quota:       d.Quota,
+		Used:        d.Used,
+	})
+}
+
+type buildErrorJSON struct {
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Step    string `json:"step"`
+	Error   string `json:"error"`
+	Code    int    `json:"code"`
+}
+
+// MarshalJSON encodes b with the daemon's error message.
+func (b *BuildError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(buildErrorJSON{
+		Status:  StatusError,
+		Message: b.Error(),
+		Step:    b.Step,
+		Error:   b.Message,
+		Code:    b.Code,
+	})
+}
+
+type usageJSON struct {
+	MaxMemory   uint64 `json:"maxMemory"`
+	CPUUserMS   int64  `json:"cpuUserMs"`
+	CPUSystemMS int64  `json:"cpuSystemMs"`
+	BlockRead   uint64 `json:"blockRead"`
+	BlockWrite  uint64 `json:"blockWrite"`
+	NetRx       uint64 `json:"netRx"`
+	NetTx       uint64 `json:"netTx"`
+}
+
+// MarshalJSON encodes u with its CPU times in milliseconds.
+func (u *Usage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(usageJSON{
+		MaxMemory:   u.MaxMemory,
+		CPUUserMS:   u.CPUUser.Milliseconds(),
+		CPUSystemMS: u.CPUSystem.Milliseconds(),
+		BlockRead:   u.BlockRead,
+		BlockWrite:  u.BlockWrite,
+		NetRx:       u.NetRx,
+		NetTx:       u.NetTx,
+	})
+}
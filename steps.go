@@ -0,0 +1,218 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Step is one command of a pipeline run by an Executor with Steps, such
+// as compiling a program and then running it. Every step runs in the same
+// container, so each sees the files left behind by the ones before it.
+type Step struct {
+	// Cmd is the shell command of the step. It is passed as is to sh -c.
+	Cmd string
+
+	// Stdin, if non-nil, is fed to the command. Its output is written to
+	// Stdout and Stderr, or to those of the Executor if they are nil,
+	// and like the output of any command, is subject to the Executor's
+	// limits, filters, and other destinations of output.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Timeout limits the time the step may run, in place of the
+	// Executor's Timeout, unless it is zero.
+	Timeout time.Duration
+
+	// Memory, CPUQuota, and PidsLimit, if positive, replace the limits of
+	// the container from this step on, as do those of the Executor. The
+	// container gets no swap once a step limits its Memory.
+	Memory    int64
+	CPUQuota  int64
+	PidsLimit int64
+}
+
+// runSteps runs the Executor's Steps in a container created from ref,
+// stopping at the first that fails. The result is that of the last step
+// run, with the results of every step in its Steps.
+func (e *Executor) runSteps(ctx context.Context, ref ImageRef) (res *ExecResult, err error) {
+	if e.Net == NetRestricted {
+		release, err := e.restrict(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	id := randN(16)
+	dir := e.workDir(ctx, ref.Tag)
+	if err := e.startIdle(ctx, id, ref.Tag, dir, ref.files); err != nil {
+		return nil, err
+	}
+	e.logger().Info("eggsy: container started", "container", id, "image", ref.Tag)
+	defer e.removeContainer(id)
+	e.setRunning(id)
+	defer e.setRunning("")
+	// the output of every step goes through the same writers, so that
+	// MaxOutputBytes applies to the pipeline as a whole
+	var outw, errw stepWriter
+	stdout, stderr, flush, err := e.wrapWriters(&outw, &errw, false)
+	if err != nil {
+		return nil, err
+	}
+	bctx, bud := newBudget(ctx, e.StepsBudget, e.StepsCPUBudget)
+	defer bud.stop()
+	if e.StepsCPUBudget > 0 || e.em != nil {
+		// the container idles once the steps are done, so its stats
+		// are canceled rather than waited for
+		sctx, cancel := context.WithCancel(bctx)
+		stop := e.watchStats(sctx, id, func(u *Usage) {
+			bud.use(0, u.CPU())
+			e.em.emit(statsEvent(u))
+		})
+		defer func() { cancel(); stop() }()
+	}
+	e.em.emit(Event{Type: EventStart})
+	res, err = e.execSteps(bctx, bud, id, ref.Tag, &outw, &errw, stdout, stderr)
+	flush()
+	if res == nil {
+		return nil, err
+	}
+	res.StdoutTruncated = e.outLimit != nil && e.outLimit.truncated
+	res.StderrTruncated = e.errLimit != nil && e.errLimit.truncated
+	e.em.emit(exitEvent(res.ExitCode, res.Status))
+	e.logger().Info("eggsy: container exited", "container", id, "exitCode", res.ExitCode, "status", res.Status)
+	return res, err
+}
+
+// execSteps runs the Steps in the container id, switching outw and errw
+// to the writers of each step, which stdout and stderr wrap. The steps
+// stop once bud is used up.
+func (e *Executor) execSteps(ctx context.Context, bud *budget, id, tag string, outw, errw *stepWriter, stdout, stderr io.Writer) (*ExecResult, error) {
+	res := &ExecResult{Version: SchemaVersion, Emulated: e.emulated}
+	// the cgroup is sampled between steps, so that each step's result
+	// has its own usage, and is OOMKilled only if it was
+	prev, _ := e.sampleCgroup(ctx, id, tag)
+	for _, s := range e.Steps {
+		if bud.exceeded() {
+			res.Status = StatusBudgetExceeded
+			return res, ErrBudgetExceeded
+		}
+		if s.Memory > 0 || s.CPUQuota > 0 || s.PidsLimit > 0 {
+			r := container.Resources{CPUQuota: s.CPUQuota, PidsLimit: s.PidsLimit}
+			if s.Memory > 0 {
+				r.Memory, r.MemorySwap = s.Memory, s.Memory
+			}
+			if _, err := e.cli.ContainerUpdate(ctx, id, container.UpdateConfig{Resources: r}); err != nil {
+				return nil, err
+			}
+		}
+		timeout := s.Timeout
+		if timeout == 0 {
+			timeout = e.Timeout
+		}
+		outw.w, errw.w = s.Stdout, s.Stderr
+		if outw.w == nil {
+			outw.w = e.Stdout
+		}
+		if errw.w == nil {
+			errw.w = e.Stderr
+		}
+		start := time.Now()
+		sr, err := e.exec(ctx, id, tag, s.Cmd, timeout, s.Stdin, stdout, stderr)
+		if sr == nil && bud.exceeded() {
+			// the step was canceled once the budget was used up
+			sr = &ExecResult{Version: SchemaVersion, Status: StatusBudgetExceeded, ExitCode: 137, Started: start, Finished: time.Now()}
+			err = ErrBudgetExceeded
+		}
+		if sr == nil {
+			return nil, err
+		}
+		if prev != nil && ctx.Err() == nil {
+			if cur, serr := e.sampleCgroup(ctx, id, tag); serr == nil {
+				e.stepUsage(sr, prev, cur)
+				prev = cur
+			}
+		}
+		res.Steps = append(res.Steps, sr)
+		res.Status, res.ExitCode, res.TimedOut, res.Finished = sr.Status, sr.ExitCode, sr.TimedOut, sr.Finished
+		res.OOMKilled, res.CPUTimeExceeded = sr.OOMKilled, sr.CPUTimeExceeded
+		if sr.Usage != nil {
+			if res.Usage == nil {
+				res.Usage = new(Usage)
+			}
+			res.Usage.CPUUser += sr.Usage.CPUUser
+			res.Usage.CPUSystem += sr.Usage.CPUSystem
+			if sr.Usage.MaxMemory > res.Usage.MaxMemory {
+				res.Usage.MaxMemory = sr.Usage.MaxMemory
+			}
+		}
+		if len(res.Steps) == 1 {
+			res.Started = sr.Started
+		}
+		if err != nil {
+			return res, err
+		}
+		if sr.ExitCode != 0 {
+			break
+		}
+	}
+	return res, nil
+}
+
+// stepUsage sets the Usage of the step result sr from the samples of
+// the cgroup before and after it ran, and whether the step was killed
+// for its memory or CPU time, as runImage does for a container.
+func (e *Executor) stepUsage(sr *ExecResult, prev, cur *cgroupSample) {
+	sr.Usage = cur.since(prev)
+	sr.OOMKilled = cur.oomKills > prev.oomKills
+	// a process killed by RLIMIT_CPU exits from SIGXCPU or SIGKILL
+	ec := sr.ExitCode
+	sr.CPUTimeExceeded = e.CPUTimeLimit > 0 && (ec == 128+24 || ec == 128+9) && sr.Usage.CPU() >= e.CPUTimeLimit-time.Second
+	switch {
+	case sr.Status != StatusOK:
+		// the step timed out or used up the budget first
+	case sr.OOMKilled:
+		sr.Status = StatusOOMKilled
+	case sr.CPUTimeExceeded:
+		sr.Status = StatusCPUTimeExceeded
+	}
+}
+
+// stepWriter writes to the writer of the step that is running, if any.
+// Steps run one at a time, and their output is copied before the next
+// starts.
+type stepWriter struct {
+	w io.Writer
+}
+
+func (s *stepWriter) Write(p []byte) (int, error) {
+	if s.w == nil {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
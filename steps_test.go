@@ -0,0 +1,156 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStepOutputLimit(t *testing.T) {
+	var events, first, second bytes.Buffer
+	e := &Executor{MaxOutputBytes: 5, Events: &events}
+	e.em = newEmitter(e.Events, e.EventC)
+	var outw, errw stepWriter
+	stdout, _, flush, err := e.wrapWriters(&outw, &errw, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outw.w = &first
+	io.WriteString(stdout, "abc")
+	outw.w = &second
+	io.WriteString(stdout, "defgh")
+	flush()
+	if first.String() != "abc" || second.String() != "de" {
+		t.Errorf("steps wrote %q and %q, want %q and %q", first.String(), second.String(), "abc", "de")
+	}
+	if !e.outLimit.truncated {
+		t.Error("output of the steps was not truncated")
+	}
+	if !bytes.Contains(events.Bytes(), []byte(`"data":"abc"`)) || !bytes.Contains(events.Bytes(), []byte(`"data":"de"`)) {
+		t.Errorf("events don't hold the output of every step:\n%s", events.String())
+	}
+}
+
+func TestStepMemoryLimit(t *testing.T) {
+	samples := []string{
+		"/sys/fs/cgroup/memory.events oom_kill 0\n/sys/fs/cgroup/cpu.stat user_usec 1000\n/sys/fs/cgroup/cpu.stat system_usec 0\n/sys/fs/cgroup/memory.peak 1048576\n",
+		"/sys/fs/cgroup/memory.events oom_kill 1\n/sys/fs/cgroup/cpu.stat user_usec 501000\n/sys/fs/cgroup/cpu.stat system_usec 20000\n/sys/fs/cgroup/memory.peak 67108864\n",
+	}
+	var (
+		mu      sync.Mutex
+		sampled int
+		updated bool
+		steps   []string
+	)
+	cli := fakeDaemon(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/containers/c/update"):
+			updated = true
+			fmt.Fprint(w, `{}`)
+		case strings.HasSuffix(r.URL.Path, "/containers/c/exec"):
+			var cfg struct{ Cmd []string }
+			json.NewDecoder(r.Body).Decode(&cfg)
+			if strings.HasPrefix(cfg.Cmd[2], "for f in") {
+				fmt.Fprint(w, `{"Id":"sample"}`)
+				return
+			}
+			steps = append(steps, cfg.Cmd[2])
+			fmt.Fprint(w, `{"Id":"step"}`)
+		case strings.HasSuffix(r.URL.Path, "/start"):
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+			if strings.Contains(r.URL.Path, "/sample/") && sampled < len(samples) {
+				// a frame of standard output
+				hdr := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+				binary.BigEndian.PutUint32(hdr[4:], uint32(len(samples[sampled])))
+				buf.Write(hdr)
+				buf.WriteString(samples[sampled])
+				sampled++
+			}
+			buf.Flush()
+			conn.Close()
+		case strings.HasSuffix(r.URL.Path, "/exec/sample/json"):
+			fmt.Fprint(w, `{"ExitCode":0}`)
+		case strings.HasSuffix(r.URL.Path, "/exec/step/json"):
+			// killed for its memory
+			fmt.Fprint(w, `{"ExitCode":137}`)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	})
+	e := &Executor{cli: cli, Timeout: time.Minute, Steps: []Step{{Cmd: "./alloc", Memory: 64 << 20}, {Cmd: "./never"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, bud := newBudget(ctx, 0, 0)
+	defer bud.stop()
+	var outw, errw stepWriter
+	res, err := e.execSteps(ctx, bud, "c", "img", &outw, &errw, &outw, &errw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !updated || len(steps) != 1 {
+		t.Fatalf("updated %v, ran steps %q; want the memory limited and only the first step run", updated, steps)
+	}
+	if len(res.Steps) != 1 {
+		t.Fatalf("got %d step results, want 1", len(res.Steps))
+	}
+	sr := res.Steps[0]
+	if !sr.OOMKilled || sr.Status != StatusOOMKilled || sr.ExitCode != 137 {
+		t.Errorf("step result is %v with exit code %d, OOMKilled %v; want killed for its memory", sr.Status, sr.ExitCode, sr.OOMKilled)
+	}
+	if sr.Usage == nil || sr.Usage.CPUUser != 500*time.Millisecond || sr.Usage.CPUSystem != 20*time.Millisecond || sr.Usage.MaxMemory != 64<<20 {
+		t.Errorf("step usage = %+v, want the usage between the samples", sr.Usage)
+	}
+	if res.Status != StatusOOMKilled || !res.OOMKilled || res.Usage == nil || res.Usage.CPU() != sr.Usage.CPU() {
+		t.Errorf("result is %v, OOMKilled %v, usage %+v; want those of the step", res.Status, res.OOMKilled, res.Usage)
+	}
+}
+
+func TestParseCgroupV1(t *testing.T) {
+	s := parseCgroup("/sys/fs/cgroup/memory/memory.oom_control oom_kill_disable 0\r\n" +
+		"/sys/fs/cgroup/memory/memory.oom_control oom_kill 2\r\n" +
+		"/sys/fs/cgroup/memory/memory.max_usage_in_bytes 4096\r\n" +
+		"/sys/fs/cgroup/cpuacct/cpuacct.stat user 150\r\n" +
+		"/sys/fs/cgroup/cpuacct/cpuacct.stat system 3\r\n")
+	want := cgroupSample{oomKills: 2, peak: 4096, cpuUser: 1500 * time.Millisecond, cpuSystem: 30 * time.Millisecond}
+	if *s != want {
+		t.Errorf("parseCgroup = %+v, want %+v", *s, want)
+	}
+}
@@ -0,0 +1,193 @@
+// MIT License
+
+// Copyright (c) 2018 Akhil Indurti
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package eggsy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// BackendWASM runs a WASI module named main.wasm among the Executor's Files.
+var BackendWASM = WASMBackend{Module: "main.wasm"}
+
+// WASMBackend runs the Executor's command as a WebAssembly module under
+// wazero, in the calling process, so that no container engine is needed.
+// It suits languages that compile to WASI (wasip1), whose modules can
+// only reach the files they are given and can't open sockets.
+//
+// Only Execute supports it. The Dockerfile and Image are ignored, and
+// Files are written to a temporary directory that is the module's root
+// directory, except for symbolic links, which are left out. Cmd is
+// ignored, since there is no shell: the module is run with Args as its
+// arguments, or with its path alone. Stdin, StdinPath, and the output
+// fields work as they do for containers.
+//
+// Memory is rounded down to a whole number of 64KiB pages, past which
+// the module can't grow its memory. wazero doesn't meter instructions,
+// so the module is instead interrupted once it runs for its Timeout or
+// its CPUTimeLimit, which are the same for a module that runs on a
+// single thread. Other limits don't apply.
+type WASMBackend struct {
+	// Module is the path among the Executor's Files of the module.
+	Module string
+}
+
+// ClientOpts implements Backend. It returns ErrNoDaemon.
+func (b WASMBackend) ClientOpts() ([]func(*client.Client) error, error) {
+	return nil, ErrNoDaemon
+}
+
+// wasmPageSize is the size in bytes of a page of WebAssembly memory.
+const wasmPageSize = 64 << 10
+
+// executeWASM executes the Executor's command as the module of b.
+func (e *Executor) executeWASM(ctx context.Context, b WASMBackend) (res *ExecResult, err error) {
+	dir, err := ioutil.TempDir("", "eggsy-wasm-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	err = e.extractFiles(dir)
+	stdin := e.Stdin
+	if e.stdin != nil {
+		defer e.stdin.Close()
+		stdin = e.stdin
+	}
+	if err != nil {
+		return nil, err
+	}
+	module, err := cleanPath(b.Module)
+	if err != nil {
+		return nil, err
+	}
+	bin, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(module)))
+	if err != nil {
+		return nil, err
+	}
+
+	rc := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if e.Memory > 0 {
+		pages := e.Memory / wasmPageSize
+		if pages < 1 {
+			pages = 1
+		} else if pages > 1<<16 {
+			pages = 1 << 16
+		}
+		rc = rc.WithMemoryLimitPages(uint32(pages))
+	}
+	r := wazero.NewRuntimeWithConfig(ctx, rc)
+	defer r.Close(context.Background())
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+	compiled, err := r.CompileModule(ctx, bin)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stderr, flush, err := e.wrapOutput()
+	if err != nil {
+		return nil, err
+	}
+	written := &countWriter{}
+	args := e.Args
+	if len(args) == 0 {
+		args = []string{module}
+	}
+	mc := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(args...).
+		WithStdout(io.MultiWriter(stdout, written)).
+		WithStderr(io.MultiWriter(stderr, written)).
+		WithFSConfig(wazero.NewFSConfig().WithDirMount(dir, "/")).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep()
+	if stdin != nil {
+		mc = mc.WithStdin(stdin)
+	}
+
+	limit := e.Timeout
+	if e.CPUTimeLimit > 0 && (limit < 0 || e.CPUTimeLimit < limit) {
+		limit = e.CPUTimeLimit
+	}
+	mctx := ctx
+	if limit >= 0 {
+		var cancel context.CancelFunc
+		mctx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+	e.em.emit(Event{Type: EventStart})
+	e.logger().Info("eggsy: module started", "module", module)
+	start := time.Now()
+	mod, err := r.InstantiateModule(mctx, compiled, mc)
+	if mod != nil {
+		mod.Close(context.Background())
+	}
+	flush()
+	res = &ExecResult{Version: SchemaVersion, Started: start, Finished: time.Now()}
+	var ee *sys.ExitError
+	switch {
+	case ctx.Err() != nil:
+		e.logger().Info("eggsy: execution canceled", "module", module, "err", ctx.Err())
+		return nil, &ContextError{Cmd: e.command(), Image: module, Err: ctx.Err()}
+	case mctx.Err() != nil:
+		res.ExitCode = 137
+		if e.CPUTimeLimit > 0 && limit == e.CPUTimeLimit {
+			res.Status = StatusCPUTimeExceeded
+			res.CPUTimeExceeded = true
+			res.Usage = &Usage{CPUUser: res.Duration()}
+			break
+		}
+		res.Status = StatusTimeout
+		res.TimedOut = true
+		e.em.emit(exitEvent(res.ExitCode, res.Status))
+		e.logger().Info("eggsy: module exited", "module", module, "exitCode", res.ExitCode, "status", res.Status)
+		return res, &TimeoutError{
+			Cmd:     e.command(),
+			Image:   module,
+			Timeout: e.Timeout,
+			Elapsed: res.Duration(),
+			Output:  written.n,
+		}
+	case errors.As(err, &ee):
+		res.ExitCode = int(ee.ExitCode())
+		res.Status = StatusOK
+	case err != nil:
+		// the module trapped, such as by growing its memory past Memory
+		return nil, err
+	default:
+		res.Status = StatusOK
+	}
+	e.em.emit(exitEvent(res.ExitCode, res.Status))
+	e.logger().Info("eggsy: module exited", "module", module, "exitCode", res.ExitCode, "status", res.Status)
+	return res, nil
+}